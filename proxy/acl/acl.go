@@ -0,0 +1,390 @@
+package acl
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+const (
+	//ActionAllow is rule action to allow access
+	ActionAllow = "allow"
+	//ActionDeny is rule action to deny access
+	ActionDeny = "deny"
+)
+
+// Checker is interface for check access from client to target uri
+type Checker interface {
+	Check(client, uri string) (err error)
+}
+
+// CheckerF is func to implement Checker
+type CheckerF func(client, uri string) (err error)
+
+// Check will check access by func
+func (c CheckerF) Check(client, uri string) (err error) {
+	err = c(client, uri)
+	return
+}
+
+// DeniedError is error for access is denied by rule
+type DeniedError struct {
+	Client string
+	URI    string
+	Rule   string
+}
+
+func (d *DeniedError) Error() string {
+	return fmt.Sprintf("access %v from %v is denied by rule(%v)", d.URI, d.Client, d.Rule)
+}
+
+// Code will return socks5 reply code for connection not allowed by ruleset
+func (d *DeniedError) Code() byte {
+	return 0x02
+}
+
+// IsDenied will return true if err is DeniedError
+func IsDenied(err error) (denied bool) {
+	_, denied = err.(*DeniedError)
+	return
+}
+
+// PortRange is the port range from Min to Max, both is included
+type PortRange struct {
+	Min, Max int
+}
+
+// Contains will return true if port is in range
+func (p PortRange) Contains(port int) bool {
+	return port >= p.Min && port <= p.Max
+}
+
+// Rule is one access rule to match target host/port and client ip
+type Rule struct {
+	Action  string
+	Hosts   []string
+	Nets    []*net.IPNet
+	Ports   []PortRange
+	Clients []*net.IPNet
+	Line    string
+}
+
+// ParseRule will parse rule from line, the format is action [host=pattern,...] [port=range,...] [client=cidr,...].
+// The host pattern can be ip, cidr, domain suffix like .example.com or glob like *.example.com,
+// the port range can be 80 or 8000-9000, the empty matcher is match all.
+func ParseRule(line string) (rule *Rule, err error) {
+	fields := strings.Fields(line)
+	if len(fields) < 1 {
+		err = fmt.Errorf("rule is empty")
+		return
+	}
+	rule = &Rule{Action: strings.ToLower(fields[0]), Line: strings.Join(fields, " ")}
+	if rule.Action != ActionAllow && rule.Action != ActionDeny {
+		err = fmt.Errorf("rule(%v) action %v is not supported", line, fields[0])
+		return
+	}
+	for _, field := range fields[1:] {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) < 2 {
+			if field == "*" {
+				continue
+			}
+			err = fmt.Errorf("rule(%v) field %v is invalid", line, field)
+			return
+		}
+		for _, value := range strings.Split(parts[1], ",") {
+			if len(value) < 1 {
+				continue
+			}
+			switch parts[0] {
+			case "host":
+				if ipnet := parseNet(value); ipnet != nil {
+					rule.Nets = append(rule.Nets, ipnet)
+				} else {
+					rule.Hosts = append(rule.Hosts, strings.ToLower(value))
+				}
+			case "port":
+				var ports PortRange
+				ports, err = ParsePortRange(value)
+				if err != nil {
+					err = fmt.Errorf("rule(%v) port %v is invalid", line, value)
+					return
+				}
+				rule.Ports = append(rule.Ports, ports)
+			case "client":
+				ipnet := parseNet(value)
+				if ipnet == nil {
+					err = fmt.Errorf("rule(%v) client %v is invalid", line, value)
+					return
+				}
+				rule.Clients = append(rule.Clients, ipnet)
+			default:
+				err = fmt.Errorf("rule(%v) field %v is not supported", line, parts[0])
+				return
+			}
+		}
+	}
+	return
+}
+
+func parseNet(value string) (ipnet *net.IPNet) {
+	if strings.Contains(value, "/") {
+		_, ipnet, _ = net.ParseCIDR(value)
+		return
+	}
+	if ip := net.ParseIP(value); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			ipnet = &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}
+		} else {
+			ipnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}
+		}
+	}
+	return
+}
+
+// ParsePortRange will parse port range from value like 80 or 8000-9000
+func ParsePortRange(value string) (ports PortRange, err error) {
+	parts := strings.SplitN(value, "-", 2)
+	ports.Min, err = strconv.Atoi(parts[0])
+	if err == nil && len(parts) > 1 {
+		ports.Max, err = strconv.Atoi(parts[1])
+	} else {
+		ports.Max = ports.Min
+	}
+	if err == nil && (ports.Min < 0 || ports.Max > 65535 || ports.Min > ports.Max) {
+		err = fmt.Errorf("invalid port range")
+	}
+	return
+}
+
+func matchNets(nets []*net.IPNet, ips []net.IP) bool {
+	for _, ipnet := range nets {
+		for _, ip := range ips {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchHost(pattern, host string) bool {
+	if pattern == "*" || pattern == host {
+		return true
+	}
+	if strings.HasPrefix(pattern, ".") {
+		return strings.HasSuffix(host, pattern) || host == pattern[1:]
+	}
+	matched, _ := path.Match(pattern, host)
+	return matched
+}
+
+// Match will return true if rule is matched client ip and target host/port,
+// the resolve is called to get target ip when host is domain and rule having cidr
+func (r *Rule) Match(clientIP net.IP, host string, port int, resolve func(host string) []net.IP) bool {
+	if len(r.Clients) > 0 && (clientIP == nil || !matchNets(r.Clients, []net.IP{clientIP})) {
+		return false
+	}
+	if len(r.Ports) > 0 {
+		matched := false
+		for _, ports := range r.Ports {
+			if ports.Contains(port) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	if len(r.Hosts) < 1 && len(r.Nets) < 1 {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, pattern := range r.Hosts {
+		if matchHost(pattern, host) {
+			return true
+		}
+	}
+	if len(r.Nets) > 0 {
+		var ips []net.IP
+		if ip := net.ParseIP(host); ip != nil {
+			ips = []net.IP{ip}
+		} else if resolve != nil {
+			ips = resolve(host)
+		}
+		if matchNets(r.Nets, ips) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) String() string {
+	return r.Line
+}
+
+// ACL is Checker implement by rule list, the first matched rule is used and Default is used when not rule matched,
+// the domain host is resolved to match the cidr rule when Resolve is true, it is true by NewACL
+type ACL struct {
+	Default string
+	Resolve bool
+	rules   []*Rule
+	lock    sync.RWMutex
+}
+
+// NewACL will return new ACL with allow default action
+func NewACL() (acl *ACL) {
+	acl = &ACL{
+		Default: ActionAllow,
+		Resolve: true,
+		lock:    sync.RWMutex{},
+	}
+	return
+}
+
+// Rules will return current rules
+func (a *ACL) Rules() (rules []*Rule) {
+	a.lock.RLock()
+	rules = a.rules
+	a.lock.RUnlock()
+	return
+}
+
+// SetRules will replace all rules
+func (a *ACL) SetRules(rules []*Rule) {
+	a.lock.Lock()
+	a.rules = rules
+	a.lock.Unlock()
+}
+
+// Load will parse rules from lines and replace all rules, the empty line and # comment is skipped
+func (a *ACL) Load(lines ...string) (err error) {
+	rules := []*Rule{}
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if len(line) < 1 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var rule *Rule
+		rule, err = ParseRule(line)
+		if err != nil {
+			return
+		}
+		rules = append(rules, rule)
+	}
+	a.SetRules(rules)
+	return
+}
+
+// LoadString will parse rules from string and replace all rules
+func (a *ACL) LoadString(data string) (err error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(strings.NewReader(data))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	err = a.Load(lines...)
+	return
+}
+
+// LoadFile will parse rules from file and replace all rules
+func (a *ACL) LoadFile(filename string) (err error) {
+	data, err := ioutil.ReadFile(filename)
+	if err == nil {
+		err = a.LoadString(string(data))
+	}
+	return
+}
+
+func (a *ACL) resolve(host string) (ips []net.IP) {
+	if a.Resolve {
+		ips, _ = net.LookupIP(host)
+	}
+	return
+}
+
+// Check will check access from client to uri, the client is address like 127.0.0.1:1000 and uri is like tcp://host:port
+func (a *ACL) Check(client, uri string) (err error) {
+	var clientIP net.IP
+	if clientHost, _, xerr := net.SplitHostPort(client); xerr == nil {
+		clientIP = net.ParseIP(clientHost)
+	} else {
+		clientIP = net.ParseIP(client)
+	}
+	host, port := SplitURI(uri)
+	action, matched := a.Default, "default"
+	for _, rule := range a.Rules() {
+		if rule.Match(clientIP, host, port, a.resolve) {
+			action, matched = rule.Action, rule.Line
+			break
+		}
+	}
+	if action == ActionDeny {
+		err = &DeniedError{Client: client, URI: uri, Rule: matched}
+	}
+	return
+}
+
+// SplitURI will split uri like tcp://host:port to host and port
+func SplitURI(uri string) (host string, port int) {
+	address := uri
+	if parts := strings.SplitN(uri, "://", 2); len(parts) > 1 {
+		address = parts[1]
+	}
+	if index := strings.IndexAny(address, "/?"); index >= 0 {
+		address = address[:index]
+	}
+	h, p, err := net.SplitHostPort(address)
+	if err != nil {
+		host = strings.Trim(address, "[]")
+		return
+	}
+	host = h
+	port, _ = strconv.Atoi(p)
+	return
+}
+
+// Dialer is xio.PiperDialer implement to check access before dial by next, the client is read from ctx by xio.ClientFromContext
+// and Client is used when ctx is not carrying client
+type Dialer struct {
+	Checker
+	Client string
+	Next   xio.PiperDialer
+}
+
+// NewDialer will return new Dialer
+func NewDialer(checker Checker, next xio.PiperDialer) (dialer *Dialer) {
+	dialer = &Dialer{
+		Checker: checker,
+		Next:    next,
+	}
+	return
+}
+
+// DialPiper will check access and dial by next
+func (d *Dialer) DialPiper(uri string, bufferSize int) (raw xio.Piper, err error) {
+	raw, err = d.DialPiperContext(context.Background(), uri, bufferSize)
+	return
+}
+
+// DialPiperContext will check access and dial by next with context
+func (d *Dialer) DialPiperContext(ctx context.Context, uri string, bufferSize int) (raw xio.Piper, err error) {
+	client := xio.ClientFromContext(ctx)
+	if len(client) < 1 {
+		client = d.Client
+	}
+	err = d.Check(client, uri)
+	if err == nil {
+		raw, err = xio.DialPiperContext(ctx, d.Next, uri, bufferSize)
+	}
+	return
+}
@@ -0,0 +1,169 @@
+package acl
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+func TestACL(t *testing.T) {
+	acl := NewACL()
+	err := acl.LoadString(`
+#comment
+deny host=10.0.0.0/8,.blocked.com
+allow host=*.internal.com port=80,8000-9000 client=192.168.0.0/16
+deny host=*.internal.com
+deny port=25
+allow client=127.0.0.1 host=::1
+deny host=::1
+	`)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if len(acl.Rules()) != 6 {
+		t.Error("error")
+		return
+	}
+	for _, c := range []struct {
+		Client string
+		URI    string
+		Denied bool
+	}{
+		{Client: "127.0.0.1:100", URI: "tcp://10.1.1.1:80", Denied: true},
+		{Client: "127.0.0.1:100", URI: "tcp://11.1.1.1:80", Denied: false},
+		{Client: "127.0.0.1:100", URI: "tcp://blocked.com:80", Denied: true},
+		{Client: "127.0.0.1:100", URI: "tcp://a.blocked.com:443", Denied: true},
+		{Client: "127.0.0.1:100", URI: "tcp://ablocked.com:443", Denied: false},
+		{Client: "192.168.1.1:100", URI: "tcp://a.internal.com:80", Denied: false},
+		{Client: "192.168.1.1:100", URI: "tcp://a.internal.com:8080", Denied: false},
+		{Client: "192.168.1.1:100", URI: "tcp://a.internal.com:22", Denied: true},
+		{Client: "172.16.1.1:100", URI: "tcp://a.internal.com:80", Denied: true},
+		{Client: "", URI: "tcp://a.internal.com:80", Denied: true},
+		{Client: "172.16.1.1:100", URI: "tcp://mail.com:25", Denied: true},
+		{Client: "127.0.0.1", URI: "tcp://[::1]:80", Denied: false},
+		{Client: "127.0.0.2:100", URI: "tcp://[::1]:80", Denied: true},
+		{Client: "127.0.0.2:100", URI: "127.0.0.1:80", Denied: false},
+		{Client: "127.0.0.2:100", URI: "10.0.0.1", Denied: true},
+		{Client: "127.0.0.2:100", URI: "ws://10.0.0.1:80/path?a=1", Denied: true},
+	} {
+		err := acl.Check(c.Client, c.URI)
+		if IsDenied(err) != c.Denied {
+			t.Errorf("%v->%v expect %v, but %v", c.Client, c.URI, c.Denied, err)
+			return
+		}
+	}
+	{ //default
+		acl.Default = ActionDeny
+		err = acl.Check("127.0.0.1:100", "tcp://11.1.1.1:80")
+		if !IsDenied(err) || len(err.Error()) < 1 || err.(*DeniedError).Code() != 0x02 {
+			t.Error(err)
+			return
+		}
+		acl.Default = ActionAllow
+	}
+	{ //resolve
+		acl.Resolve = true
+		acl.Load("deny host=127.0.0.0/8")
+		err = acl.Check("127.0.0.1:100", "tcp://localhost:80")
+		if !IsDenied(err) {
+			t.Error(err)
+			return
+		}
+		acl.Resolve = false
+		err = acl.Check("127.0.0.1:100", "tcp://localhost:80")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //file
+		filename := filepath.Join(os.TempDir(), "acl_test.rules")
+		ioutil.WriteFile(filename, []byte("deny *\n"), os.ModePerm)
+		defer os.Remove(filename)
+		err = acl.LoadFile(filename)
+		if err != nil || acl.Rules()[0].String() != "deny *" {
+			t.Error(err)
+			return
+		}
+		err = acl.Check("127.0.0.1:100", "tcp://localhost:80")
+		if !IsDenied(err) {
+			t.Error(err)
+			return
+		}
+		err = acl.LoadFile("not_exists.rules")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //error
+		for _, line := range []string{"", "xx", "allow xx", "allow port=x", "allow port=10-1", "allow client=xx", "allow a=1"} {
+			_, err = ParseRule(line)
+			if err == nil {
+				t.Error(line)
+				return
+			}
+		}
+		err = acl.Load("xx")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestDialer(t *testing.T) {
+	acl := NewACL()
+	acl.Load("deny host=10.0.0.0/8")
+	dialer := NewDialer(acl, xio.NewEchoDialer())
+	_, err := dialer.DialPiper("tcp://127.0.0.1:80", 1024)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.DialPiper("tcp://10.0.0.1:80", 1024)
+	if !IsDenied(err) {
+		t.Error(err)
+		return
+	}
+	//resolve by default
+	acl.Load("deny host=127.0.0.0/8")
+	_, err = dialer.DialPiper("tcp://localhost:80", 1024)
+	if !IsDenied(err) {
+		t.Error(err)
+		return
+	}
+	//client from context
+	acl.Load("deny client=192.168.1.0/24")
+	_, err = dialer.DialPiperContext(xio.ContextWithClient(context.Background(), "192.168.1.10:1000"), "tcp://127.0.0.1:80", 1024)
+	if !IsDenied(err) || err.(*DeniedError).Client != "192.168.1.10:1000" {
+		t.Error(err)
+		return
+	}
+	_, err = xio.DialPiperClient("192.168.2.10:1000", dialer, "tcp://127.0.0.1:80", 1024)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dialer.Client = "192.168.1.11:1000"
+	_, err = dialer.DialPiper("tcp://127.0.0.1:80", 1024)
+	if !IsDenied(err) {
+		t.Error(err)
+		return
+	}
+	dialer.Checker = CheckerF(func(client, uri string) (err error) {
+		err = fmt.Errorf("test error")
+		return
+	})
+	_, err = dialer.DialPiper("tcp://127.0.0.1:80", 1024)
+	if err == nil || IsDenied(err) {
+		t.Error(err)
+		return
+	}
+}
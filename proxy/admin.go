@@ -0,0 +1,243 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/codingeasygo/util/xmap"
+)
+
+// Admin is http.Handler to manage forward and proxy session by token, it can be mounted on any path prefix of mux.
+// The token is checked by Authorization: Bearer <token> header only, the token query is not accepted to avoid leaking token to access log,
+// all request is denied when token is empty.
+//
+//	GET  <prefix>/forward/list
+//	POST <prefix>/forward/start?name=xx&listen=tcp://:8080&router=tcp://127.0.0.1:80
+//	POST <prefix>/forward/stop?name=xx
+//	GET  <prefix>/session/list[?source=forward]
+//	POST <prefix>/session/kill?source=forward&id=1
+//	GET  <prefix>/monitor[?source=forward]
+type Admin struct {
+	Token    string
+	Forward  *Forward
+	sessions map[string]*SessionManager
+	lock     sync.RWMutex
+}
+
+// NewAdmin will return new Admin, the forward sessions is added as forward source when forward is not nil
+func NewAdmin(token string, forward *Forward) (admin *Admin) {
+	admin = &Admin{
+		Token:    token,
+		Forward:  forward,
+		sessions: map[string]*SessionManager{},
+		lock:     sync.RWMutex{},
+	}
+	if forward != nil && forward.Sessions != nil {
+		admin.AddSessions("forward", forward.Sessions)
+	}
+	return
+}
+
+// AddSessions will add session manager as source by name, like server.Sessions of proxy.Server
+func (a *Admin) AddSessions(source string, sessions *SessionManager) {
+	a.lock.Lock()
+	a.sessions[source] = sessions
+	a.lock.Unlock()
+}
+
+func (a *Admin) findSessions(source string) (found map[string]*SessionManager, err error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	found = map[string]*SessionManager{}
+	if len(source) > 0 {
+		sessions := a.sessions[source]
+		if sessions == nil {
+			err = fmt.Errorf("session source %v is not exists", source)
+			return
+		}
+		found[source] = sessions
+		return
+	}
+	for name, sessions := range a.sessions {
+		found[name] = sessions
+	}
+	return
+}
+
+func (a *Admin) checkToken(req *http.Request) bool {
+	if len(a.Token) < 1 {
+		return false
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(a.Token)) == 1
+}
+
+func (a *Admin) writeJSON(w http.ResponseWriter, status int, result interface{}) {
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+func (a *Admin) writeError(w http.ResponseWriter, status int, err error) {
+	a.writeJSON(w, status, xmap.M{"code": status, "message": err.Error()})
+}
+
+// ServeHTTP is http.Handler implement
+func (a *Admin) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !a.checkToken(req) {
+		a.writeError(w, http.StatusUnauthorized, fmt.Errorf("token is invalid"))
+		return
+	}
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	var action func(w http.ResponseWriter, req *http.Request)
+	method := http.MethodPost
+	switch {
+	case strings.HasSuffix(path, "/forward/list"):
+		action, method = a.listForward, http.MethodGet
+	case strings.HasSuffix(path, "/forward/start"):
+		action = a.startForward
+	case strings.HasSuffix(path, "/forward/stop"):
+		action = a.stopForward
+	case strings.HasSuffix(path, "/session/list"):
+		action, method = a.listSession, http.MethodGet
+	case strings.HasSuffix(path, "/session/kill"):
+		action = a.killSession
+	case strings.HasSuffix(path, "/monitor"):
+		action, method = a.monitorState, http.MethodGet
+	default:
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("%v is not found", req.URL.Path))
+		return
+	}
+	if req.Method != method {
+		a.writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("method %v is not allowed", req.Method))
+		return
+	}
+	action(w, req)
+}
+
+func (a *Admin) listForward(w http.ResponseWriter, req *http.Request) {
+	if a.Forward == nil {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("forward is not setted"))
+		return
+	}
+	forwards := a.Forward.List()
+	if forwards == nil {
+		forwards = []*ForwardInfo{}
+	}
+	a.writeJSON(w, http.StatusOK, xmap.M{"code": 0, "forwards": forwards})
+}
+
+func (a *Admin) startForward(w http.ResponseWriter, req *http.Request) {
+	if a.Forward == nil {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("forward is not setted"))
+		return
+	}
+	req.ParseForm()
+	name, listen, router := req.Form.Get("name"), req.Form.Get("listen"), req.Form.Get("router")
+	listenURL, err := url.Parse(listen)
+	if err != nil || len(name) < 1 || len(listenURL.Scheme) < 1 || len(router) < 1 {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("name/listen/router is required and listen must be uri"))
+		return
+	}
+	listener, err := a.Forward.StartForward(name, listenURL, router)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	InfoLog("Admin start forward %v by %v->%v from %v", name, listen, router, req.RemoteAddr)
+	a.writeJSON(w, http.StatusOK, xmap.M{"code": 0, "address": listener.Addr().String()})
+}
+
+func (a *Admin) stopForward(w http.ResponseWriter, req *http.Request) {
+	if a.Forward == nil {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("forward is not setted"))
+		return
+	}
+	req.ParseForm()
+	name := req.Form.Get("name")
+	found := false
+	for _, forward := range a.Forward.List() {
+		if forward.Name == name {
+			found = true
+			break
+		}
+	}
+	if !found {
+		a.writeError(w, http.StatusNotFound, fmt.Errorf("forward %v is not exists", name))
+		return
+	}
+	err := a.Forward.StopForward(name)
+	if err != nil {
+		a.writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	InfoLog("Admin stop forward %v from %v", name, req.RemoteAddr)
+	a.writeJSON(w, http.StatusOK, xmap.M{"code": 0})
+}
+
+func (a *Admin) listSession(w http.ResponseWriter, req *http.Request) {
+	found, err := a.findSessions(req.URL.Query().Get("source"))
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	sessions := xmap.M{}
+	for name, manager := range found {
+		list := manager.List()
+		if list == nil {
+			list = []*SessionInfo{}
+		}
+		sessions[name] = list
+	}
+	a.writeJSON(w, http.StatusOK, xmap.M{"code": 0, "sessions": sessions})
+}
+
+func (a *Admin) killSession(w http.ResponseWriter, req *http.Request) {
+	req.ParseForm()
+	source := req.Form.Get("source")
+	id, err := strconv.ParseUint(req.Form.Get("id"), 10, 64)
+	if err != nil || len(source) < 1 {
+		a.writeError(w, http.StatusBadRequest, fmt.Errorf("source/id is required"))
+		return
+	}
+	found, err := a.findSessions(source)
+	if err == nil {
+		err = found[source].Kill(id)
+	}
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	InfoLog("Admin kill session %v/%v from %v", source, id, req.RemoteAddr)
+	a.writeJSON(w, http.StatusOK, xmap.M{"code": 0})
+}
+
+func (a *Admin) monitorState(w http.ResponseWriter, req *http.Request) {
+	found, err := a.findSessions(req.URL.Query().Get("source"))
+	if err != nil {
+		a.writeError(w, http.StatusNotFound, err)
+		return
+	}
+	monitors := xmap.M{}
+	for name, manager := range found {
+		if manager.Monitor == nil {
+			continue
+		}
+		monitors[name], err = manager.Monitor.State()
+		if err != nil {
+			a.writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	a.writeJSON(w, http.StatusOK, xmap.M{"code": 0, "monitor": monitors})
+}
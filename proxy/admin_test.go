@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xmap"
+)
+
+func TestAdmin(t *testing.T) {
+	forward := NewForward("Test")
+	forward.Dialer = xio.NewEchoDialer()
+	defer forward.Stop()
+	server := NewServer(xio.NewEchoDialer())
+	admin := NewAdmin("123", forward)
+	admin.AddSessions("server", server.Sessions)
+	mux := http.NewServeMux()
+	mux.Handle("/admin/", admin)
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	call := func(method, path string, form url.Values, token string) (status int, result xmap.M) {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if len(token) > 0 {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer resp.Body.Close()
+		status = resp.StatusCode
+		result = xmap.M{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return
+	}
+	{ //token
+		if status, _ := call("GET", "/admin/forward/list", nil, ""); status != http.StatusUnauthorized {
+			t.Error(status)
+			return
+		}
+		if status, _ := call("GET", "/admin/forward/list", nil, "xxx"); status != http.StatusUnauthorized {
+			t.Error(status)
+			return
+		}
+		if status, _ := call("GET", "/admin/forward/list?token=123", nil, ""); status != http.StatusUnauthorized {
+			t.Error(status)
+			return
+		}
+		if status, _ := call("GET", "/admin/forward/list", nil, "123"); status != http.StatusOK {
+			t.Error(status)
+			return
+		}
+	}
+	{ //forward
+		status, result := call("POST", "/admin/forward/start", url.Values{"name": {"a"}, "listen": {"tcp://127.0.0.1:0"}, "router": {"tcp://echo"}}, "123")
+		if status != http.StatusOK || len(result.Str("address")) < 1 {
+			t.Errorf("%v,%v", status, result)
+			return
+		}
+		address := result.Str("address")
+		status, result = call("GET", "/admin/forward/list", nil, "123")
+		if status != http.StatusOK || len(result.ArrayMapDef(nil, "forwards")) != 1 {
+			t.Errorf("%v,%v", status, result)
+			return
+		}
+		//session
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 1024)
+		conn.Read(buf)
+		status, result = call("GET", "/admin/session/list", nil, "123")
+		sessions := result.ArrayMapDef(nil, "sessions/forward")
+		if status != http.StatusOK || len(sessions) != 1 || len(result.ArrayMapDef(nil, "sessions/server")) != 0 {
+			t.Errorf("%v,%v", status, result)
+			return
+		}
+		status, result = call("GET", "/admin/monitor?source=forward", nil, "123")
+		if status != http.StatusOK || result.MapDef(nil, "monitor/forward") == nil {
+			t.Errorf("%v,%v", status, result)
+			return
+		}
+		id := fmt.Sprintf("%v", sessions[0].Int64Def(0, "id"))
+		status, _ = call("POST", "/admin/session/kill", url.Values{"source": {"forward"}, "id": {id}}, "123")
+		if status != http.StatusOK {
+			t.Error(status)
+			return
+		}
+		if _, err = conn.Read(buf); err == nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+		status, _ = call("POST", "/admin/forward/stop", url.Values{"name": {"a"}}, "123")
+		if status != http.StatusOK {
+			t.Error(status)
+			return
+		}
+	}
+	{ //error
+		for _, c := range []struct {
+			Method string
+			Path   string
+			Form   url.Values
+			Status int
+		}{
+			{Method: "GET", Path: "/admin/none", Status: http.StatusNotFound},
+			{Method: "GET", Path: "/admin/forward/start", Status: http.StatusMethodNotAllowed},
+			{Method: "POST", Path: "/admin/forward/start", Form: url.Values{"name": {"a"}}, Status: http.StatusBadRequest},
+			{Method: "POST", Path: "/admin/forward/start", Form: url.Values{"name": {"a"}, "listen": {"xx://127.0.0.1:0"}, "router": {"tcp://echo"}}, Status: http.StatusInternalServerError},
+			{Method: "POST", Path: "/admin/forward/stop", Form: url.Values{"name": {"none"}}, Status: http.StatusNotFound},
+			{Method: "GET", Path: "/admin/session/list?source=none", Status: http.StatusNotFound},
+			{Method: "POST", Path: "/admin/session/kill", Form: url.Values{"source": {"forward"}}, Status: http.StatusBadRequest},
+			{Method: "POST", Path: "/admin/session/kill", Form: url.Values{"source": {"forward"}, "id": {"100"}}, Status: http.StatusNotFound},
+			{Method: "GET", Path: "/admin/monitor?source=none", Status: http.StatusNotFound},
+		} {
+			if status, result := call(c.Method, c.Path, c.Form, "123"); status != c.Status {
+				t.Errorf("%v expect %v, but %v by %v", c.Path, c.Status, status, result)
+				return
+			}
+		}
+		empty := NewAdmin("123", nil)
+		for _, path := range []string{"/forward/list", "/forward/start", "/forward/stop"} {
+			req := httptest.NewRequest("POST", path, nil)
+			if path == "/forward/list" {
+				req.Method = "GET"
+			}
+			req.Header.Set("Authorization", "Bearer 123")
+			w := httptest.NewRecorder()
+			empty.ServeHTTP(w, req)
+			if w.Code != http.StatusNotFound {
+				t.Error(w.Code)
+				return
+			}
+		}
+		w := httptest.NewRecorder()
+		NewAdmin("", forward).ServeHTTP(w, httptest.NewRequest("GET", "/forward/list?token=", nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Error(w.Code)
+			return
+		}
+	}
+}
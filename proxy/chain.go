@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/codingeasygo/util/proxy/http"
+	"github.com/codingeasygo/util/proxy/socks"
+	"github.com/codingeasygo/util/proxy/ws"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xnet"
+)
+
+// ChainDialer is PiperDialer to dial target through upstream proxy chain,
+// the supported proxy is socks5://[user:pass@]host:port, http://[user:pass@]host:port and ws(s)://host:port/path
+type ChainDialer struct {
+	Proxies []string
+	Dialer  xnet.RawDialer
+}
+
+// NewChainDialer will return new ChainDialer by proxy uri list, the first proxy is dialed directly
+func NewChainDialer(proxies ...string) (dialer *ChainDialer, err error) {
+	for _, proxy := range proxies {
+		u, xerr := url.Parse(proxy)
+		if xerr != nil {
+			err = xerr
+			return
+		}
+		switch u.Scheme {
+		case "socks5", "socks", "http", "ws", "wss":
+		default:
+			err = fmt.Errorf("not supported proxy %v", proxy)
+			return
+		}
+	}
+	dialer = &ChainDialer{
+		Proxies: proxies,
+		Dialer:  &net.Dialer{},
+	}
+	return
+}
+
+// ParseChainDialer will return new ChainDialer by proxy uri list which is splited by comma
+func ParseChainDialer(proxies string) (dialer *ChainDialer, err error) {
+	uris := []string{}
+	for _, proxy := range strings.Split(proxies, ",") {
+		proxy = strings.TrimSpace(proxy)
+		if len(proxy) > 0 {
+			uris = append(uris, proxy)
+		}
+	}
+	dialer, err = NewChainDialer(uris...)
+	return
+}
+
+// Dial will dial address through all proxy, it is xnet.RawDialer implement
+func (c *ChainDialer) Dial(network, address string) (conn net.Conn, err error) {
+	conn, err = c.DialContext(context.Background(), network, address)
+	return
+}
+
+// DialContext will dial address through all proxy with context, it is xnet.RawContextDialer implement
+func (c *ChainDialer) DialContext(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	if len(c.Proxies) < 1 {
+		conn, err = xnet.DialRawContext(ctx, c.Dialer, network, address)
+		return
+	}
+	if network != "tcp" {
+		err = fmt.Errorf("not supported network %v by proxy chain", network)
+		return
+	}
+	last := c.Proxies[len(c.Proxies)-1]
+	prev := &ChainDialer{Proxies: c.Proxies[:len(c.Proxies)-1], Dialer: c.Dialer}
+	switch {
+	case strings.HasPrefix(last, "socks5://"):
+		conn, err = socks.DialByContext(ctx, prev, last, address)
+	case strings.HasPrefix(last, "socks://"):
+		conn, err = socks.DialByContext(ctx, prev, "socks5://"+strings.TrimPrefix(last, "socks://"), address)
+	case strings.HasPrefix(last, "http://"):
+		conn, err = http.DialByContext(ctx, prev, last, address)
+	case strings.HasPrefix(last, "ws://") || strings.HasPrefix(last, "wss://"):
+		conn, err = ws.DialByContext(ctx, prev, last, address)
+	default:
+		err = fmt.Errorf("not supported proxy %v", last)
+	}
+	if err != nil {
+		WarnLog("ChainDialer dial to %v by %v fail with %v", address, last, err)
+	}
+	return
+}
+
+// DialPiper will dial uri through all proxy and return xio.NetPiper, it is xio.PiperDialer implement
+func (c *ChainDialer) DialPiper(uri string, bufferSize int) (piper xio.Piper, err error) {
+	piper, err = c.DialPiperContext(context.Background(), uri, bufferSize)
+	return
+}
+
+// DialPiperContext will dial uri through all proxy with context, it is xio.PiperContextDialer implement
+func (c *ChainDialer) DialPiperContext(ctx context.Context, uri string, bufferSize int) (piper xio.Piper, err error) {
+	network, address := "tcp", uri
+	if parts := strings.SplitN(uri, "://", 2); len(parts) == 2 {
+		network, address = parts[0], parts[1]
+	}
+	conn, err := c.DialContext(ctx, network, address)
+	if err == nil {
+		piper = &xio.NetPiper{
+			Conn: conn,
+			CopyPiper: xio.CopyPiper{
+				ReadWriteCloser: conn,
+				BufferSize:      bufferSize,
+			},
+		}
+	}
+	return
+}
@@ -0,0 +1,183 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/proxy/http"
+	"github.com/codingeasygo/util/proxy/socks"
+	"github.com/codingeasygo/util/proxy/ws"
+	"github.com/codingeasygo/util/xio"
+)
+
+func TestChainDialer(t *testing.T) {
+	socksServer := socks.NewServer()
+	socksServer.Auth = socks.StaticAuthenticator{"u1": "p1"}
+	socksListener, err := socksServer.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer socksServer.Stop()
+	httpServer := http.NewServer()
+	httpServer.Auth = http.AuthenticatorF(func(username, password string) (err error) {
+		if username != "u2" || password != "p2" {
+			err = fmt.Errorf("invalid")
+		}
+		return
+	})
+	httpListener, err := httpServer.Start("127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer httpServer.Stop()
+	wsServer := ws.NewServer()
+	wsServer.Dialer = xio.NewEchoDialer()
+	wsListener, err := wsServer.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer wsServer.Stop()
+	testEcho := func(conn net.Conn, err error) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "abc" {
+			t.Error(err)
+			return
+		}
+	}
+	dialer, err := ParseChainDialer(fmt.Sprintf("socks5://u1:p1@%v, http://u2:p2@%v,ws://%v", socksListener.Addr(), httpListener.Addr(), wsListener.Addr()))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testEcho(dialer.Dial("tcp", "127.0.0.1:80"))
+	{ //piper
+		piper, err := dialer.DialPiper("tcp://127.0.0.1:80", 1024)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server := NewServer(xio.PiperDialerF(func(uri string, bufferSize int) (xio.Piper, error) { return piper, nil }))
+		listener, _ := server.Start("tcp", "127.0.0.1:0")
+		testEcho(socks.Dial(listener.Addr().String(), "127.0.0.1:80"))
+		server.Close()
+		listener.Close()
+		server.Wait()
+	}
+	{ //socks alias
+		dialer, _ := NewChainDialer(fmt.Sprintf("socks://u1:p1@%v", socksListener.Addr()), fmt.Sprintf("ws://%v", wsListener.Addr()))
+		testEcho(dialer.Dial("tcp", "127.0.0.1:80"))
+	}
+	{ //direct
+		dialer, _ := NewChainDialer()
+		dialer.Dialer = xio.NewEchoDialer()
+		testEcho(dialer.Dial("tcp", "127.0.0.1:80"))
+	}
+	{ //error
+		_, err = NewChainDialer("xx://127.0.0.1")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = NewChainDialer("%zz://127.0.0.1")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = dialer.Dial("udp", "127.0.0.1:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = dialer.DialPiper("udp://127.0.0.1:80", 1024)
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = (&ChainDialer{Proxies: []string{"xx://127.0.0.1"}}).Dial("tcp", "127.0.0.1:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		dialer, _ := NewChainDialer(fmt.Sprintf("socks5://u1:xx@%v", socksListener.Addr()))
+		_, err = dialer.Dial("tcp", "127.0.0.1:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestChainDialerContext(t *testing.T) {
+	blackhole, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer blackhole.Close()
+	go func() {
+		for {
+			conn, err := blackhole.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	socksServer := socks.NewServer()
+	socksServer.Dialer = xio.NewEchoDialer()
+	socksListener, err := socksServer.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer socksServer.Stop()
+	for _, proxy := range []string{"socks5://", "http://", "ws://"} {
+		dialer, _ := NewChainDialer(proxy + blackhole.Addr().String())
+		begin := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err = dialer.DialContext(ctx, "tcp", "127.0.0.1:80")
+		cancel()
+		if err == nil || time.Since(begin) > time.Second {
+			t.Errorf("%v,%v", proxy, err)
+			return
+		}
+	}
+	dialer, _ := NewChainDialer("socks5://" + socksListener.Addr().String())
+	conn, err := dialer.DialContext(context.Background(), "tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	piper, err := dialer.DialPiperContext(context.Background(), "tcp://127.0.0.1:80", 1024)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	piper.Close()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = dialer.DialPiperContext(canceled, "tcp://127.0.0.1:80", 1024); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = socks.DialContext(canceled, socksListener.Addr().String(), "127.0.0.1:80"); err == nil {
+		t.Error(err)
+		return
+	}
+	router := &RouterPiperDialer{Router: "${HOST}", Next: dialer}
+	if _, err = xio.DialPiperContext(canceled, router, "tcp://127.0.0.1:80", 1024); err == nil {
+		t.Error(err)
+		return
+	}
+}
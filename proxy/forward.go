@@ -1,12 +1,16 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
-	"io"
 	"net"
 	"net/url"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/codingeasygo/util/proxy/socks"
 	"github.com/codingeasygo/util/xdebug"
@@ -19,7 +23,81 @@ type RouterPiperDialer struct {
 }
 
 func (r *RouterPiperDialer) DialPiper(uri string, bufferSize int) (raw xio.Piper, err error) {
-	raw, err = r.Next.DialPiper(strings.Replace(r.Router, "${HOST}", uri, -1), bufferSize)
+	raw, err = r.DialPiperContext(context.Background(), uri, bufferSize)
+	return
+}
+
+// DialPiperContext will dial the router which ${HOST} is replaced by uri with context
+func (r *RouterPiperDialer) DialPiperContext(ctx context.Context, uri string, bufferSize int) (raw xio.Piper, err error) {
+	raw, err = xio.DialPiperContext(ctx, r.Next, strings.Replace(r.Router, "${HOST}", uri, -1), bufferSize)
+	return
+}
+
+// ForwardInfo is the info of one running forward
+type ForwardInfo struct {
+	Name    string `json:"name"`
+	Scheme  string `json:"scheme"`
+	Listen  string `json:"listen"`
+	Address string `json:"address"`
+	Router  string `json:"router"`
+	Active  int64  `json:"active"`
+}
+
+// shutdowner is server can be shutdown gracefully, like socks.Server and Server
+type shutdowner interface {
+	Shutdown(ctx context.Context) (drained, killed int, err error)
+}
+
+type forwardEntry struct {
+	name     string
+	listen   *url.URL
+	router   string
+	listener net.Listener
+	server   shutdowner
+	conns    *xio.ConnGroup
+	active   int64
+}
+
+// shutdown will stop the entry listener and drain running connection until ctx is done,
+// the udp session can not be drained after listener closed, so it is killed directly
+func (e *forwardEntry) shutdown(ctx context.Context) (drained, killed int, err error) {
+	switch {
+	case e.server != nil:
+		drained, killed, err = e.server.Shutdown(ctx)
+	case strings.HasPrefix(e.listen.Scheme, "udp"):
+		killed = e.conns.Size()
+		e.listener.Close()
+		e.conns.Shutdown(ctx)
+	default:
+		e.listener.Close()
+		drained, killed, err = e.conns.Shutdown(ctx)
+	}
+	return
+}
+
+func (e *forwardEntry) wrap(raw xio.Piper) xio.Piper {
+	return newCountPiper(raw, &e.active)
+}
+
+func (e *forwardEntry) dialer(next xio.PiperDialer) xio.PiperDialer {
+	return xio.PiperContextDialerF(func(ctx context.Context, uri string, bufferSize int) (raw xio.Piper, err error) {
+		raw, err = xio.DialPiperContext(ctx, next, uri, bufferSize)
+		if err == nil {
+			raw = e.wrap(raw)
+		}
+		return
+	})
+}
+
+func (e *forwardEntry) info() (info *ForwardInfo) {
+	info = &ForwardInfo{
+		Name:    e.name,
+		Scheme:  e.listen.Scheme,
+		Listen:  e.listen.String(),
+		Address: e.listener.Addr().String(),
+		Router:  e.router,
+		Active:  atomic.LoadInt64(&e.active),
+	}
 	return
 }
 
@@ -27,8 +105,13 @@ type Forward struct {
 	Name       string
 	BufferSize int
 	Dialer     xio.PiperDialer
+	Sessions   *SessionManager
+	Limiter    *xio.Limiter
+	UDPTimeout time.Duration
+	TLSConfig  *tls.Config
+	ProxyProto bool
 	forwardLck sync.RWMutex
-	forwardAll map[string][]interface{}
+	forwardAll map[string]*forwardEntry
 }
 
 func NewForward(name string) (forward *Forward) {
@@ -36,46 +119,103 @@ func NewForward(name string) (forward *Forward) {
 		Name:       name,
 		BufferSize: 8 * 1024,
 		Dialer:     xio.PiperDialerF(xio.DialNetPiper),
+		Sessions:   NewSessionManager(),
+		UDPTimeout: time.Minute,
 		forwardLck: sync.RWMutex{},
-		forwardAll: map[string][]interface{}{},
+		forwardAll: map[string]*forwardEntry{},
+	}
+	return
+}
+
+// listenTLS will return the tls config for listen scheme, it is nil when scheme is not tls
+func (f *Forward) listenTLS(scheme string) (config *tls.Config, err error) {
+	switch scheme {
+	case "tls", "socks+tls", "proxy+tls", "https":
+		config = f.TLSConfig
+		if config == nil {
+			err = fmt.Errorf("tls config is not setted for %v", scheme)
+		}
 	}
 	return
 }
 
-// StartForward will forward address to uri
+// listenTCP will listen tcp on address and wrap listener to accept PROXY protocol header when ProxyProto is true
+func (f *Forward) listenTCP(network, address string) (listener net.Listener, err error) {
+	listener, err = net.Listen(network, address)
+	if err == nil && f.ProxyProto {
+		listener = xio.NewProxyProtoListener(listener)
+	}
+	return
+}
+
+// StartForward will forward address to uri, the listen scheme is supported by
+// tcp/tls for tcp forward, socks/socks+tls for socks proxy, proxy/proxy+tls/https for socks/http proxy, udp for udp forward
 func (f *Forward) StartForward(name string, listen *url.URL, router string) (listener net.Listener, err error) {
 	f.forwardLck.Lock()
 	defer f.forwardLck.Unlock()
 	if f.forwardAll[name] != nil || len(name) < 1 {
 		err = fmt.Errorf("the name(%v) is already used", name)
-		WarnLog("Forward(%v) start forward by %v fail with %v", f.Name, listen, router, err)
+		WarnLog("Forward(%v) start forward(%v) by %v->%v fail with %v", f.Name, name, listen, router, err)
+		return
+	}
+	tlsConfig, err := f.listenTLS(listen.Scheme)
+	if err != nil {
+		WarnLog("Forward(%v) start forward(%v) by %v->%v fail with %v", f.Name, name, listen, router, err)
 		return
 	}
+	entry := &forwardEntry{name: name, listen: listen, router: router, conns: xio.NewConnGroup()}
 	switch listen.Scheme {
-	case "socks":
+	case "socks", "socks+tls":
 		sp := socks.NewServer()
+		sp.TLSConfig = tlsConfig
+		sp.ProxyProto = f.ProxyProto
 		sp.BufferSize = f.BufferSize
-		sp.Dialer = &RouterPiperDialer{Router: router, Next: f.Dialer}
+		sp.Dialer = f.Sessions.Dialer("socks", entry.dialer(&RouterPiperDialer{Router: router, Next: f.Dialer}))
+		if f.Limiter != nil {
+			sp.Dialer = f.Limiter.Dialer(sp.Dialer)
+		}
 		listener, err = sp.Start("tcp", listen.Host)
 		if err == nil {
-			f.forwardAll[name] = []interface{}{listen.Scheme, listener, listen}
+			entry.listener, entry.server = listener, sp
+			f.forwardAll[name] = entry
 			InfoLog("Forward(%v) start socket forward on %v success by %v->%v", f.Name, listener.Addr(), listen, router)
 		}
-	case "proxy":
-		dialer := &RouterPiperDialer{Router: router, Next: f.Dialer}
+	case "proxy", "proxy+tls", "https":
+		dialer := entry.dialer(&RouterPiperDialer{Router: router, Next: f.Dialer})
 		sp := NewServer(dialer)
+		sp.TLSConfig = tlsConfig
+		sp.ProxyProto = f.ProxyProto
+		sp.Sessions = f.Sessions
+		sp.Limiter = f.Limiter
 		sp.SOCKS.BufferSize = f.BufferSize
 		sp.HTTP.BufferSize = f.BufferSize
 		listener, err = sp.Start("tcp", listen.Host)
 		if err == nil {
-			f.forwardAll[name] = []interface{}{listen.Scheme, listener, listen, router}
+			entry.listener, entry.server = listener, sp
+			f.forwardAll[name] = entry
 			InfoLog("Forward(%v) start proxy forward on %v success by %v->%v", f.Name, listener.Addr(), listen, router)
 		}
+	case "udp", "udp4", "udp6":
+		listener, err = f.startUDPForward(entry)
+		if err == nil {
+			f.forwardAll[name] = entry
+			InfoLog("Forward(%v) start udp forward on %v success by %v->%v", f.Name, listener.Addr(), listen, router)
+		}
+	case "tls":
+		listener, err = f.listenTCP("tcp", listen.Host)
+		if err == nil {
+			listener = tls.NewListener(listener, tlsConfig)
+			entry.listener = listener
+			f.forwardAll[name] = entry
+			go f.loopForward(entry)
+			InfoLog("Forward(%v) start tls forward on %v success by %v->%v", f.Name, listener.Addr(), listen, router)
+		}
 	default:
-		listener, err = net.Listen(listen.Scheme, listen.Host)
+		listener, err = f.listenTCP(listen.Scheme, listen.Host)
 		if err == nil {
-			f.forwardAll[name] = []interface{}{listen.Scheme, listener, listen, router}
-			go f.loopForward(listener, name, listen, router)
+			entry.listener = listener
+			f.forwardAll[name] = entry
+			go f.loopForward(entry)
 			InfoLog("Forward(%v) start tcp forward on %v success by %v->%v", f.Name, listener.Addr(), listen, router)
 		}
 	}
@@ -86,23 +226,39 @@ func (f *Forward) StartForward(name string, listen *url.URL, router string) (lis
 func (f *Forward) StopForward(name string) (err error) {
 	InfoLog("Forward(%v) stop forward by name:%v", f.Name, name)
 	f.forwardLck.Lock()
-	forward := f.forwardAll[name]
+	entry := f.forwardAll[name]
 	delete(f.forwardAll, name)
 	f.forwardLck.Unlock()
-	if len(forward) > 0 {
-		err = forward[1].(io.Closer).Close()
+	if entry != nil {
+		err = entry.listener.Close()
 	}
 	return
 }
 
-func (f *Forward) loopForward(l net.Listener, name string, listen *url.URL, uri string) {
-	defer func() {
-		f.forwardLck.Lock()
-		delete(f.forwardAll, name)
-		f.forwardLck.Unlock()
-	}()
+// removeForward will remove the forward entry when it is still running
+func (f *Forward) removeForward(entry *forwardEntry) {
+	f.forwardLck.Lock()
+	if f.forwardAll[entry.name] == entry {
+		delete(f.forwardAll, entry.name)
+	}
+	f.forwardLck.Unlock()
+}
+
+// List will return all running forward info sorted by name
+func (f *Forward) List() (forwards []*ForwardInfo) {
+	f.forwardLck.RLock()
+	for _, entry := range f.forwardAll {
+		forwards = append(forwards, entry.info())
+	}
+	f.forwardLck.RUnlock()
+	sort.Slice(forwards, func(i, j int) bool { return forwards[i].Name < forwards[j].Name })
+	return
+}
+
+func (f *Forward) loopForward(entry *forwardEntry) {
+	defer f.removeForward(entry)
+	l, uri := entry.listener, entry.router
 	var err error
-	var piper xio.Piper
 	var conn net.Conn
 	InfoLog("Forward(%v) proxy forward(%v->%v) accept runner is starting", f.Name, l.Addr(), uri)
 	for {
@@ -110,20 +266,42 @@ func (f *Forward) loopForward(l net.Listener, name string, listen *url.URL, uri
 		if err != nil {
 			break
 		}
-		DebugLog("Forward(%v) accepting forward(%v->%v) connection from %v", f.Name, l.Addr(), uri, conn.RemoteAddr())
-		piper, err = f.Dialer.DialPiper(uri, f.BufferSize)
-		if err == nil {
-			DebugLog("Forward(%v) proxy forward(%v->%v) success", f.Name, l.Addr(), uri)
-			go f.procForward(l, name, piper, conn, uri)
-		} else {
-			WarnLog("Forward(%v) proxy forward(%v->%v) fail with %v", f.Name, l.Addr(), uri, err)
+		if !entry.conns.Add(conn) {
 			conn.Close()
+			continue
 		}
+		go f.procTrackedForward(entry, conn)
 	}
 	l.Close()
 	InfoLog("Forward(%v) proxy forward(%v->%v) accept runner is stopped", f.Name, l.Addr(), uri)
 }
 
+// procTrackedForward will dial router and pipe conn in connection runner,
+// the RemoteAddr may be blocked on reading PROXY protocol header, so it must not be called in accept runner
+func (f *Forward) procTrackedForward(entry *forwardEntry, conn net.Conn) {
+	defer entry.conns.Done(conn)
+	l, uri := entry.listener, entry.router
+	DebugLog("Forward(%v) accepting forward(%v->%v) connection from %v", f.Name, l.Addr(), uri, conn.RemoteAddr())
+	piper, err := xio.DialPiperClient(conn.RemoteAddr().String(), f.dialer(), uri, f.BufferSize)
+	if err != nil {
+		WarnLog("Forward(%v) proxy forward(%v->%v) fail with %v", f.Name, l.Addr(), uri, err)
+		conn.Close()
+		return
+	}
+	piper = f.Sessions.Wrap("forward", uri, entry.wrap(piper))
+	DebugLog("Forward(%v) proxy forward(%v->%v) success", f.Name, l.Addr(), uri)
+	f.procForward(l, entry.name, piper, conn, uri)
+}
+
+// dialer will return Dialer limited by Limiter, so the max connection is checked before dial
+func (f *Forward) dialer() (dialer xio.PiperDialer) {
+	dialer = f.Dialer
+	if f.Limiter != nil {
+		dialer = f.Limiter.Dialer(dialer)
+	}
+	return
+}
+
 func (f *Forward) procForward(l net.Listener, name string, piper xio.Piper, conn net.Conn, uri string) {
 	defer func() {
 		if perr := recover(); perr != nil {
@@ -136,12 +314,43 @@ func (f *Forward) procForward(l net.Listener, name string, piper xio.Piper, conn
 // Stop will stop all
 func (f *Forward) Stop() (err error) {
 	InfoLog("Forward(%v) is closing", f.Name)
-	f.forwardLck.RLock()
-	for key, forward := range f.forwardAll {
-		forward[1].(net.Listener).Close()
+	f.forwardLck.Lock()
+	all := f.forwardAll
+	f.forwardAll = map[string]*forwardEntry{}
+	f.forwardLck.Unlock()
+	for key, entry := range all {
+		entry.listener.Close()
 		InfoLog("Forward(%v) forwad %v is closed", f.Name, key)
 	}
-	f.forwardAll = map[string][]interface{}{}
-	f.forwardLck.RUnlock()
+	return
+}
+
+// Shutdown will stop all forward and wait running connection done until ctx is done, then close the remaining.
+// The drained is count of connection done by self and killed is count of connection closed by force.
+func (f *Forward) Shutdown(ctx context.Context) (drained, killed int, err error) {
+	InfoLog("Forward(%v) is shutting down", f.Name)
+	f.forwardLck.Lock()
+	all := f.forwardAll
+	f.forwardAll = map[string]*forwardEntry{}
+	f.forwardLck.Unlock()
+	waiter := sync.WaitGroup{}
+	lock := sync.Mutex{}
+	for _, entry := range all {
+		waiter.Add(1)
+		go func(entry *forwardEntry) {
+			defer waiter.Done()
+			d, k, xerr := entry.shutdown(ctx)
+			lock.Lock()
+			drained += d
+			killed += k
+			if err == nil {
+				err = xerr
+			}
+			lock.Unlock()
+			InfoLog("Forward(%v) forward %v is shutdown with %v drained, %v killed", f.Name, entry.name, d, k)
+		}(entry)
+	}
+	waiter.Wait()
+	InfoLog("Forward(%v) is shutdown with %v drained, %v killed", f.Name, drained, killed)
 	return
 }
@@ -1,13 +1,16 @@
 package proxy
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	"net/url"
 	"testing"
+	"time"
 
 	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xio/frame"
 )
 
 func runEchoServer(addr string) {
@@ -119,3 +122,184 @@ func TestForward(t *testing.T) {
 		dialer.DialPiper("", 0)
 	}
 }
+
+func TestForwardLimit(t *testing.T) {
+	backend, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer backend.Close()
+	accepted := make(chan net.Conn, 10)
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				break
+			}
+			accepted <- conn
+			go io.Copy(conn, conn)
+		}
+	}()
+	forward := NewForward("Test")
+	defer forward.Stop()
+	forward.Limiter = xio.NewLimiter()
+	forward.Limiter.Global.MaxConn = 1
+	listenURL, _ := url.Parse("tcp://127.0.0.1:0")
+	listener, err := forward.StartForward("limit", listenURL, "tcp://"+backend.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	buf := make([]byte, 3)
+	fmt.Fprintf(conn, "abc")
+	conn.SetDeadline(time.Now().Add(time.Second))
+	if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", err, string(buf))
+		return
+	}
+	//rejected client is closed before dial
+	rejected, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer rejected.Close()
+	rejected.SetDeadline(time.Now().Add(time.Second))
+	if _, err = rejected.Read(buf); err != io.EOF {
+		t.Error(err)
+		return
+	}
+	time.Sleep(50 * time.Millisecond)
+	if len(accepted) != 1 {
+		t.Error(len(accepted))
+		return
+	}
+}
+
+func TestForwardShutdown(t *testing.T) {
+	echo, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	forward := NewForward("Test")
+	listenURL, _ := url.Parse("tcp://127.0.0.1:0")
+	listener, err := forward.StartForward("tcp", listenURL, "tcp://"+echo.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	proxyURL, _ := url.Parse("proxy://127.0.0.1:0")
+	_, err = forward.StartForward("proxy", proxyURL, "tcp://${HOST}")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dial := func() (conn net.Conn) {
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			panic(err)
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 3)
+		io.ReadFull(conn, buf)
+		return
+	}
+	drainConn := dial()
+	killConn := dial()
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		drainConn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	drained, killed, err := forward.Shutdown(ctx)
+	if err != context.DeadlineExceeded || drained != 1 || killed != 1 {
+		t.Errorf("%v,%v,%v", drained, killed, err)
+		return
+	}
+	buf := make([]byte, 3)
+	if _, err = killConn.Read(buf); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, err = net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("error")
+		return
+	}
+	if len(forward.List()) != 0 {
+		t.Error("error")
+		return
+	}
+}
+
+func TestForwardMux(t *testing.T) {
+	echo, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	a, b, _ := xio.CreatePipedConn()
+	client := frame.NewMuxSession(frame.NewReadWriteCloser(nil, a, 32*1024), true)
+	server := frame.NewMuxSession(frame.NewReadWriteCloser(nil, b, 32*1024), false)
+	defer client.Close()
+	listener := server.Listener()
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			target := conn.(*frame.MuxStream).Target
+			piper, err := xio.DialNetPiper(target, 32*1024)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			go piper.PipeConn(conn, target)
+		}
+	}()
+	forward := NewForward("Test")
+	forward.Dialer = client
+	defer forward.Stop()
+	listenURL, _ := url.Parse("tcp://127.0.0.1:0")
+	forwardListener, err := forward.StartForward("mux", listenURL, "tcp://"+echo.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	for i := 0; i < 3; i++ {
+		conn, err := net.Dial("tcp", forwardListener.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 3)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "abc" {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+		conn.Close()
+	}
+}
@@ -1,15 +1,21 @@
 package http
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	_ "net/http/pprof"
+	"net/url"
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/codingeasygo/util/proxy/acl"
+	"github.com/codingeasygo/util/xio"
 )
 
 func init() {
@@ -150,3 +156,284 @@ func TestProxy(t *testing.T) {
 	server.Stop()
 	// conn,err:=Dial(":8011",ts.URL)
 }
+
+func TestAuth(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "abc")
+	}))
+	defer ts.Close()
+	server := NewServer()
+	server.Auth = AuthenticatorF(func(username, password string) (err error) {
+		if username != "abc" || password != "123" {
+			err = fmt.Errorf("invalid username or password")
+		}
+		return
+	})
+	listener, err := server.Start("127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	proxy := listener.Addr().String()
+	address := strings.TrimPrefix(ts.URL, "http://")
+	{ //CONNECT ok
+		conn, err := Dial("http://abc:123@"+proxy, address)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+	{ //CONNECT fail
+		_, err = Dial("http://abc:xxx@"+proxy, address)
+		if err == nil || !strings.Contains(err.Error(), "407") {
+			t.Error(err)
+			return
+		}
+		_, err = Dial("http://abc@"+proxy, address)
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = Dial(proxy, address)
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //NORMAL
+		proxyURL, _ := url.Parse("http://abc:123@" + proxy)
+		client := http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyURL(proxyURL),
+			},
+		}
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != "abc" {
+			t.Error(string(data))
+			return
+		}
+		proxyURL, _ = url.Parse("http://" + proxy)
+		client.Transport = &http.Transport{
+			Proxy: http.ProxyURL(proxyURL),
+		}
+		resp, err = client.Get(ts.URL)
+		if err != nil || resp.StatusCode != http.StatusProxyAuthRequired || len(resp.Header.Get("Proxy-Authenticate")) < 1 {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+	}
+	{ //parse
+		if _, _, ok := parseProxyAuth("Basic xxx"); ok {
+			t.Error("error")
+			return
+		}
+		if _, _, ok := parseProxyAuth("Bearer xxx"); ok {
+			t.Error("error")
+			return
+		}
+	}
+}
+
+func TestKeepAlive(t *testing.T) {
+	newServer := func(name string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.RequestURI != "/path?a=1" || len(r.Header.Get("Proxy-Connection")) > 0 || len(r.Header.Get("X-Hop")) > 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, "%v,%v", r.RequestURI, r.Header)
+				return
+			}
+			if r.Method == http.MethodPost {
+				data, _ := ioutil.ReadAll(r.Body)
+				fmt.Fprintf(w, "%v-%v", name, string(data))
+				return
+			}
+			fmt.Fprintf(w, "%v", name)
+		}))
+	}
+	ts1, ts2 := newServer("ts1"), newServer("ts2")
+	defer ts1.Close()
+	defer ts2.Close()
+	server := NewServer()
+	listener, err := server.Start("127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	for _, c := range []struct {
+		URL    string
+		Method string
+		Body   string
+		Expect string
+	}{
+		{URL: ts1.URL, Method: "GET", Expect: "ts1"},
+		{URL: ts1.URL, Method: "POST", Body: "abc", Expect: "ts1-abc"},
+		{URL: ts2.URL, Method: "GET", Expect: "ts2"},
+		{URL: ts1.URL, Method: "GET", Expect: "ts1"},
+	} {
+		req, _ := http.NewRequest(c.Method, c.URL+"/path?a=1", strings.NewReader(c.Body))
+		req.Header.Set("Proxy-Connection", "keep-alive")
+		req.Header.Set("Connection", "X-Hop")
+		req.Header.Set("X-Hop", "1")
+		err = req.WriteProxy(conn)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(data) != c.Expect {
+			t.Errorf("expect %v, but %v", c.Expect, string(data))
+			return
+		}
+	}
+	{ //close
+		req, _ := http.NewRequest("GET", ts2.URL+"/path?a=1", nil)
+		req.Close = true
+		req.WriteProxy(conn)
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil || !resp.Close {
+			t.Error(err)
+			return
+		}
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		_, err = reader.ReadByte()
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //upgrade
+		ln, _ := net.Listen("tcp", "127.0.0.1:0")
+		defer ln.Close()
+		go func() {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			r := bufio.NewReader(c)
+			http.ReadRequest(r)
+			fmt.Fprintf(c, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+			line, _ := r.ReadString('\n')
+			fmt.Fprintf(c, "%v", line)
+			c.Close()
+		}()
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		req, _ := http.NewRequest("GET", "http://"+ln.Addr().String()+"/path?a=1", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "test")
+		req.WriteProxy(conn)
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, req)
+		if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc\n")
+		line, _ := reader.ReadString('\n')
+		if line != "abc\n" {
+			t.Error(line)
+			return
+		}
+	}
+}
+
+func TestAccess(t *testing.T) {
+	server := NewServer()
+	server.Dialer = xio.NewEchoDialer()
+	rules := acl.NewACL()
+	rules.Load("deny host=10.0.0.0/8")
+	server.Access = rules
+	listener, err := server.Start("127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	proxy := listener.Addr().String()
+	conn, err := Dial(proxy, "127.0.0.1:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	_, err = Dial(proxy, "10.0.0.1:80")
+	if err == nil || !strings.Contains(err.Error(), "403") {
+		t.Error(err)
+		return
+	}
+	for _, header := range []string{"", "Upgrade"} {
+		conn, err := net.Dial("tcp", proxy)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req, _ := http.NewRequest("GET", "http://10.0.0.1/", nil)
+		if len(header) > 0 {
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "test")
+		}
+		req.WriteProxy(conn)
+		resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+		if err != nil || resp.StatusCode != http.StatusForbidden {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	server := NewServer()
+	listener, err := server.Start("127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	drainConn, _ := net.Dial("tcp", listener.Addr().String())
+	killConn, _ := net.Dial("tcp", listener.Addr().String())
+	defer killConn.Close()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		drainConn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	drained, killed, err := server.Shutdown(ctx)
+	if err != context.DeadlineExceeded || drained != 1 || killed != 1 {
+		t.Errorf("%v,%v,%v", drained, killed, err)
+		return
+	}
+	if _, err = net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("error")
+		return
+	}
+}
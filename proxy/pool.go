@@ -0,0 +1,370 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codingeasygo/util/proxy/http"
+	"github.com/codingeasygo/util/proxy/socks"
+	"github.com/codingeasygo/util/xio"
+)
+
+const (
+	//PoolRoundRobin is pool strategy to select member by turns
+	PoolRoundRobin = "round-robin"
+	//PoolLeastConn is pool strategy to select member having least active connection
+	PoolLeastConn = "least-conn"
+	//PoolRandom is pool strategy to select member randomly
+	PoolRandom = "random"
+)
+
+// PoolMember is one upstream member of PoolDialer
+type PoolMember struct {
+	URI        string
+	Dialer     xio.PiperDialer
+	active     int64
+	fails      int
+	ejectUntil time.Time
+}
+
+// PoolMemberInfo is the state info of PoolMember
+type PoolMemberInfo struct {
+	URI     string `json:"uri"`
+	Active  int64  `json:"active"`
+	Fails   int    `json:"fails"`
+	Ejected bool   `json:"ejected"`
+}
+
+// Active will return the active connection count of member
+func (p *PoolMember) Active() int64 {
+	return atomic.LoadInt64(&p.active)
+}
+
+// PoolDialer is PiperDialer to dial target by one of upstream members, the failed member is ejected temporarily
+// after MaxFails continuous dial or health check fail, and the next member is retried when dial fail.
+type PoolDialer struct {
+	Strategy     string
+	Retry        int
+	MaxFails     int
+	EjectTime    time.Duration
+	CheckDelay   time.Duration
+	CheckTimeout time.Duration
+	Probe        func(uri string) (err error)
+	members      []*PoolMember
+	sequence     uint64
+	lock         sync.RWMutex
+	exiter       chan int
+	waiter       sync.WaitGroup
+}
+
+// NewPoolDialer will return new PoolDialer by strategy and upstream uri list,
+// the upstream uri is proxy chain list for ChainDialer like socks5://host:port or socks5://host1:port,http://host2:port
+func NewPoolDialer(strategy string, uris ...string) (pool *PoolDialer, err error) {
+	switch strategy {
+	case PoolRoundRobin, PoolLeastConn, PoolRandom:
+	case "":
+		strategy = PoolRoundRobin
+	default:
+		err = fmt.Errorf("not supported pool strategy %v", strategy)
+		return
+	}
+	pool = &PoolDialer{
+		Strategy:     strategy,
+		MaxFails:     3,
+		EjectTime:    30 * time.Second,
+		CheckDelay:   10 * time.Second,
+		CheckTimeout: 3 * time.Second,
+		lock:         sync.RWMutex{},
+		waiter:       sync.WaitGroup{},
+	}
+	pool.Probe = pool.probeTCP
+	for _, uri := range uris {
+		var dialer *ChainDialer
+		dialer, err = ParseChainDialer(uri)
+		if err != nil {
+			return
+		}
+		pool.AddMember(uri, dialer)
+	}
+	return
+}
+
+// AddMember will add upstream member by uri and dialer
+func (p *PoolDialer) AddMember(uri string, dialer xio.PiperDialer) {
+	p.lock.Lock()
+	p.members = append(p.members, &PoolMember{URI: uri, Dialer: dialer})
+	p.lock.Unlock()
+}
+
+// RemoveMember will remove upstream member by uri
+func (p *PoolDialer) RemoveMember(uri string) {
+	p.lock.Lock()
+	members := []*PoolMember{}
+	for _, member := range p.members {
+		if member.URI != uri {
+			members = append(members, member)
+		}
+	}
+	p.members = members
+	p.lock.Unlock()
+}
+
+// Members will return all member info
+func (p *PoolDialer) Members() (infos []*PoolMemberInfo) {
+	now := time.Now()
+	p.lock.RLock()
+	for _, member := range p.members {
+		infos = append(infos, &PoolMemberInfo{
+			URI:     member.URI,
+			Active:  member.Active(),
+			Fails:   member.fails,
+			Ejected: now.Before(member.ejectUntil),
+		})
+	}
+	p.lock.RUnlock()
+	return
+}
+
+// Start will start the health check runner
+func (p *PoolDialer) Start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.exiter != nil {
+		return
+	}
+	p.exiter = make(chan int, 1)
+	p.waiter.Add(1)
+	go p.loopCheck(p.exiter)
+}
+
+// Stop will stop the health check runner
+func (p *PoolDialer) Stop() {
+	p.lock.Lock()
+	exiter := p.exiter
+	p.exiter = nil
+	p.lock.Unlock()
+	if exiter != nil {
+		exiter <- 1
+		p.waiter.Wait()
+	}
+}
+
+func (p *PoolDialer) loopCheck(exiter chan int) {
+	defer p.waiter.Done()
+	ticker := time.NewTicker(p.CheckDelay)
+	defer ticker.Stop()
+	InfoLog("PoolDialer health check runner is starting")
+	running := true
+	for running {
+		select {
+		case <-exiter:
+			running = false
+		case <-ticker.C:
+			p.Check()
+		}
+	}
+	InfoLog("PoolDialer health check runner is stopped")
+}
+
+// Check will probe all member once and update the member health
+func (p *PoolDialer) Check() {
+	p.lock.RLock()
+	members := p.members
+	p.lock.RUnlock()
+	waiter := sync.WaitGroup{}
+	for _, member := range members {
+		waiter.Add(1)
+		go func(member *PoolMember) {
+			defer waiter.Done()
+			err := p.Probe(member.URI)
+			p.mark(member, err)
+		}(member)
+	}
+	waiter.Wait()
+}
+
+func (p *PoolDialer) probeTCP(uri string) (err error) {
+	first := uri
+	if chain, xerr := ParseChainDialer(uri); xerr == nil && len(chain.Proxies) > 0 {
+		first = chain.Proxies[0]
+	}
+	u, err := url.Parse(first)
+	if err != nil {
+		return
+	}
+	address := u.Host
+	if len(u.Port()) < 1 {
+		switch u.Scheme {
+		case "wss":
+			address = net.JoinHostPort(u.Hostname(), "443")
+		case "socks5", "socks":
+			address = net.JoinHostPort(u.Hostname(), "1080")
+		default:
+			address = net.JoinHostPort(u.Hostname(), "80")
+		}
+	}
+	conn, err := net.DialTimeout("tcp", address, p.CheckTimeout)
+	if err == nil {
+		conn.Close()
+	}
+	return
+}
+
+// isTargetError will return true if err is replied by member for target like connection refused or host unreachable,
+// the member is reachable and the error should not be counted as member fail
+func isTargetError(err error) bool {
+	var rerr *socks.ReplyError
+	if errors.As(err, &rerr) {
+		switch rerr.Reply {
+		case socks.ReplyNotAllowed, socks.ReplyNetworkUnreachable, socks.ReplyHostUnreachable,
+			socks.ReplyConnectionRefused, socks.ReplyTTLExpired, socks.ReplyAddressNotSupported:
+			return len(rerr.Message) < 1 //the message is set by local auth/method check
+		}
+		return false
+	}
+	var herr *http.ResponseError
+	if errors.As(err, &herr) {
+		switch herr.StatusCode {
+		case 403, 500, 502, 504:
+			return true
+		}
+	}
+	return false
+}
+
+func (p *PoolDialer) mark(member *PoolMember, err error) {
+	if err != nil && isTargetError(err) {
+		err = nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err == nil {
+		if member.fails > 0 {
+			InfoLog("PoolDialer member %v is recovered", member.URI)
+		}
+		member.fails = 0
+		member.ejectUntil = time.Time{}
+		return
+	}
+	member.fails++
+	if p.MaxFails > 0 && member.fails >= p.MaxFails {
+		member.ejectUntil = time.Now().Add(p.EjectTime)
+		WarnLog("PoolDialer member %v is ejected by %v continuous fails with %v", member.URI, member.fails, err)
+	}
+}
+
+// selectMember will return one member by strategy which is not in tried, the ejected member is used only when all member is ejected
+func (p *PoolDialer) selectMember(tried map[*PoolMember]bool) (member *PoolMember) {
+	now := time.Now()
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	candidates := []*PoolMember{}
+	ejected := []*PoolMember{}
+	for _, m := range p.members {
+		if tried[m] {
+			continue
+		}
+		if now.Before(m.ejectUntil) {
+			ejected = append(ejected, m)
+		} else {
+			candidates = append(candidates, m)
+		}
+	}
+	if len(candidates) < 1 {
+		candidates = ejected
+	}
+	if len(candidates) < 1 {
+		return
+	}
+	switch p.Strategy {
+	case PoolLeastConn:
+		member = candidates[0]
+		for _, m := range candidates[1:] {
+			if m.Active() < member.Active() {
+				member = m
+			}
+		}
+	case PoolRandom:
+		member = candidates[rand.Intn(len(candidates))]
+	default:
+		member = candidates[int(atomic.AddUint64(&p.sequence, 1)-1)%len(candidates)]
+	}
+	return
+}
+
+// DialPiper will dial uri by selected member and retry next member when fail, it is xio.PiperDialer implement
+func (p *PoolDialer) DialPiper(uri string, bufferSize int) (raw xio.Piper, err error) {
+	raw, err = p.DialPiperContext(context.Background(), uri, bufferSize)
+	return
+}
+
+// DialPiperContext will dial uri by selected member with context and retry next member when fail until ctx is done
+func (p *PoolDialer) DialPiperContext(ctx context.Context, uri string, bufferSize int) (raw xio.Piper, err error) {
+	tried := map[*PoolMember]bool{}
+	for {
+		if xerr := ctx.Err(); xerr != nil {
+			err = xerr
+			break
+		}
+		member := p.selectMember(tried)
+		if member == nil || (p.Retry > 0 && len(tried) > p.Retry) {
+			if err == nil {
+				err = fmt.Errorf("not upstream member is available")
+			}
+			break
+		}
+		tried[member] = true
+		raw, err = xio.DialPiperContext(ctx, member.Dialer, uri, bufferSize)
+		if err != nil && ctx.Err() != nil {
+			break
+		}
+		p.mark(member, err)
+		if err == nil {
+			raw = newCountPiper(raw, &member.active)
+			break
+		}
+		DebugLog("PoolDialer dial %v by member %v fail with %v", uri, member.URI, err)
+	}
+	return
+}
+
+// countPiper is piper wrapper to count active piper, the counter is decreased when piper is closed or PipeConn returned
+type countPiper struct {
+	xio.Piper
+	counter *int64
+	closer  sync.Once
+}
+
+func newCountPiper(raw xio.Piper, counter *int64) (piper *countPiper) {
+	atomic.AddInt64(counter, 1)
+	piper = &countPiper{Piper: raw, counter: counter}
+	return
+}
+
+func (c *countPiper) done() {
+	c.closer.Do(func() {
+		atomic.AddInt64(c.counter, -1)
+	})
+}
+
+func (c *countPiper) PipeConn(conn io.ReadWriteCloser, target string) (err error) {
+	err = c.Piper.PipeConn(conn, target)
+	if err != xio.ErrAsyncRunning {
+		c.done()
+	}
+	return
+}
+
+func (c *countPiper) Close() (err error) {
+	err = c.Piper.Close()
+	c.done()
+	return
+}
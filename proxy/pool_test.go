@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/proxy/http"
+	"github.com/codingeasygo/util/proxy/socks"
+	"github.com/codingeasygo/util/xio"
+)
+
+func TestPoolDialer(t *testing.T) {
+	newMember := func(name string, fail *bool, dialed map[string]int) xio.PiperDialer {
+		return xio.PiperDialerF(func(uri string, bufferSize int) (raw xio.Piper, err error) {
+			dialed[name]++
+			if *fail {
+				err = fmt.Errorf("%v fail", name)
+				return
+			}
+			raw = xio.NewEchoPiper(bufferSize)
+			return
+		})
+	}
+	failA, failB := false, false
+	dialed := map[string]int{}
+	pool, _ := NewPoolDialer(PoolRoundRobin)
+	pool.AddMember("a", newMember("a", &failA, dialed))
+	pool.AddMember("b", newMember("b", &failB, dialed))
+	{ //round robin
+		for i := 0; i < 4; i++ {
+			raw, err := pool.DialPiper("tcp://127.0.0.1:80", 1024)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			raw.Close()
+		}
+		if dialed["a"] != 2 || dialed["b"] != 2 {
+			t.Error(dialed)
+			return
+		}
+	}
+	{ //retry and eject
+		failA = true
+		pool.MaxFails = 1
+		for i := 0; i < 4; i++ {
+			raw, err := pool.DialPiper("tcp://127.0.0.1:80", 1024)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			raw.Close()
+		}
+		if dialed["a"] != 3 || dialed["b"] != 6 {
+			t.Error(dialed)
+			return
+		}
+		members := pool.Members()
+		if !members[0].Ejected || members[1].Ejected || members[0].Fails != 1 {
+			t.Error("error")
+			return
+		}
+		//all fail
+		failB = true
+		_, err := pool.DialPiper("tcp://127.0.0.1:80", 1024)
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		pool.Retry = 0
+		failA, failB = false, false
+		raw, err := pool.DialPiper("tcp://127.0.0.1:80", 1024)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		raw.Close()
+	}
+	{ //health check
+		pool.Probe = func(uri string) error {
+			if uri == "a" {
+				return fmt.Errorf("a fail")
+			}
+			return nil
+		}
+		pool.Check()
+		members := pool.Members()
+		if !members[0].Ejected || members[1].Ejected {
+			t.Error("error")
+			return
+		}
+		pool.Probe = func(uri string) error { return nil }
+		pool.CheckDelay = 10 * time.Millisecond
+		pool.Start()
+		pool.Start()
+		time.Sleep(50 * time.Millisecond)
+		pool.Stop()
+		pool.Stop()
+		members = pool.Members()
+		if members[0].Ejected || members[0].Fails != 0 {
+			t.Error("error")
+			return
+		}
+	}
+	{ //least conn
+		pool.Strategy = PoolLeastConn
+		raws := []xio.Piper{}
+		for i := 0; i < 4; i++ {
+			raw, err := pool.DialPiper("tcp://127.0.0.1:80", 1024)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			raws = append(raws, raw)
+		}
+		members := pool.Members()
+		if members[0].Active != 2 || members[1].Active != 2 {
+			t.Error("error")
+			return
+		}
+		conna, connb, _ := xio.CreatePipedConn()
+		go func() {
+			fmt.Fprintf(conna, "abc")
+			buf := make([]byte, 3)
+			xio.FullBuffer(conna, buf, 3, nil)
+			conna.Close()
+		}()
+		raws[0].PipeConn(connb, "")
+		for _, raw := range raws {
+			raw.Close()
+		}
+		members = pool.Members()
+		if members[0].Active != 0 || members[1].Active != 0 {
+			t.Error("error")
+			return
+		}
+	}
+	{ //random
+		pool.Strategy = PoolRandom
+		raw, err := pool.DialPiper("tcp://127.0.0.1:80", 1024)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		raw.Close()
+		pool.RemoveMember("a")
+		pool.RemoveMember("b")
+		_, err = pool.DialPiper("tcp://127.0.0.1:80", 1024)
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //target error
+		pool, _ := NewPoolDialer(PoolRoundRobin)
+		pool.MaxFails = 1
+		var targetErr error
+		pool.AddMember("a", xio.PiperDialerF(func(uri string, bufferSize int) (raw xio.Piper, err error) {
+			err = targetErr
+			return
+		}))
+		for _, err := range []error{
+			&socks.ReplyError{Reply: socks.ReplyConnectionRefused},
+			&socks.ReplyError{Reply: socks.ReplyHostUnreachable},
+			&http.ResponseError{StatusCode: 502},
+		} {
+			targetErr = err
+			if _, xerr := pool.DialPiper("tcp://127.0.0.1:80", 1024); xerr != err {
+				t.Error(xerr)
+				return
+			}
+			if members := pool.Members(); members[0].Fails != 0 || members[0].Ejected {
+				t.Errorf("%v,%v", err, members[0].Fails)
+				return
+			}
+		}
+		for _, err := range []error{
+			&socks.ReplyError{Reply: socks.ReplyGeneralFailure},
+			&socks.ReplyError{Reply: socks.ReplyNotAllowed, Message: "socks server auth fail with code(1)"},
+			&http.ResponseError{StatusCode: 407},
+			fmt.Errorf("dial fail"),
+		} {
+			if isTargetError(err) {
+				t.Error(err)
+				return
+			}
+		}
+		targetErr = &http.ResponseError{StatusCode: 407}
+		pool.DialPiper("tcp://127.0.0.1:80", 1024)
+		if members := pool.Members(); members[0].Fails != 1 || !members[0].Ejected {
+			t.Error("error")
+			return
+		}
+	}
+	{ //upstream uri
+		server := socks.NewServer()
+		server.Dialer = xio.NewEchoDialer()
+		listener, _ := server.Start("tcp", "127.0.0.1:0")
+		defer server.Stop()
+		pool, err := NewPoolDialer("", "socks5://"+listener.Addr().String(), "socks5://127.0.0.1:1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		pool.Check()
+		members := pool.Members()
+		if members[0].Fails != 0 || members[1].Fails != 1 {
+			t.Error("error")
+			return
+		}
+		raw, err := pool.DialPiper("tcp://127.0.0.1:80", 1024)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		raw.Close()
+		for _, uri := range []string{"socks5://127.0.0.1", "wss://127.0.0.1", "http://127.0.0.1", "http://%zz"} {
+			if err := pool.probeTCP(uri); err == nil {
+				t.Error(uri)
+				return
+			}
+		}
+		_, err = NewPoolDialer("xx")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = NewPoolDialer(PoolRandom, "xx://127.0.0.1")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+}
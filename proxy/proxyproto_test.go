@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/proxy/socks"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xnet"
+)
+
+func TestForwardProxyProto(t *testing.T) {
+	raw, _ := net.Listen("tcp", "127.0.0.1:0")
+	backend := xio.NewProxyProtoListener(raw)
+	defer backend.Close()
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				fmt.Fprintf(conn, "%v", conn.RemoteAddr())
+				conn.Close()
+			}()
+		}
+	}()
+	forward := NewForward("Test")
+	defer forward.Stop()
+	forward.ProxyProto = true
+	forward.Dialer = &xio.NetPiperDialer{ProxyProtocol: 1}
+	start := func(name, listen, router string) (address string) {
+		listenURL, _ := url.Parse(listen)
+		listener, err := forward.StartForward(name, listenURL, router)
+		if err != nil {
+			panic(err)
+		}
+		address = listener.Addr().String()
+		return
+	}
+	source := &net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1000}
+	testRemote := func(conn net.Conn, err error) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		data, err := io.ReadAll(conn)
+		if err != nil || string(data) != source.String() {
+			t.Errorf("%v,%v", err, string(data))
+			return
+		}
+	}
+	{ //tcp
+		address := start("tcp", "tcp://127.0.0.1:0", "tcp://"+backend.Addr().String())
+		conn, err := net.Dial("tcp", address)
+		if err == nil {
+			conn.Write(xio.NewProxyHeader(2, source, conn.RemoteAddr()).Bytes())
+		}
+		testRemote(conn, err)
+		//slow header is not blocking accept
+		slow, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer slow.Close()
+		conn, err = net.Dial("tcp", address)
+		if err == nil {
+			conn.Write(xio.NewProxyHeader(2, source, conn.RemoteAddr()).Bytes())
+			conn.SetDeadline(time.Now().Add(time.Second))
+		}
+		testRemote(conn, err)
+	}
+	{ //socks
+		address := start("socks", "socks://127.0.0.1:0", "${HOST}")
+		dialer := func(network, addr string) (conn net.Conn, err error) {
+			conn, err = net.Dial(network, addr)
+			if err == nil {
+				_, err = conn.Write(xio.NewProxyHeader(1, source, conn.RemoteAddr()).Bytes())
+			}
+			return
+		}
+		testRemote(socks.DialBy(xnet.RawDialerF(dialer), "socks5://"+address, backend.Addr().String()))
+	}
+	{ //not header
+		address := forward.List()[1].Address
+		conn, err := net.Dial("tcp", address)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Write([]byte("abc"))
+		buf := make([]byte, 1)
+		if _, err = conn.Read(buf); err == nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+}
@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/codingeasygo/util/proxy/acl"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xprop"
+)
+
+func TestRouteDialer(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "route")
+	defer os.RemoveAll(dir)
+	geoFile := filepath.Join(dir, "geo.txt")
+	ioutil.WriteFile(geoFile, []byte("#geo\n1.0.1.0/24 cn\n8.8.8.0/24 US\n"), os.ModePerm)
+	config := xprop.NewConfig()
+	err := config.LoadPropString(fmt.Sprintf(`
+[route]
+default=corp
+geo=%v
+upstream.corp=socks5://127.0.0.1:1
+rule.01=direct domain=.local.com
+rule.02=reject port=25
+rule.03=direct cidr=10.0.0.0/8,192.168.1.1
+rule.04=corp geo=US
+rule.05=direct geo=CN
+`, geoFile))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	dialer := NewRouteDialer(xio.NewEchoDialer())
+	err = dialer.LoadConfig(config, "route")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if dialer.Geo.Size() != 2 || len(dialer.Rules()) != 5 || len(dialer.Upstreams()) != 1 {
+		t.Error("error")
+		return
+	}
+	for uri, expect := range map[string]string{
+		"tcp://a.local.com:80": "direct",
+		"local.com:80":         "direct",
+		"xlocal.com:80":        "corp",
+		"tcp://1.1.1.1:25":     "reject",
+		"10.1.1.1:80":          "direct",
+		"192.168.1.1:80":       "direct",
+		"192.168.1.2:80":       "corp",
+		"8.8.8.8:53":           "corp",
+		"1.0.1.1:443":          "direct",
+		"example.com:443":      "corp",
+	} {
+		if route, matched := dialer.Match(uri); route != expect {
+			t.Errorf("%v expect %v, but %v by %v", uri, expect, route, matched)
+			return
+		}
+	}
+	testEcho := func(piper xio.Piper, err error) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conna, connb, _ := xio.CreatePipedConn()
+		go piper.PipeConn(connb, "")
+		fmt.Fprintf(conna, "abc")
+		buf := make([]byte, 1024)
+		n, err := conna.Read(buf)
+		if err != nil || string(buf[:n]) != "abc" {
+			t.Error(err)
+			return
+		}
+		conna.Close()
+	}
+	testEcho(dialer.DialPiper("tcp://a.local.com:80", 1024))
+	testEcho(dialer.DialPiper("direct://example.com:80", 1024))
+	_, err = dialer.DialPiper("tcp://1.1.1.1:25", 1024)
+	if !acl.IsDenied(err) {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.DialPiper("reject://a.local.com:80", 1024)
+	if !acl.IsDenied(err) {
+		t.Error(err)
+		return
+	}
+	_, err = dialer.DialPiper("example.com:80", 1024)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	dialer.SetUpstream("corp", xio.NewEchoDialer())
+	testEcho(dialer.DialPiper("example.com:80", 1024))
+	testEcho(dialer.DialPiper("corp://a.local.com:80", 1024))
+	dialer.SetUpstream("corp", nil)
+	_, err = dialer.DialPiper("example.com:80", 1024)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	{ //forward
+		dialer.SetUpstream("corp", xio.NewEchoDialer())
+		forward := NewForward("Test")
+		forward.Dialer = dialer
+		listener, err := forward.StartForward("corp", &url.URL{Scheme: "tcp", Host: "127.0.0.1:0"}, "corp://example.com:80")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn, err := net.Dial("tcp", listener.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "abc" {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+		forward.Stop()
+	}
+	{ //reload
+		dialer := NewRouteDialer(xio.NewEchoDialer())
+		if err := dialer.LoadConfig(config, "route"); err != nil {
+			t.Error(err)
+			return
+		}
+		reload := xprop.NewConfig()
+		reload.LoadPropString("[route]\nupstream.other=socks5://127.0.0.1:1\nrule.01=other port=22\n")
+		if err := dialer.LoadConfig(reload, "route"); err != nil {
+			t.Error(err)
+			return
+		}
+		if upstreams := dialer.Upstreams(); len(upstreams) != 1 || upstreams[0] != "other" || dialer.Geo != nil || len(dialer.Rules()) != 1 {
+			t.Errorf("%v,%v", upstreams, dialer.Rules())
+			return
+		}
+		if route, _ := dialer.Match("example.com:443"); route != RouteDirect {
+			t.Error(route)
+			return
+		}
+		bad := xprop.NewConfig()
+		bad.LoadPropString("[route]\nupstream.corp=socks5://127.0.0.1:1\nrule.01=none *\n")
+		if err := dialer.LoadConfig(bad, "route"); err == nil {
+			t.Error(err)
+			return
+		}
+		if upstreams := dialer.Upstreams(); len(upstreams) != 1 || upstreams[0] != "other" || len(dialer.Rules()) != 1 {
+			t.Errorf("%v,%v", upstreams, dialer.Rules())
+			return
+		}
+	}
+	{ //resolve
+		dialer := NewRouteDialer(xio.NewEchoDialer())
+		dialer.Load("reject cidr=127.0.0.0/8")
+		if route, _ := dialer.Match("localhost:80"); route != RouteReject {
+			t.Error(route)
+			return
+		}
+		if _, err := dialer.DialPiper("tcp://localhost:80", 1024); err == nil {
+			t.Error(err)
+			return
+		}
+		dialer.Resolve = false
+		if route, _ := dialer.Match("localhost:80"); route != RouteDirect {
+			t.Error(route)
+			return
+		}
+		config := xprop.NewConfig()
+		config.LoadPropString("[route]\nresolve=0\nrule.01=reject cidr=127.0.0.0/8\n")
+		if err := dialer.LoadConfig(config, "route"); err != nil || dialer.Resolve {
+			t.Error(err)
+			return
+		}
+		if route, _ := dialer.Match("localhost:80"); route != RouteDirect {
+			t.Error(route)
+			return
+		}
+		config = xprop.NewConfig()
+		config.LoadPropString("[route]\nrule.01=reject cidr=127.0.0.0/8\n")
+		if err := dialer.LoadConfig(config, "route"); err != nil || !dialer.Resolve {
+			t.Error(err)
+			return
+		}
+		if route, _ := dialer.Match("localhost:80"); route != RouteReject {
+			t.Error(route)
+			return
+		}
+		config = xprop.NewConfig()
+		config.LoadPropString("[route]\nresolve=xx\n")
+		if err := dialer.LoadConfig(config, "route"); err == nil || !dialer.Resolve {
+			t.Error(err)
+			return
+		}
+	}
+	{ //error
+		dialer := NewRouteDialer(xio.NewEchoDialer())
+		for _, line := range []string{"", "direct xx", "direct xx=1", "direct cidr=xx", "direct port=xx", "direct port=2-1", "none *"} {
+			if line == "" {
+				if _, err := ParseRouteRule(line); err == nil {
+					t.Error(line)
+					return
+				}
+				continue
+			}
+			if err := dialer.Load(line); err == nil {
+				t.Error(line)
+				return
+			}
+		}
+		for _, data := range []string{
+			"[route]\nupstream.x=xx://127.0.0.1",
+			"[route]\ngeo=/none/geo.txt",
+			"[route]\ndefault=none",
+			"[route]\nrule.01=none *",
+		} {
+			config := xprop.NewConfig()
+			config.LoadPropString(data)
+			if err := dialer.LoadConfig(config, "route"); err == nil {
+				t.Error(data)
+				return
+			}
+		}
+		geo := NewGeoTable()
+		if err := geo.Load(strings.NewReader("1.0.0.0/8")); err == nil {
+			t.Error(err)
+			return
+		}
+		if err := geo.Load(strings.NewReader("1.0.0.0 CN")); err == nil {
+			t.Error(err)
+			return
+		}
+		if code := geo.Lookup(nil); code != "" {
+			t.Error(code)
+			return
+		}
+	}
+}
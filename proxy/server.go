@@ -1,6 +1,8 @@
 package proxy
 
 import (
+	"context"
+	"crypto/tls"
 	"net"
 	"sync"
 
@@ -12,10 +14,14 @@ import (
 // Server provider http/socks combined server
 type Server struct {
 	*xio.ByteDistributeProcessor
-	Dialer xio.PiperDialer
-	HTTP   *http.Server
-	SOCKS  *socks.Server
-	waiter sync.WaitGroup
+	Dialer     xio.PiperDialer
+	HTTP       *http.Server
+	SOCKS      *socks.Server
+	Sessions   *SessionManager
+	Limiter    *xio.Limiter
+	TLSConfig  *tls.Config
+	ProxyProto bool
+	waiter     sync.WaitGroup
 }
 
 // NewServer will return new Server
@@ -25,18 +31,46 @@ func NewServer(dialer xio.PiperDialer) (server *Server) {
 		Dialer:                  dialer,
 		HTTP:                    http.NewServer(),
 		SOCKS:                   socks.NewServer(),
+		Sessions:                NewSessionManager(),
 		waiter:                  sync.WaitGroup{},
 	}
-	server.HTTP.Dialer = server
-	server.SOCKS.Dialer = server
+	server.HTTP.Dialer = server.protocolDialer("http")
+	server.SOCKS.Dialer = server.protocolDialer("socks")
 	server.AddProcessor('*', server.HTTP)
+	server.AddProcessor(0x04, server.SOCKS)
 	server.AddProcessor(0x05, server.SOCKS)
 	return
 }
 
 // DialPiper is xio.Piper implement
 func (s *Server) DialPiper(uri string, bufferSize int) (raw xio.Piper, err error) {
-	raw, err = s.Dialer.DialPiper(uri, bufferSize)
+	raw, err = s.DialPiperContext(context.Background(), uri, bufferSize)
+	return
+}
+
+// DialPiperContext is xio.PiperContextDialer implement
+func (s *Server) DialPiperContext(ctx context.Context, uri string, bufferSize int) (raw xio.Piper, err error) {
+	raw, err = xio.DialPiperContext(ctx, s.Dialer, uri, bufferSize)
+	return
+}
+
+func (s *Server) protocolDialer(protocol string) xio.PiperDialer {
+	return xio.PiperContextDialerF(func(ctx context.Context, uri string, bufferSize int) (raw xio.Piper, err error) {
+		var dialer xio.PiperDialer = xio.PiperContextDialerF(s.DialPiperContext)
+		if s.Limiter != nil {
+			dialer = s.Limiter.Dialer(dialer)
+		}
+		raw, err = xio.DialPiperContext(ctx, dialer, uri, bufferSize)
+		if err == nil && s.Sessions != nil {
+			raw = s.Sessions.Wrap(protocol, uri, raw)
+		}
+		return
+	})
+}
+
+// State will return the running session and monitor state
+func (s *Server) State() (state interface{}, err error) {
+	state, err = s.Sessions.State()
 	return
 }
 
@@ -46,6 +80,12 @@ func (s *Server) Start(network, addr string) (listener net.Listener, err error)
 	if err != nil {
 		return
 	}
+	if s.ProxyProto {
+		listener = xio.NewProxyProtoListener(listener)
+	}
+	if s.TLSConfig != nil {
+		listener = tls.NewListener(listener, s.TLSConfig)
+	}
 	s.waiter.Add(1)
 	go func() {
 		s.ProcAccept(listener)
@@ -54,6 +94,15 @@ func (s *Server) Start(network, addr string) (listener net.Listener, err error)
 	return
 }
 
+// Shutdown will stop all listener and wait running connection done until ctx is done, then close the remaining.
+// The drained is count of connection done by self and killed is count of connection closed by force.
+func (s *Server) Shutdown(ctx context.Context) (drained, killed int, err error) {
+	drained, killed, err = s.ByteDistributeProcessor.Shutdown(ctx)
+	s.Wait()
+	InfoLog("Server proxy is shutdown with %v drained, %v killed", drained, killed)
+	return
+}
+
 // Wait will wait all runner
 func (s *Server) Wait() {
 	s.waiter.Wait()
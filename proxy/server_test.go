@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/proxy/socks"
+	"github.com/codingeasygo/util/xio"
+)
+
+func TestServer(t *testing.T) {
+	server := NewServer(xio.NewEchoDialer())
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	proxy := listener.Addr().String()
+	testEcho := func(conn net.Conn, err error) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "abc" {
+			t.Error(err)
+			return
+		}
+	}
+	testEcho(socks.Dial(proxy, "127.0.0.1:80"))
+	testEcho(socks.Dial4(proxy, "127.0.0.1:80"))
+	testEcho(socks.Dial4(proxy, "localhost:80"))
+	server.Close()
+	listener.Close()
+	server.Wait()
+}
+
+func TestServerLimit(t *testing.T) {
+	server := NewServer(xio.NewEchoDialer())
+	server.Limiter = xio.NewLimiter()
+	server.Limiter.Client.MaxConn = 1
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	proxy := listener.Addr().String()
+	conn, err := socks.Dial(proxy, "127.0.0.1:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(conn, "abc")
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Error(err)
+		return
+	}
+	limited, err := socks.Dial(proxy, "127.0.0.1:80")
+	if err == nil {
+		_, err = limited.Read(buf)
+	}
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	server.Close()
+	listener.Close()
+	server.Wait()
+}
+
+func TestServerShutdown(t *testing.T) {
+	server := NewServer(xio.PiperDialerF(xio.DialNetPiper))
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	drainConn, _ := net.Dial("tcp", listener.Addr().String())
+	killConn, _ := net.Dial("tcp", listener.Addr().String())
+	defer killConn.Close()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		drainConn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	drained, killed, err := server.Shutdown(ctx)
+	if err != context.DeadlineExceeded || drained != 1 || killed != 1 {
+		t.Errorf("%v,%v,%v", drained, killed, err)
+		return
+	}
+}
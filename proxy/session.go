@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codingeasygo/util/monitor"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xmap"
+)
+
+// SessionInfo is the info of one proxy session
+type SessionInfo struct {
+	ID       uint64    `json:"id"`
+	Protocol string    `json:"protocol"`
+	Client   string    `json:"client"`
+	URI      string    `json:"uri"`
+	Start    time.Time `json:"start"`
+	In       int64     `json:"in"`
+	Out      int64     `json:"out"`
+}
+
+// Session is the running proxy session, the In is bytes readed from client and Out is bytes writed to client
+type Session struct {
+	ID        uint64
+	Protocol  string
+	Client    string
+	URI       string
+	Start     time.Time
+	in        int64
+	out       int64
+	conn      io.ReadWriteCloser
+	raw       xio.Piper
+	manager   *SessionManager
+	monitorID string
+	closer    sync.Once
+}
+
+// In will return bytes readed from client
+func (s *Session) In() int64 {
+	return atomic.LoadInt64(&s.in)
+}
+
+// Out will return bytes writed to client
+func (s *Session) Out() int64 {
+	return atomic.LoadInt64(&s.out)
+}
+
+// Info will return the session info
+func (s *Session) Info() (info *SessionInfo) {
+	info = &SessionInfo{
+		ID:       s.ID,
+		Protocol: s.Protocol,
+		Client:   s.Client,
+		URI:      s.URI,
+		Start:    s.Start,
+		In:       s.In(),
+		Out:      s.Out(),
+	}
+	return
+}
+
+func (s *Session) Read(p []byte) (n int, err error) {
+	n, err = s.conn.Read(p)
+	atomic.AddInt64(&s.in, int64(n))
+	return
+}
+
+func (s *Session) Write(p []byte) (n int, err error) {
+	n, err = s.conn.Write(p)
+	atomic.AddInt64(&s.out, int64(n))
+	return
+}
+
+// Close will close the client connection and remove session
+func (s *Session) Close() (err error) {
+	err = s.conn.Close()
+	s.done()
+	return
+}
+
+// Kill will close client connection and piper
+func (s *Session) Kill() (err error) {
+	err = s.conn.Close()
+	s.raw.Close()
+	s.done()
+	return
+}
+
+func (s *Session) done() {
+	s.closer.Do(func() {
+		s.manager.remove(s)
+	})
+}
+
+func (s *Session) String() string {
+	return s.Client
+}
+
+// RemoteAddr will return the remote address of client conn, it is nil when conn is not net.Conn
+func (s *Session) RemoteAddr() (addr net.Addr) {
+	if conn, ok := s.conn.(net.Conn); ok {
+		addr = conn.RemoteAddr()
+	}
+	return
+}
+
+// LocalAddr will return the local address of client conn, it is nil when conn is not net.Conn
+func (s *Session) LocalAddr() (addr net.Addr) {
+	if conn, ok := s.conn.(net.Conn); ok {
+		addr = conn.LocalAddr()
+	}
+	return
+}
+
+// SessionManager is the live table of proxy session
+type SessionManager struct {
+	Monitor  *monitor.Monitor
+	sessions map[uint64]*Session
+	sequence uint64
+	lock     sync.RWMutex
+}
+
+// NewSessionManager will return new SessionManager
+func NewSessionManager() (manager *SessionManager) {
+	manager = &SessionManager{
+		Monitor:  monitor.New(),
+		sessions: map[uint64]*Session{},
+		lock:     sync.RWMutex{},
+	}
+	return
+}
+
+func (m *SessionManager) add(protocol, uri string, conn io.ReadWriteCloser, raw xio.Piper) (session *Session) {
+	session = &Session{
+		Protocol: protocol,
+		Client:   xio.RemoteAddr(conn),
+		URI:      uri,
+		Start:    time.Now(),
+		conn:     conn,
+		raw:      raw,
+		manager:  m,
+	}
+	if m.Monitor != nil {
+		session.monitorID = m.Monitor.Start("proxy/" + protocol)
+	}
+	m.lock.Lock()
+	m.sequence++
+	session.ID = m.sequence
+	m.sessions[session.ID] = session
+	m.lock.Unlock()
+	return
+}
+
+func (m *SessionManager) remove(session *Session) {
+	m.lock.Lock()
+	delete(m.sessions, session.ID)
+	m.lock.Unlock()
+	if m.Monitor != nil {
+		m.Monitor.Done(session.monitorID)
+	}
+	DebugLog("SessionManager session(%v) %v from %v to %v is done by in:%v,out:%v", session.ID, session.Protocol, session.Client, session.URI, session.In(), session.Out())
+}
+
+// Wrap will wrap piper to record session when PipeConn is called
+func (m *SessionManager) Wrap(protocol, uri string, raw xio.Piper) (piper xio.Piper) {
+	piper = &sessionPiper{
+		manager:  m,
+		protocol: protocol,
+		uri:      uri,
+		Piper:    raw,
+	}
+	return
+}
+
+// Dialer will return PiperDialer to record session of piper dialed by next
+func (m *SessionManager) Dialer(protocol string, next xio.PiperDialer) (dialer xio.PiperDialer) {
+	dialer = xio.PiperContextDialerF(func(ctx context.Context, uri string, bufferSize int) (raw xio.Piper, err error) {
+		raw, err = xio.DialPiperContext(ctx, next, uri, bufferSize)
+		if err == nil {
+			raw = m.Wrap(protocol, uri, raw)
+		}
+		return
+	})
+	return
+}
+
+// List will return all running session info sorted by id
+func (m *SessionManager) List() (sessions []*SessionInfo) {
+	m.lock.RLock()
+	for _, session := range m.sessions {
+		sessions = append(sessions, session.Info())
+	}
+	m.lock.RUnlock()
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].ID < sessions[j].ID })
+	return
+}
+
+// Find will return running session info by id, nil is returned when not found
+func (m *SessionManager) Find(id uint64) (info *SessionInfo) {
+	m.lock.RLock()
+	session := m.sessions[id]
+	m.lock.RUnlock()
+	if session != nil {
+		info = session.Info()
+	}
+	return
+}
+
+// Kill will close the running session by id
+func (m *SessionManager) Kill(id uint64) (err error) {
+	m.lock.RLock()
+	session := m.sessions[id]
+	m.lock.RUnlock()
+	if session == nil {
+		err = fmt.Errorf("session %v is not exists", id)
+		return
+	}
+	InfoLog("SessionManager session(%v) %v from %v to %v is killed", session.ID, session.Protocol, session.Client, session.URI)
+	err = session.Kill()
+	return
+}
+
+// Size will return running session count
+func (m *SessionManager) Size() (n int) {
+	m.lock.RLock()
+	n = len(m.sessions)
+	m.lock.RUnlock()
+	return
+}
+
+// State will return the running session and monitor state
+func (m *SessionManager) State() (state interface{}, err error) {
+	result := xmap.M{
+		"sessions": m.List(),
+	}
+	if m.Monitor != nil {
+		result["monitor"], err = m.Monitor.State()
+	}
+	state = result
+	return
+}
+
+type sessionPiper struct {
+	xio.Piper
+	manager  *SessionManager
+	protocol string
+	uri      string
+}
+
+func (s *sessionPiper) PipeConn(conn io.ReadWriteCloser, target string) (err error) {
+	session := s.manager.add(s.protocol, s.uri, conn, s.Piper)
+	err = s.Piper.PipeConn(session, target)
+	if err != xio.ErrAsyncRunning {
+		session.done()
+	}
+	return
+}
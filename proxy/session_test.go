@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/converter"
+	"github.com/codingeasygo/util/proxy/http"
+	"github.com/codingeasygo/util/proxy/socks"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xmap"
+)
+
+func waitSessions(sessions *SessionManager, n int) bool {
+	for i := 0; i < 100; i++ {
+		if sessions.Size() == n {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestSession(t *testing.T) {
+	server := NewServer(xio.NewEchoDialer())
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer func() {
+		server.Close()
+		listener.Close()
+		server.Wait()
+	}()
+	proxy := listener.Addr().String()
+	testSession := func(protocol string, dial func(proxy, uri string) (net.Conn, error)) {
+		conn, err := dial(proxy, "127.0.0.1:80")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "abc" {
+			t.Error(err)
+			return
+		}
+		sessions := server.Sessions.List()
+		if len(sessions) != 1 || sessions[0].Protocol != protocol || sessions[0].In != 3 || sessions[0].Out != 3 || sessions[0].Client != conn.LocalAddr().String() {
+			t.Errorf("%v", converter.JSON(sessions))
+			return
+		}
+		info := server.Sessions.Find(sessions[0].ID)
+		if info == nil || info.URI != "tcp://127.0.0.1:80" {
+			t.Error("error")
+			return
+		}
+		err = server.Sessions.Kill(sessions[0].ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, err = conn.Read(buf)
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		if !waitSessions(server.Sessions, 0) {
+			t.Error("error")
+			return
+		}
+	}
+	testSession("socks", socks.Dial)
+	testSession("http", http.Dial)
+	conn, err := socks.Dial(proxy, "127.0.0.1:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "abc")
+	if _, err = conn.Read(make([]byte, 1024)); err != nil {
+		t.Error(err)
+		return
+	}
+	state, err := server.State()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	stateM := state.(xmap.M)
+	sessions, _ := stateM["sessions"].([]*SessionInfo)
+	if len(sessions) != 1 || sessions[0].Protocol != "socks" || sessions[0].URI != "tcp://127.0.0.1:80" || sessions[0].Client != conn.LocalAddr().String() ||
+		sessions[0].In != 3 || sessions[0].Out != 3 || sessions[0].Start.IsZero() || stateM["monitor"] == nil {
+		t.Errorf("%v", converter.JSON(state))
+		return
+	}
+	if server.Sessions.Find(1000) != nil {
+		t.Error("error")
+		return
+	}
+	if err = server.Sessions.Kill(1000); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestForwardSession(t *testing.T) {
+	forward := NewForward("Test")
+	forward.Dialer = xio.NewEchoDialer()
+	defer forward.Stop()
+	listenURL, _ := url.Parse("tcp://127.0.0.1:0")
+	listener, err := forward.StartForward("abc", listenURL, "tcp://127.0.0.1:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fmt.Fprintf(conn, "abc")
+	buf := make([]byte, 1024)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "abc" {
+		t.Error(err)
+		return
+	}
+	sessions := forward.Sessions.List()
+	if len(sessions) != 1 || sessions[0].Protocol != "forward" {
+		t.Errorf("%v", converter.JSON(sessions))
+		return
+	}
+	conn.Close()
+	if !waitSessions(forward.Sessions, 0) {
+		t.Error("error")
+		return
+	}
+}
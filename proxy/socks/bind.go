@@ -0,0 +1,116 @@
+package socks
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+func (s *Server) procBind(conn io.ReadWriteCloser, buf []byte, host string, port int) (err error) {
+	err = s.checkAccess(conn, "tcp://"+net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		writeErrorReply(conn, buf, err)
+		return
+	}
+	var bindIP net.IP
+	if netConn, ok := conn.(net.Conn); ok {
+		if addr, ok := netConn.LocalAddr().(*net.TCPAddr); ok {
+			bindIP = addr.IP
+		}
+	}
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: bindIP})
+	if err != nil {
+		writeErrorReply(conn, buf, err)
+		WarnLog("Server socks proxy bind on %v from %v fail with %v", xio.LocalAddr(conn), xio.RemoteAddr(conn), err)
+		return
+	}
+	defer listener.Close()
+	bind := listener.Addr().(*net.TCPAddr)
+	_, err = writeReply(conn, buf, ReplySucceeded, bind.IP.String(), bind.Port)
+	if err != nil {
+		return
+	}
+	DebugLog("Server socks proxy bind on %v from %v is waiting incoming connection", bind, xio.RemoteAddr(conn))
+	if s.BindTimeout > 0 {
+		listener.SetDeadline(time.Now().Add(s.BindTimeout))
+	}
+	expect := net.ParseIP(host)
+	var remote *net.TCPConn
+	var from *net.TCPAddr
+	for {
+		remote, err = listener.AcceptTCP()
+		if err != nil {
+			writeErrorReply(conn, buf, err)
+			DebugLog("Server socks proxy bind on %v from %v fail with %v", bind, xio.RemoteAddr(conn), err)
+			return
+		}
+		from = remote.RemoteAddr().(*net.TCPAddr)
+		if expect == nil || expect.IsUnspecified() || expect.Equal(from.IP) {
+			break
+		}
+		WarnLog("Server socks proxy bind on %v reject incoming connection from %v, expect %v", bind, from, host)
+		remote.Close()
+	}
+	listener.Close()
+	_, err = writeReply(conn, buf, ReplySucceeded, from.IP.String(), from.Port)
+	if err != nil {
+		remote.Close()
+		return
+	}
+	uri := "tcp://" + from.String()
+	DebugLog("Server socks proxy bind on %v from %v is accepted %v", bind, xio.RemoteAddr(conn), from)
+	raw := xio.NewCopyPiper(remote, s.BufferSize)
+	err = raw.PipeConn(conn, uri)
+	raw.Close()
+	return
+}
+
+// BindConn is net.Conn implement by socks5 bind command
+type BindConn struct {
+	net.Conn
+	//BindAddr is the address which proxy server listen on for incoming connection
+	BindAddr string
+}
+
+// DialBind will send bind command to proxy server and return the connection after first reply,
+// the uri is the address of expected incoming connection, 0.0.0.0:0 is for any.
+func DialBind(proxy, uri string) (conn *BindConn, err error) {
+	host, p, err := net.SplitHostPort(uri)
+	if err != nil {
+		return
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return
+	}
+	uriType := byte(0x03)
+	if ip := net.ParseIP(host); ip != nil && ip.To4() != nil {
+		uriType = 0x01
+	} else if ip != nil {
+		uriType = 0x04
+	}
+	raw, bind, err := dialRequest(nil, proxy, 0x02, uriType, host, port)
+	if err != nil {
+		return
+	}
+	bindHost, bindPort, _ := net.SplitHostPort(bind)
+	if ip := net.ParseIP(bindHost); ip != nil && ip.IsUnspecified() {
+		if addr, ok := raw.RemoteAddr().(*net.TCPAddr); ok {
+			bind = net.JoinHostPort(addr.IP.String(), bindPort)
+		}
+	}
+	conn = &BindConn{
+		Conn:     raw,
+		BindAddr: bind,
+	}
+	return
+}
+
+// Accept will wait the incoming connection on proxy server and return the remote address of it
+func (b *BindConn) Accept() (remote string, err error) {
+	remote, err = readReply(b.Conn, make([]byte, 1024*64))
+	return
+}
@@ -0,0 +1,143 @@
+package socks
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBind(t *testing.T) {
+	server := NewServer()
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	proxy := listener.Addr().String()
+	{ //ok
+		conn, err := DialBind(proxy, "0.0.0.0:0")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go func() {
+			remote, err := net.Dial("tcp", conn.BindAddr)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			fmt.Fprintf(remote, "abc")
+			remote.Close()
+		}()
+		from, err := conn.Accept()
+		if err != nil || len(from) < 1 {
+			t.Error(err)
+			return
+		}
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "abc" {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+	{ //reject not expected and timeout
+		server.BindTimeout = 300 * time.Millisecond
+		conn, err := DialBind(proxy, "127.0.0.2:0")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		remote, err := net.Dial("tcp", conn.BindAddr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		_, err = conn.Accept()
+		if err == nil || ErrorReply(err) != ReplyTTLExpired {
+			t.Error(err)
+			return
+		}
+		remote.Close()
+		conn.Close()
+	}
+	{ //domain
+		conn, err := DialBind(proxy, "localhost:0")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+	{ //error
+		_, err = DialBind(proxy, "xxx")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = DialBind(proxy, "127.0.0.1:x")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestReplyCode(t *testing.T) {
+	server := NewServer()
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	proxy := listener.Addr().String()
+	{ //connection refused
+		ln, _ := net.Listen("tcp", "127.0.0.1:0")
+		address := ln.Addr().String()
+		ln.Close()
+		_, err = Dial(proxy, address)
+		if err == nil || ErrorReply(err) != ReplyConnectionRefused {
+			t.Error(err)
+			return
+		}
+	}
+	{ //host unreachable
+		_, err = Dial(proxy, "not.exists.invalid:80")
+		if err == nil || ErrorReply(err) != ReplyHostUnreachable {
+			t.Error(err)
+			return
+		}
+	}
+	{ //command not supported
+		conn, _, err := dialRequest(nil, proxy, 0x09, 0x01, "127.0.0.1", 80)
+		if err == nil || ErrorReply(err) != ReplyCommandNotSupported {
+			t.Errorf("%v,%v", conn, err)
+			return
+		}
+	}
+	{ //error
+		err := &ReplyError{Reply: ReplyGeneralFailure}
+		if err.Code() != ReplyGeneralFailure || len(err.Error()) < 1 {
+			t.Error(err)
+			return
+		}
+		if ErrorReply(fmt.Errorf("xx")) != ReplyMessage {
+			t.Error("error")
+			return
+		}
+		if ErrorReply(&net.OpError{Err: &timeoutErr{}}) != ReplyTTLExpired {
+			t.Error("error")
+			return
+		}
+	}
+}
+
+type timeoutErr struct{}
+
+func (t *timeoutErr) Error() string   { return "timeout" }
+func (t *timeoutErr) Timeout() bool   { return true }
+func (t *timeoutErr) Temporary() bool { return true }
@@ -1,16 +1,47 @@
 package socks
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
+	"github.com/codingeasygo/util/proxy/acl"
 	"github.com/codingeasygo/util/xdebug"
 	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xnet"
+)
+
+const (
+	//ReplySucceeded is reply code for succeeded
+	ReplySucceeded byte = 0x00
+	//ReplyGeneralFailure is reply code for general socks server failure
+	ReplyGeneralFailure byte = 0x01
+	//ReplyNotAllowed is reply code for connection not allowed by ruleset
+	ReplyNotAllowed byte = 0x02
+	//ReplyNetworkUnreachable is reply code for network unreachable
+	ReplyNetworkUnreachable byte = 0x03
+	//ReplyHostUnreachable is reply code for host unreachable
+	ReplyHostUnreachable byte = 0x04
+	//ReplyConnectionRefused is reply code for connection refused
+	ReplyConnectionRefused byte = 0x05
+	//ReplyTTLExpired is reply code for TTL expired
+	ReplyTTLExpired byte = 0x06
+	//ReplyCommandNotSupported is reply code for command not supported
+	ReplyCommandNotSupported byte = 0x07
+	//ReplyAddressNotSupported is reply code for address type not supported
+	ReplyAddressNotSupported byte = 0x08
+	//ReplyMessage is extension reply code for error message following the reply
+	ReplyMessage byte = 0x10
 )
 
 // Codable is interface for get current code
@@ -18,21 +49,101 @@ type Codable interface {
 	Code() byte
 }
 
+// ReplyError is the error for socks server reply not succeeded
+type ReplyError struct {
+	Reply   byte
+	Message string
+}
+
+// Code is Codable implement
+func (r *ReplyError) Code() byte {
+	return r.Reply
+}
+
+func (r *ReplyError) Error() string {
+	if len(r.Message) > 0 {
+		return r.Message
+	}
+	return fmt.Sprintf("socks server response code(%x)", r.Reply)
+}
+
+// ErrorReply will return the reply code by error
+func ErrorReply(err error) (reply byte) {
+	var cerr Codable
+	var derr *net.DNSError
+	var nerr net.Error
+	switch {
+	case errors.As(err, &cerr):
+		reply = cerr.Code()
+	case errors.Is(err, syscall.ECONNREFUSED):
+		reply = ReplyConnectionRefused
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &derr):
+		reply = ReplyHostUnreachable
+	case errors.Is(err, syscall.ENETUNREACH):
+		reply = ReplyNetworkUnreachable
+	case errors.As(err, &nerr) && nerr.Timeout():
+		reply = ReplyTTLExpired
+	default:
+		reply = ReplyMessage
+	}
+	return
+}
+
+// Authenticator is interface for check socks5 username/password
+type Authenticator interface {
+	Authenticate(username, password string) (err error)
+}
+
+// AuthenticatorF is func to implement Authenticator
+type AuthenticatorF func(username, password string) (err error)
+
+// Authenticate will check username/password by func
+func (a AuthenticatorF) Authenticate(username, password string) (err error) {
+	err = a(username, password)
+	return
+}
+
+// StaticAuthenticator is Authenticator implement by static username/password table
+type StaticAuthenticator map[string]string
+
+// Authenticate will check username/password by table
+func (s StaticAuthenticator) Authenticate(username, password string) (err error) {
+	if having, ok := s[username]; !ok || having != password {
+		err = fmt.Errorf("invalid username or password")
+	}
+	return
+}
+
 // Server is an implementation of socks5 proxy
 type Server struct {
 	BufferSize int
+	TLSConfig  *tls.Config
+	ProxyProto bool
 	listners   map[net.Listener]string
 	waiter     sync.WaitGroup
+	conns      *xio.ConnGroup
 	Dialer     xio.PiperDialer
+	Auth       Authenticator
+	Access     acl.Checker
+	//BindTimeout is the max time for waiting incoming connection on bind command
+	BindTimeout time.Duration
+	//UDPTimeout is the idle time to close udp associate session of one target, 0 is not closed until associate is done
+	UDPTimeout time.Duration
+	//UDPMaxSessions is the max target session of one udp associate, 0 is unlimited
+	UDPMaxSessions int
 }
 
 // NewServer will return new Server
 func NewServer() (socks *Server) {
 	socks = &Server{
-		BufferSize: 32 * 1024,
-		listners:   map[net.Listener]string{},
-		waiter:     sync.WaitGroup{},
-		Dialer:     xio.PiperDialerF(xio.DialNetPiper),
+		BufferSize:     32 * 1024,
+		listners:       map[net.Listener]string{},
+		waiter:         sync.WaitGroup{},
+		conns:          xio.NewConnGroup(),
+		Dialer:         xio.PiperDialerF(xio.DialNetPiper),
+		BindTimeout:    2 * time.Minute,
+		UDPTimeout:     time.Minute,
+		UDPMaxSessions: 256,
 	}
 	return
 }
@@ -43,15 +154,30 @@ func (s *Server) loopAccept(l net.Listener) {
 		if err != nil {
 			break
 		}
-		go s.ProcConn(conn)
+		if !s.conns.Add(conn) {
+			conn.Close()
+			continue
+		}
+		go s.procTrackedConn(conn)
 	}
 	s.waiter.Done()
 }
 
+func (s *Server) procTrackedConn(conn net.Conn) {
+	defer s.conns.Done(conn)
+	s.ProcConn(conn)
+}
+
 // Run will listen tcp on address and sync accept to ProcConn
 func (s *Server) Run(addr string) (err error) {
 	listener, err := net.Listen("tcp", addr)
 	if err == nil {
+		if s.ProxyProto {
+			listener = xio.NewProxyProtoListener(listener)
+		}
+		if s.TLSConfig != nil {
+			listener = tls.NewListener(listener, s.TLSConfig)
+		}
 		s.listners[listener] = addr
 		InfoLog("Server listen http proxy on %v", addr)
 		s.waiter.Add(1)
@@ -64,6 +190,12 @@ func (s *Server) Run(addr string) (err error) {
 func (s *Server) Start(network, addr string) (listener net.Listener, err error) {
 	listener, err = net.Listen(network, addr)
 	if err == nil {
+		if s.ProxyProto {
+			listener = xio.NewProxyProtoListener(listener)
+		}
+		if s.TLSConfig != nil {
+			listener = tls.NewListener(listener, s.TLSConfig)
+		}
 		s.listners[listener] = addr
 		InfoLog("Server listen socks5 proxy on %v", addr)
 		s.waiter.Add(1)
@@ -83,6 +215,15 @@ func (s *Server) Stop() (err error) {
 	return
 }
 
+// Shutdown will stop listener and wait running connection done until ctx is done, then close the remaining.
+// The drained is count of connection done by self and killed is count of connection closed by force.
+func (s *Server) Shutdown(ctx context.Context) (drained, killed int, err error) {
+	s.Stop()
+	drained, killed, err = s.conns.Shutdown(ctx)
+	InfoLog("Server socks5 proxy is shutdown with %v drained, %v killed", drained, killed)
+	return
+}
+
 // ProcConn will process connecton as socket protocol
 func (s *Server) ProcConn(conn io.ReadWriteCloser) (err error) {
 	// DebugLog("Server proxy socks connection on %v from %v", xio.LocalAddr(conn), xio.RemoteAddr(conn))
@@ -102,15 +243,23 @@ func (s *Server) ProcConn(conn io.ReadWriteCloser) (err error) {
 	if err != nil {
 		return
 	}
+	if buf[0] == 0x04 {
+		err = s.procSocks4(conn, buf)
+		return
+	}
 	if buf[0] != 0x05 {
-		err = fmt.Errorf("only ver 0x05 is supported, but %x", buf[0])
+		err = fmt.Errorf("only ver 0x04/0x05 is supported, but %x", buf[0])
 		return
 	}
 	err = xio.FullBuffer(conn, buf[2:], uint32(buf[1]), nil)
 	if err != nil {
 		return
 	}
-	_, err = conn.Write([]byte{0x05, 0x00})
+	if s.Auth != nil {
+		err = s.procAuth(conn, buf)
+	} else {
+		_, err = conn.Write([]byte{0x05, 0x00})
+	}
 	if err != nil {
 		return
 	}
@@ -124,55 +273,72 @@ func (s *Server) ProcConn(conn io.ReadWriteCloser) (err error) {
 		err = fmt.Errorf("only ver 0x05 is supported, but %x", buf[0])
 		return
 	}
-	var uri string
+	cmd := buf[1]
+	var uri, host string
+	var port int
 	switch buf[3] {
 	case 0x01:
 		err = xio.FullBuffer(conn, buf[5:], 5, nil)
-		if err == nil {
-			remote := fmt.Sprintf("%v.%v.%v.%v", buf[4], buf[5], buf[6], buf[7])
-			port := uint16(buf[8])*256 + uint16(buf[9])
-			uri = fmt.Sprintf("tcp://%v:%v", remote, port)
-		}
 	case 0x03:
-		err = xio.FullBuffer(conn, buf[5:], uint32(buf[4]+2), nil)
-		if err == nil {
-			remote := string(buf[5 : buf[4]+5])
-			port := uint16(buf[buf[4]+5])*256 + uint16(buf[buf[4]+6])
-			uri = fmt.Sprintf("tcp://%v:%v", remote, port)
-		}
+		err = xio.FullBuffer(conn, buf[5:], uint32(buf[4])+2, nil)
+	case 0x04:
+		err = xio.FullBuffer(conn, buf[5:], 17, nil)
 	default:
-		err = xio.FullBuffer(conn, buf[5:], uint32(buf[4]+2), nil)
+		err = xio.FullBuffer(conn, buf[5:], uint32(buf[4])+2, nil)
 		if err == nil {
 			uri = string(buf[5 : buf[4]+5])
 		}
 	}
-	DebugLog("Server socks proxy start dial to %v on %v from %v", uri, xio.LocalAddr(conn), xio.RemoteAddr(conn))
-	raw, err := s.Dialer.DialPiper(uri, s.BufferSize)
 	if err != nil {
-		buf[0], buf[1], buf[2], buf[3] = 0x05, 0x04, 0x00, 0x01
-		buf[4], buf[5], buf[6], buf[7] = 0x00, 0x00, 0x00, 0x00
-		buf[8], buf[9] = 0x00, 0x00
-		n := 0
-		if cerr, ok := err.(Codable); ok {
-			buf[1] = cerr.Code()
-			n = 10
-		} else {
-			buf[1] = 0x10
-			message := err.Error()
-			if len(message) > 2048 {
-				message = message[:2048]
-			}
-			binary.BigEndian.PutUint16(buf[10:12], uint16(len(message)))
-			n = 12 + copy(buf[12:], []byte(message))
+		return
+	}
+	if len(uri) < 1 {
+		host, port, _, err = parseAddress(buf[3:])
+		if err != nil {
+			return
 		}
-		conn.Write(buf[:n])
+		uri = fmt.Sprintf("tcp://%v", net.JoinHostPort(host, strconv.Itoa(port)))
+	}
+	switch cmd {
+	case 0x01:
+		err = s.procConnect(conn, buf, uri)
+	case 0x02:
+		err = s.procBind(conn, buf, host, port)
+	case 0x03:
+		err = s.procUDP(conn, buf)
+	default:
+		err = fmt.Errorf("command %x is not supported", cmd)
+		writeReply(conn, buf, ReplyCommandNotSupported, "0.0.0.0", 0)
+		WarnLog("Server socks proxy on %v from %v fail with %v", xio.LocalAddr(conn), xio.RemoteAddr(conn), err)
+	}
+	return
+}
+
+// checkAccess will check access to uri when Access is setted
+func (s *Server) checkAccess(conn io.ReadWriteCloser, uri string) (err error) {
+	if s.Access != nil {
+		err = s.Access.Check(xio.RemoteAddr(conn), uri)
+	}
+	if err != nil {
+		WarnLog("Server socks proxy access to %v on %v from %v fail with %v", uri, xio.LocalAddr(conn), xio.RemoteAddr(conn), err)
+	}
+	return
+}
+
+func (s *Server) procConnect(conn io.ReadWriteCloser, buf []byte, uri string) (err error) {
+	err = s.checkAccess(conn, uri)
+	if err != nil {
+		writeErrorReply(conn, buf, err)
+		return
+	}
+	DebugLog("Server socks proxy start dial to %v on %v from %v", uri, xio.LocalAddr(conn), xio.RemoteAddr(conn))
+	raw, err := xio.DialPiperClient(xio.RemoteAddr(conn), s.Dialer, uri, s.BufferSize)
+	if err != nil {
+		writeErrorReply(conn, buf, err)
 		DebugLog("Server socks proxy dial to %v on %v fail with %v", uri, xio.RemoteAddr(conn), err)
 		return
 	}
-	buf[0], buf[1], buf[2], buf[3] = 0x05, 0x00, 0x00, 0x01
-	buf[4], buf[5], buf[6], buf[7] = 0x00, 0x00, 0x00, 0x00
-	buf[8], buf[9] = 0x00, 0x00
-	_, err = conn.Write(buf[:10])
+	_, err = writeReply(conn, buf, ReplySucceeded, "0.0.0.0", 0)
 	if err != nil {
 		raw.Close()
 		return
@@ -184,63 +350,181 @@ func (s *Server) ProcConn(conn io.ReadWriteCloser) (err error) {
 	return
 }
 
+// writeReply will write socks5 reply with bind address
+func writeReply(conn io.Writer, buf []byte, reply byte, host string, port int) (n int, err error) {
+	data, err := appendAddress(append(buf[:0], 0x05, reply, 0x00), host, port)
+	if err == nil {
+		n, err = conn.Write(data)
+	}
+	return
+}
+
+// writeErrorReply will write socks5 reply by error code, the error message is sent when code is not found
+func writeErrorReply(conn io.Writer, buf []byte, err error) {
+	reply := ErrorReply(err)
+	if reply != ReplyMessage {
+		writeReply(conn, buf, reply, "0.0.0.0", 0)
+		return
+	}
+	buf[0], buf[1], buf[2], buf[3] = 0x05, ReplyMessage, 0x00, 0x01
+	buf[4], buf[5], buf[6], buf[7] = 0x00, 0x00, 0x00, 0x00
+	buf[8], buf[9] = 0x00, 0x00
+	message := err.Error()
+	if len(message) > 2048 {
+		message = message[:2048]
+	}
+	binary.BigEndian.PutUint16(buf[10:12], uint16(len(message)))
+	n := 12 + copy(buf[12:], []byte(message))
+	conn.Write(buf[:n])
+}
+
+func (s *Server) procAuth(conn io.ReadWriteCloser, buf []byte) (err error) {
+	supported := false
+	for _, method := range buf[2 : 2+int(buf[1])] {
+		if method == 0x02 {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		conn.Write([]byte{0x05, 0xFF})
+		err = fmt.Errorf("username/password method is required")
+		WarnLog("Server socks proxy auth on %v from %v fail with %v", xio.LocalAddr(conn), xio.RemoteAddr(conn), err)
+		return
+	}
+	_, err = conn.Write([]byte{0x05, 0x02})
+	if err != nil {
+		return
+	}
+	err = xio.FullBuffer(conn, buf, 2, nil)
+	if err != nil {
+		return
+	}
+	if buf[0] != 0x01 {
+		err = fmt.Errorf("only auth ver 0x01 is supported, but %x", buf[0])
+		return
+	}
+	ulen := uint32(buf[1])
+	err = xio.FullBuffer(conn, buf[2:], ulen+1, nil)
+	if err != nil {
+		return
+	}
+	plen := uint32(buf[2+ulen])
+	err = xio.FullBuffer(conn, buf[3+ulen:], plen, nil)
+	if err != nil {
+		return
+	}
+	username := string(buf[2 : 2+ulen])
+	password := string(buf[3+ulen : 3+ulen+plen])
+	err = s.Auth.Authenticate(username, password)
+	if err != nil {
+		conn.Write([]byte{0x01, 0x01})
+		WarnLog("Server socks proxy auth user %v on %v from %v fail with %v", username, xio.LocalAddr(conn), xio.RemoteAddr(conn), err)
+		return
+	}
+	_, err = conn.Write([]byte{0x01, 0x00})
+	return
+}
+
 // Dial will dial connection by proxy server
 func Dial(proxy, uri string) (conn net.Conn, err error) {
 	conn, err = DialType(proxy, 0x03, uri)
 	return
 }
 
+// DialContext will dial connection by proxy server with context
+func DialContext(ctx context.Context, proxy, uri string) (conn net.Conn, err error) {
+	conn, err = DialByContext(ctx, nil, proxy, uri)
+	return
+}
+
+// DialBy will dial connection by proxy server, the connection to proxy server is dialed by dialer
+func DialBy(dialer xnet.RawDialer, proxy, uri string) (conn net.Conn, err error) {
+	conn, err = DialByContext(context.Background(), dialer, proxy, uri)
+	return
+}
+
+// DialByContext will dial connection by proxy server with context, the handshake is canceled when ctx is done
+func DialByContext(ctx context.Context, dialer xnet.RawDialer, proxy, uri string) (conn net.Conn, err error) {
+	host, p, _ := net.SplitHostPort(uri)
+	port, _ := strconv.Atoi(p)
+	conn, _, err = dialRequestContext(ctx, dialer, proxy, 0x01, 0x03, host, port)
+	return
+}
+
 // DialType wil dial connection by proxy server and uri type
 func DialType(proxy string, uriType byte, uri string) (conn net.Conn, err error) {
-	proxyNetwork, proxyAddr := "", ""
-	if strings.HasPrefix(proxy, "socks5://") {
-		proxyNetwork = "tcp"
-		proxyAddr = strings.TrimPrefix(proxy, "socks5://")
-	} else if strings.HasPrefix(proxy, "tcp://") {
-		proxyNetwork = "tcp"
-		proxyAddr = strings.TrimPrefix(proxy, "tcp://")
-	} else if strings.HasPrefix(proxy, "unix://") {
-		proxyNetwork = "unix"
-		proxyAddr = strings.TrimPrefix(proxy, "unix://")
-	} else {
-		proxyNetwork = "tcp"
-		proxyAddr = proxy
+	var host string
+	var port int
+	switch uriType {
+	case 0x01, 0x03, 0x04:
+		var p string
+		host, p, _ = net.SplitHostPort(uri)
+		port, _ = strconv.Atoi(p)
+	default:
+		host = uri
 	}
-	conn, err = net.Dial(proxyNetwork, proxyAddr)
+	conn, _, err = dialRequest(nil, proxy, 0x01, uriType, host, port)
+	return
+}
+
+// dialRequest will dial to proxy server by dialer or net.Dial when nil, send command request and read the reply bind address
+func dialRequest(dialer xnet.RawDialer, proxy string, cmd, uriType byte, host string, port int) (conn net.Conn, bind string, err error) {
+	conn, bind, err = dialRequestContext(context.Background(), dialer, proxy, cmd, uriType, host, port)
+	return
+}
+
+// dialRequestContext is dialRequest with context, the connection is closed when ctx is done before reply
+func dialRequestContext(ctx context.Context, dialer xnet.RawDialer, proxy string, cmd, uriType byte, host string, port int) (conn net.Conn, bind string, err error) {
+	proxyNetwork, proxyAddr, username, password := parseProxy(proxy)
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	conn, err = xnet.DialRawContext(ctx, dialer, proxyNetwork, proxyAddr)
 	if err != nil {
 		return
 	}
-	conn.Write([]byte{0x05, 0x01, 0x00})
+	stop := xnet.CloseOnDone(ctx, conn)
+	defer func() {
+		if xerr := stop(); err == nil && xerr != nil {
+			err = xerr
+			conn.Close()
+		}
+		if err != nil {
+			conn = nil
+		}
+	}()
 	buf := make([]byte, 1024*64)
-	err = xio.FullBuffer(conn, buf, 2, nil)
+	err = clientAuth(conn, buf, username, password)
 	if err != nil {
 		conn.Close()
 		return
 	}
-	if buf[0] != 0x05 || buf[1] != 0x00 {
-		err = fmt.Errorf("unsupported %x", buf)
+	request := append(buf[:0], 0x05, cmd, 0x00)
+	switch uriType {
+	case 0x01, 0x04:
+		request, err = appendAddress(request, host, port)
+	default:
+		request = append(request, uriType, byte(len(host)))
+		request = append(request, []byte(host)...)
+		request = append(request, byte(port/256), byte(port%256))
+	}
+	if err != nil {
 		conn.Close()
 		return
 	}
-	var host string
-	var port int
-	if uriType == 0x03 {
-		var p string
-		host, p, _ = net.SplitHostPort(uri)
-		port, _ = strconv.Atoi(p)
-	} else {
-		host = uri
+	conn.Write(request)
+	bind, err = readReply(conn, buf)
+	if err != nil {
+		conn.Close()
 	}
-	blen := len(host) + 7
-	buf[0], buf[1], buf[2] = 0x05, 0x01, 0x00
-	buf[3], buf[4] = uriType, byte(len(host))
-	copy(buf[5:], []byte(host))
-	buf[blen-2] = byte(port / 256)
-	buf[blen-1] = byte(port % 256)
-	conn.Write(buf[:blen])
+	return
+}
+
+// readReply will read the socks5 reply and return the bind address
+func readReply(conn net.Conn, buf []byte) (bind string, err error) {
 	err = xio.FullBuffer(conn, buf, 5, nil)
 	if err != nil {
-		conn.Close()
 		return
 	}
 	n := 0
@@ -252,13 +536,12 @@ func DialType(proxy string, uriType byte, uri string) (conn net.Conn, err error)
 		n = 5 + int(buf[4]) + 2
 		err = xio.FullBuffer(conn, buf[5:], uint32(buf[4])+2, nil)
 	case 0x04:
-		n = 5 + int(buf[4]) + 17
+		n = 22
 		err = xio.FullBuffer(conn, buf[5:], 17, nil)
 	default:
 		err = fmt.Errorf("reply address type is not supported:%v", buf[3])
 	}
 	if err != nil {
-		conn.Close()
 		return
 	}
 	if buf[1] == 0x10 {
@@ -269,14 +552,94 @@ func DialType(proxy string, uriType byte, uri string) (conn net.Conn, err error)
 			err = xio.FullBuffer(conn, buf[n+2:], uint32(messageLen), nil)
 		}
 		if err == nil {
-			err = fmt.Errorf("%v", string(buf[n+2:n+2+messageLen]))
+			err = &ReplyError{Reply: ReplyMessage, Message: string(buf[n+2 : n+2+messageLen])}
 		}
 		return
 	}
-	if buf[1] != 0x00 {
-		conn.Close()
-		err = fmt.Errorf("socks server response code(%x)", buf[1])
+	if buf[1] != ReplySucceeded {
+		err = &ReplyError{Reply: buf[1]}
 		return
 	}
+	host, port, _, err := parseAddress(buf[3:n])
+	if err == nil {
+		bind = net.JoinHostPort(host, strconv.Itoa(port))
+	}
+	return
+}
+
+func parseProxy(proxy string) (network, address, username, password string) {
+	if strings.HasPrefix(proxy, "socks5://") {
+		network = "tcp"
+		address = strings.TrimPrefix(proxy, "socks5://")
+	} else if strings.HasPrefix(proxy, "socks4://") {
+		network = "tcp"
+		address = strings.TrimPrefix(proxy, "socks4://")
+	} else if strings.HasPrefix(proxy, "socks4a://") {
+		network = "tcp"
+		address = strings.TrimPrefix(proxy, "socks4a://")
+	} else if strings.HasPrefix(proxy, "tcp://") {
+		network = "tcp"
+		address = strings.TrimPrefix(proxy, "tcp://")
+	} else if strings.HasPrefix(proxy, "unix://") {
+		network = "unix"
+		address = strings.TrimPrefix(proxy, "unix://")
+		return
+	} else {
+		network = "tcp"
+		address = proxy
+	}
+	if at := strings.LastIndex(address, "@"); at >= 0 {
+		userinfo := address[:at]
+		address = address[at+1:]
+		if colon := strings.Index(userinfo, ":"); colon >= 0 {
+			username, password = userinfo[:colon], userinfo[colon+1:]
+		} else {
+			username = userinfo
+		}
+		username, _ = url.PathUnescape(username)
+		password, _ = url.PathUnescape(password)
+	}
+	address = strings.TrimSuffix(address, "/")
+	return
+}
+
+func clientAuth(conn net.Conn, buf []byte, username, password string) (err error) {
+	if len(username) > 0 {
+		conn.Write([]byte{0x05, 0x02, 0x00, 0x02})
+	} else {
+		conn.Write([]byte{0x05, 0x01, 0x00})
+	}
+	err = xio.FullBuffer(conn, buf, 2, nil)
+	if err != nil {
+		return
+	}
+	if buf[0] != 0x05 {
+		err = fmt.Errorf("unsupported %x", buf[:2])
+		return
+	}
+	switch buf[1] {
+	case 0x00:
+	case 0xFF:
+		err = &ReplyError{Reply: ReplyNotAllowed, Message: "socks server no acceptable methods"}
+	case 0x02:
+		if len(username) > 255 || len(password) > 255 {
+			err = fmt.Errorf("username or password is too long")
+			return
+		}
+		blen := 3 + len(username) + len(password)
+		buf[0], buf[1] = 0x01, byte(len(username))
+		copy(buf[2:], []byte(username))
+		buf[2+len(username)] = byte(len(password))
+		copy(buf[3+len(username):], []byte(password))
+		_, err = conn.Write(buf[:blen])
+		if err == nil {
+			err = xio.FullBuffer(conn, buf, 2, nil)
+		}
+		if err == nil && buf[1] != 0x00 {
+			err = &ReplyError{Reply: ReplyNotAllowed, Message: fmt.Sprintf("socks server auth fail with code(%x)", buf[1])}
+		}
+	default:
+		err = fmt.Errorf("unsupported %x", buf[:2])
+	}
 	return
 }
@@ -0,0 +1,141 @@
+package socks
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+const (
+	//Reply4Granted is socks4 reply code for request granted
+	Reply4Granted byte = 0x5A
+	//Reply4Rejected is socks4 reply code for request rejected or failed
+	Reply4Rejected byte = 0x5B
+)
+
+// readString will read null-terminated string from reader
+func readString(r io.Reader, buf []byte) (value string, err error) {
+	for i := 0; i < len(buf); i++ {
+		err = xio.FullBuffer(r, buf[i:], 1, nil)
+		if err != nil {
+			return
+		}
+		if buf[i] == 0x00 {
+			value = string(buf[:i])
+			return
+		}
+	}
+	err = fmt.Errorf("string is too long")
+	return
+}
+
+// procSocks4 will process socks4/socks4a request, the version and command is read to buf[0:2]
+func (s *Server) procSocks4(conn io.ReadWriteCloser, buf []byte) (err error) {
+	cmd := buf[1]
+	err = xio.FullBuffer(conn, buf[2:], 6, nil)
+	if err != nil {
+		return
+	}
+	port := int(binary.BigEndian.Uint16(buf[2:4]))
+	ip := net.IPv4(buf[4], buf[5], buf[6], buf[7])
+	userid, err := readString(conn, buf[8:264])
+	if err != nil {
+		return
+	}
+	host := ip.String()
+	if buf[4] == 0x00 && buf[5] == 0x00 && buf[6] == 0x00 && buf[7] != 0x00 { //socks4a
+		host, err = readString(conn, buf[8:264])
+		if err != nil {
+			return
+		}
+	}
+	reply := []byte{0x00, Reply4Rejected, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
+	if s.Auth != nil {
+		err = fmt.Errorf("socks4 is not supported when auth is required")
+		conn.Write(reply)
+		WarnLog("Server socks4 proxy user %v on %v from %v fail with %v", userid, xio.LocalAddr(conn), xio.RemoteAddr(conn), err)
+		return
+	}
+	if cmd != 0x01 {
+		err = fmt.Errorf("socks4 command %x is not supported", cmd)
+		conn.Write(reply)
+		WarnLog("Server socks4 proxy on %v from %v fail with %v", xio.LocalAddr(conn), xio.RemoteAddr(conn), err)
+		return
+	}
+	uri := fmt.Sprintf("tcp://%v", net.JoinHostPort(host, strconv.Itoa(port)))
+	err = s.checkAccess(conn, uri)
+	if err != nil {
+		conn.Write(reply)
+		return
+	}
+	DebugLog("Server socks4 proxy start dial to %v on %v from %v", uri, xio.LocalAddr(conn), xio.RemoteAddr(conn))
+	raw, err := xio.DialPiperClient(xio.RemoteAddr(conn), s.Dialer, uri, s.BufferSize)
+	if err != nil {
+		conn.Write(reply)
+		DebugLog("Server socks4 proxy dial to %v on %v fail with %v", uri, xio.RemoteAddr(conn), err)
+		return
+	}
+	reply[1] = Reply4Granted
+	_, err = conn.Write(reply)
+	if err != nil {
+		raw.Close()
+		return
+	}
+	err = raw.PipeConn(conn, uri)
+	if err != xio.ErrAsyncRunning {
+		raw.Close()
+	}
+	return
+}
+
+// Dial4 will dial connection by socks4 proxy server, the socks4a is used when uri host is domain
+func Dial4(proxy, uri string) (conn net.Conn, err error) {
+	host, p, err := net.SplitHostPort(uri)
+	if err != nil {
+		return
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil {
+		return
+	}
+	request := []byte{0x04, 0x01, byte(port / 256), byte(port % 256)}
+	ip := net.ParseIP(host)
+	if ip != nil && ip.To4() == nil {
+		err = fmt.Errorf("socks4 is not supported ipv6 address %v", host)
+		return
+	}
+	if ip != nil {
+		request = append(request, ip.To4()...)
+	} else {
+		request = append(request, 0x00, 0x00, 0x00, 0x01)
+	}
+	proxyNetwork, proxyAddr, username, _ := parseProxy(proxy)
+	request = append(request, []byte(username)...)
+	request = append(request, 0x00)
+	if ip == nil {
+		request = append(request, []byte(host)...)
+		request = append(request, 0x00)
+	}
+	conn, err = net.Dial(proxyNetwork, proxyAddr)
+	if err != nil {
+		return
+	}
+	_, err = conn.Write(request)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	buf := make([]byte, 8)
+	err = xio.FullBuffer(conn, buf, 8, nil)
+	if err == nil && buf[1] != Reply4Granted {
+		err = fmt.Errorf("socks4 server response code(%x)", buf[1])
+	}
+	if err != nil {
+		conn.Close()
+	}
+	return
+}
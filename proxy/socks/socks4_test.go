@@ -0,0 +1,110 @@
+package socks
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+func TestSocks4(t *testing.T) {
+	var dialed string
+	server := NewServer()
+	server.Dialer = xio.PiperDialerF(func(uri string, bufferSize int) (raw xio.Piper, err error) {
+		dialed = uri
+		if uri == "tcp://127.0.0.1:10" {
+			err = fmt.Errorf("test error")
+			return
+		}
+		raw = xio.NewEchoPiper(bufferSize)
+		return
+	})
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	proxy := listener.Addr().String()
+	testEcho := func(proxy, uri, expect string) {
+		conn, err := Dial4(proxy, uri)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "abc" || dialed != expect {
+			t.Errorf("err:%v,data:%v,dialed:%v", err, string(buf[:n]), dialed)
+			return
+		}
+	}
+	testEcho(proxy, "127.0.0.1:80", "tcp://127.0.0.1:80")
+	testEcho("socks4://"+proxy, "127.0.0.1:80", "tcp://127.0.0.1:80")
+	testEcho("socks4a://abc@"+proxy, "localhost:80", "tcp://localhost:80")
+	{ //error
+		_, err = Dial4(proxy, "127.0.0.1:10")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = Dial4(proxy, "[::1]:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = Dial4(proxy, "xxx")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = Dial4(proxy, "127.0.0.1:x")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		_, err = Dial4("127.0.0.1:1", "127.0.0.1:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		conn, conb, _ := xio.CreatePipedConn()
+		go server.ProcConn(conb)
+		conn.Write([]byte{0x04, 0x02, 0x00, 0x50, 0x7f, 0x00, 0x00, 0x01, 0x00})
+		buf := make([]byte, 8)
+		xio.FullBuffer(conn, buf, 8, nil)
+		if buf[1] != Reply4Rejected {
+			t.Error("error")
+			return
+		}
+		conn.Close()
+		//
+		conn, conb, _ = xio.CreatePipedConn()
+		go server.ProcConn(conb)
+		conn.Write([]byte{0x04, 0x01, 0x00, 0x50, 0x00, 0x00, 0x00, 0x01, 0x00})
+		conn.Close()
+		//
+		conn, conb, _ = xio.CreatePipedConn()
+		go server.ProcConn(conb)
+		conn.Write([]byte{0x04, 0x01, 0x00, 0x50})
+		conn.Close()
+		//
+		_, err = readString(bytes.NewBufferString("abcdef"), make([]byte, 4))
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //auth required
+		server.Auth = StaticAuthenticator{"abc": "123"}
+		_, err = Dial4(proxy, "127.0.0.1:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		server.Auth = nil
+	}
+}
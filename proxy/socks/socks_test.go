@@ -2,9 +2,11 @@ package socks
 
 import (
 	"bufio"
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -12,6 +14,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/codingeasygo/util/proxy/acl"
 	"github.com/codingeasygo/util/xio"
 )
 
@@ -295,3 +298,166 @@ func TestDialError(t *testing.T) {
 	//
 	proxy.Stop()
 }
+
+func TestAuth(t *testing.T) {
+	proxy := NewServer()
+	proxy.Dialer = xio.PiperDialerF(func(uri string, bufferSize int) (raw xio.Piper, err error) {
+		raw = xio.NewEchoPiper(bufferSize)
+		return
+	})
+	proxy.Auth = StaticAuthenticator{"abc": "123"}
+	listener, err := proxy.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer proxy.Stop()
+	address := listener.Addr().String()
+	{ //ok
+		conn, err := Dial("socks5://abc:123@"+address, "127.0.0.1:80")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[0:n]) != "abc" {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+	{ //password error
+		_, err := Dial("socks5://abc:xxx@"+address, "127.0.0.1:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //not auth
+		_, err := Dial(address, "127.0.0.1:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //func
+		proxy.Auth = AuthenticatorF(func(username, password string) (err error) {
+			if username != "x" {
+				err = fmt.Errorf("not user")
+			}
+			return
+		})
+		conn, err := Dial("socks5://x:@"+address, "127.0.0.1:80")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+		_, err = Dial("socks5://y@"+address, "127.0.0.1:80")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //255 methods
+		proxy.Auth = StaticAuthenticator{"abc": "123"}
+		conn, conb, _ := xio.CreatePipedConn()
+		go proxy.ProcConn(conb)
+		hello := []byte{0x05, 0xFF}
+		for i := 0; i < 255; i++ {
+			hello = append(hello, byte(i))
+		}
+		conn.Write(hello)
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(conn, buf); err != nil || buf[0] != 0x05 || buf[1] != 0x02 {
+			t.Errorf("%v,%v", err, buf)
+			return
+		}
+		conn.Write([]byte{0x01, 0x03, 'a', 'b', 'c', 0x03, '1', '2', '3'})
+		if _, err := io.ReadFull(conn, buf); err != nil || buf[0] != 0x01 || buf[1] != 0x00 {
+			t.Errorf("%v,%v", err, buf)
+			return
+		}
+		conn.Close()
+	}
+	{ //error
+		conn, conb, _ := xio.CreatePipedConn()
+		go proxy.ProcConn(conb)
+		conn.Write([]byte{0x05, 0x01, 0x02})
+		conn.Read(make([]byte, 1024))
+		conn.Write([]byte{0x02, 0x00})
+		conn.Close()
+		//
+		conn, conb, _ = xio.CreatePipedConn()
+		go proxy.ProcConn(conb)
+		conn.Write([]byte{0x05, 0x01, 0x02})
+		conn.Read(make([]byte, 1024))
+		conn.Close()
+	}
+}
+
+func TestAccess(t *testing.T) {
+	proxy := NewServer()
+	proxy.Dialer = xio.NewEchoDialer()
+	rules := acl.NewACL()
+	rules.Load("deny host=10.0.0.0/8")
+	proxy.Access = rules
+	listener, err := proxy.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer proxy.Stop()
+	address := listener.Addr().String()
+	conn, err := Dial(address, "127.0.0.1:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	_, err = Dial(address, "10.0.0.1:80")
+	if ErrorReply(err) != ReplyNotAllowed {
+		t.Error(err)
+		return
+	}
+	_, err = Dial4(address, "10.0.0.1:80")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = DialBind(address, "10.0.0.1:80")
+	if ErrorReply(err) != ReplyNotAllowed {
+		t.Error(err)
+		return
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	server := NewServer()
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	drainConn, _ := net.Dial("tcp", listener.Addr().String())
+	killConn, _ := net.Dial("tcp", listener.Addr().String())
+	defer killConn.Close()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		drainConn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	drained, killed, err := server.Shutdown(ctx)
+	if err != context.DeadlineExceeded || drained != 1 || killed != 1 {
+		t.Errorf("%v,%v,%v", drained, killed, err)
+		return
+	}
+	if _, err = net.Dial("tcp", listener.Addr().String()); err == nil {
+		t.Error("error")
+		return
+	}
+}
@@ -0,0 +1,450 @@
+package socks
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+// Addr is net.Addr implement for socks5 address which may be domain
+type Addr struct {
+	Host string
+	Port int
+}
+
+// Network is net.Addr implement
+func (a *Addr) Network() string {
+	return "udp"
+}
+
+func (a *Addr) String() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+// appendAddress will append socks5 ATYP/ADDR/PORT to buf
+func appendAddress(buf []byte, host string, port int) (out []byte, err error) {
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			out = append(buf, 0x01)
+			out = append(out, ip4...)
+		} else {
+			out = append(buf, 0x04)
+			out = append(out, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			err = fmt.Errorf("host %v is too long", host)
+			return
+		}
+		out = append(buf, 0x03, byte(len(host)))
+		out = append(out, []byte(host)...)
+	}
+	out = append(out, byte(port/256), byte(port%256))
+	return
+}
+
+// parseAddress will parse socks5 ATYP/ADDR/PORT from buf and return the used length
+func parseAddress(buf []byte) (host string, port int, n int, err error) {
+	if len(buf) < 1 {
+		err = fmt.Errorf("address is too short")
+		return
+	}
+	switch buf[0] {
+	case 0x01:
+		n = 7
+		if len(buf) >= n {
+			host = net.IP(buf[1:5]).String()
+		}
+	case 0x03:
+		if len(buf) < 2 {
+			err = fmt.Errorf("address is too short")
+			return
+		}
+		n = 4 + int(buf[1])
+		if len(buf) >= n {
+			host = string(buf[2 : n-2])
+		}
+	case 0x04:
+		n = 19
+		if len(buf) >= n {
+			host = net.IP(buf[1:17]).String()
+		}
+	default:
+		err = fmt.Errorf("address type %x is not supported", buf[0])
+		return
+	}
+	if len(buf) < n {
+		err = fmt.Errorf("address is too short")
+		return
+	}
+	port = int(binary.BigEndian.Uint16(buf[n-2 : n]))
+	return
+}
+
+// EncodeUDPDatagram will encode payload to socks5 udp datagram by RFC 1928
+func EncodeUDPDatagram(buf []byte, host string, port int, payload []byte) (datagram []byte, err error) {
+	datagram, err = appendAddress(append(buf[:0], 0x00, 0x00, 0x00), host, port)
+	if err == nil {
+		datagram = append(datagram, payload...)
+	}
+	return
+}
+
+// DecodeUDPDatagram will decode socks5 udp datagram by RFC 1928
+func DecodeUDPDatagram(datagram []byte) (host string, port int, payload []byte, err error) {
+	if len(datagram) < 4 {
+		err = fmt.Errorf("datagram is too short")
+		return
+	}
+	if datagram[2] != 0x00 {
+		err = fmt.Errorf("datagram fragment %v is not supported", datagram[2])
+		return
+	}
+	host, port, n, err := parseAddress(datagram[3:])
+	if err == nil {
+		payload = datagram[3+n:]
+	}
+	return
+}
+
+func (s *Server) procUDP(conn io.ReadWriteCloser, buf []byte) (err error) {
+	var bindIP, clientIP net.IP
+	if netConn, ok := conn.(net.Conn); ok {
+		if addr, ok := netConn.LocalAddr().(*net.TCPAddr); ok {
+			bindIP = addr.IP
+		}
+		if addr, ok := netConn.RemoteAddr().(*net.TCPAddr); ok {
+			clientIP = addr.IP
+		}
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: bindIP})
+	if err != nil {
+		writeErrorReply(conn, buf, err)
+		WarnLog("Server socks proxy udp associate on %v from %v fail with %v", xio.LocalAddr(conn), xio.RemoteAddr(conn), err)
+		return
+	}
+	bind := relay.LocalAddr().(*net.UDPAddr)
+	_, err = writeReply(conn, buf, ReplySucceeded, bind.IP.String(), bind.Port)
+	if err != nil {
+		relay.Close()
+		return
+	}
+	DebugLog("Server socks proxy udp associate on %v from %v is started", bind, xio.RemoteAddr(conn))
+	associate := &udpAssociate{
+		server:   s,
+		control:  conn,
+		relay:    relay,
+		clientIP: clientIP,
+		sessions: map[string]*udpSession{},
+	}
+	go associate.loopRead()
+	//the association is terminated when control connection is closed
+	_, err = io.Copy(ioutil.Discard, conn)
+	associate.Close()
+	DebugLog("Server socks proxy udp associate on %v from %v is stopped", bind, xio.RemoteAddr(conn))
+	return
+}
+
+type udpAssociate struct {
+	server   *Server
+	control  io.ReadWriteCloser
+	relay    *net.UDPConn
+	clientIP net.IP
+	client   *net.UDPAddr
+	sessions map[string]*udpSession
+	closed   bool
+	lock     sync.RWMutex
+}
+
+func (u *udpAssociate) loopRead() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := u.relay.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		if u.clientIP != nil && !u.clientIP.IsUnspecified() && !u.clientIP.Equal(from.IP) {
+			continue
+		}
+		host, port, payload, err := DecodeUDPDatagram(buf[:n])
+		if err != nil {
+			DebugLog("Server socks proxy udp associate on %v drop datagram from %v by %v", u.relay.LocalAddr(), from, err)
+			continue
+		}
+		u.lock.Lock()
+		u.client = from
+		u.lock.Unlock()
+		session, err := u.session(host, port)
+		if err != nil {
+			WarnLog("Server socks proxy udp associate on %v to %v:%v fail with %v", u.relay.LocalAddr(), host, port, err)
+			continue
+		}
+		session.push(payload)
+	}
+}
+
+// session will return the session of target or start new one, the new session is dialed in background,
+// so the slow target is not blocking the read runner and the datagram is queued while dialing
+func (u *udpAssociate) session(host string, port int) (session *udpSession, err error) {
+	target := net.JoinHostPort(host, strconv.Itoa(port))
+	u.lock.Lock()
+	defer u.lock.Unlock()
+	if session = u.sessions[target]; session != nil {
+		session.active()
+		return
+	}
+	if u.closed {
+		err = fmt.Errorf("udp associate is closed")
+		return
+	}
+	if u.server.UDPMaxSessions > 0 && len(u.sessions) >= u.server.UDPMaxSessions {
+		err = fmt.Errorf("too many udp session(%v)", len(u.sessions))
+		return
+	}
+	session = &udpSession{
+		associate: u,
+		target:    target,
+		host:      host,
+		port:      port,
+		timeout:   u.server.UDPTimeout,
+		queue:     make(chan []byte, 64),
+		done:      make(chan int),
+	}
+	if session.timeout > 0 {
+		session.timer = time.AfterFunc(session.timeout, func() { session.Close() })
+	}
+	u.sessions[target] = session
+	go u.procSession(session)
+	return
+}
+
+func (u *udpAssociate) procSession(session *udpSession) {
+	defer session.Close()
+	uri := "udp://" + session.target
+	err := u.server.checkAccess(u.control, uri)
+	if err != nil {
+		return
+	}
+	raw, err := xio.DialPiperClient(xio.RemoteAddr(u.control), u.server.Dialer, uri, u.server.BufferSize)
+	if err != nil {
+		WarnLog("Server socks proxy udp associate on %v dial to %v fail with %v", u.relay.LocalAddr(), session.target, err)
+		return
+	}
+	err = raw.PipeConn(session, uri)
+	if err != xio.ErrAsyncRunning {
+		raw.Close()
+	}
+}
+
+func (u *udpAssociate) remove(session *udpSession) {
+	u.lock.Lock()
+	if u.sessions[session.target] == session {
+		delete(u.sessions, session.target)
+	}
+	u.lock.Unlock()
+}
+
+func (u *udpAssociate) writeTo(host string, port int, payload []byte) (n int, err error) {
+	u.lock.RLock()
+	client := u.client
+	u.lock.RUnlock()
+	datagram, err := EncodeUDPDatagram(make([]byte, 0, len(payload)+262), host, port, payload)
+	if err == nil {
+		_, err = u.relay.WriteToUDP(datagram, client)
+	}
+	if err == nil {
+		n = len(payload)
+	}
+	return
+}
+
+func (u *udpAssociate) Close() (err error) {
+	err = u.relay.Close()
+	u.lock.Lock()
+	u.closed = true
+	sessions := u.sessions
+	u.sessions = map[string]*udpSession{}
+	u.lock.Unlock()
+	for _, session := range sessions {
+		session.Close()
+	}
+	return
+}
+
+// udpSession is io.ReadWriteCloser for one udp target, each Read/Write is one datagram,
+// it is closed when nothing is transferred in timeout
+type udpSession struct {
+	associate *udpAssociate
+	target    string
+	host      string
+	port      int
+	timeout   time.Duration
+	timer     *time.Timer
+	queue     chan []byte
+	done      chan int
+	closer    sync.Once
+}
+
+func (u *udpSession) active() {
+	if u.timer != nil {
+		u.timer.Reset(u.timeout)
+	}
+}
+
+func (u *udpSession) push(payload []byte) {
+	data := make([]byte, len(payload))
+	copy(data, payload)
+	select {
+	case u.queue <- data:
+	case <-u.done:
+	default: //drop datagram when queue is full
+	}
+}
+
+func (u *udpSession) Read(p []byte) (n int, err error) {
+	select {
+	case data := <-u.queue:
+		n = copy(p, data)
+	case <-u.done:
+		err = io.EOF
+	}
+	return
+}
+
+func (u *udpSession) Write(p []byte) (n int, err error) {
+	u.active()
+	n, err = u.associate.writeTo(u.host, u.port, p)
+	return
+}
+
+func (u *udpSession) Close() (err error) {
+	u.closer.Do(func() {
+		close(u.done)
+		if u.timer != nil {
+			u.timer.Stop()
+		}
+		u.associate.remove(u)
+	})
+	return
+}
+
+func (u *udpSession) String() string {
+	return net.JoinHostPort(u.host, strconv.Itoa(u.port))
+}
+
+// UDPConn is net.PacketConn implement by socks5 udp associate
+type UDPConn struct {
+	raw     *net.UDPConn
+	control net.Conn
+	relay   *net.UDPAddr
+}
+
+// DialUDP will send udp associate to proxy server and return the packet connection to relay datagram
+func DialUDP(proxy string) (conn *UDPConn, err error) {
+	control, bind, err := dialRequest(nil, proxy, 0x03, 0x01, "0.0.0.0", 0)
+	if err != nil {
+		return
+	}
+	relay, err := net.ResolveUDPAddr("udp", bind)
+	if err != nil {
+		control.Close()
+		return
+	}
+	if relay.IP.IsUnspecified() {
+		if addr, ok := control.RemoteAddr().(*net.TCPAddr); ok {
+			relay.IP = addr.IP
+		}
+	}
+	raw, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		control.Close()
+		return
+	}
+	conn = &UDPConn{
+		raw:     raw,
+		control: control,
+		relay:   relay,
+	}
+	return
+}
+
+// ReadFrom will read one datagram from relay and return the source address
+func (u *UDPConn) ReadFrom(p []byte) (n int, addr net.Addr, err error) {
+	buf := make([]byte, len(p)+262)
+	for {
+		readed, from, xerr := u.raw.ReadFromUDP(buf)
+		if xerr != nil {
+			err = xerr
+			break
+		}
+		if !from.IP.Equal(u.relay.IP) || from.Port != u.relay.Port {
+			continue
+		}
+		host, port, payload, xerr := DecodeUDPDatagram(buf[:readed])
+		if xerr != nil {
+			continue
+		}
+		n = copy(p, payload)
+		if ip := net.ParseIP(host); ip != nil {
+			addr = &net.UDPAddr{IP: ip, Port: port}
+		} else {
+			addr = &Addr{Host: host, Port: port}
+		}
+		break
+	}
+	return
+}
+
+// WriteTo will write one datagram to addr by relay
+func (u *UDPConn) WriteTo(p []byte, addr net.Addr) (n int, err error) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return
+	}
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return
+	}
+	datagram, err := EncodeUDPDatagram(make([]byte, 0, len(p)+262), host, portNum, p)
+	if err == nil {
+		_, err = u.raw.WriteToUDP(datagram, u.relay)
+	}
+	if err == nil {
+		n = len(p)
+	}
+	return
+}
+
+// Close will close the relay and control connection
+func (u *UDPConn) Close() (err error) {
+	err = u.raw.Close()
+	u.control.Close()
+	return
+}
+
+// LocalAddr returns the local network address.
+func (u *UDPConn) LocalAddr() net.Addr {
+	return u.raw.LocalAddr()
+}
+
+// SetDeadline sets the read and write deadlines associated
+func (u *UDPConn) SetDeadline(t time.Time) error {
+	return u.raw.SetDeadline(t)
+}
+
+// SetReadDeadline sets the deadline for future ReadFrom calls
+func (u *UDPConn) SetReadDeadline(t time.Time) error {
+	return u.raw.SetReadDeadline(t)
+}
+
+// SetWriteDeadline sets the deadline for future WriteTo calls
+func (u *UDPConn) SetWriteDeadline(t time.Time) error {
+	return u.raw.SetWriteDeadline(t)
+}
@@ -0,0 +1,166 @@
+package socks
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+func TestUDPAssociate(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer echo.Close()
+	go xio.CopyPacketConn(echo, echo)
+	server := NewServer()
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	conn, err := DialUDP(listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	for i := 0; i < 3; i++ {
+		_, err = conn.WriteTo([]byte("abc"), echo.LocalAddr())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf := make([]byte, 1024)
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil || string(buf[:n]) != "abc" || from.String() != echo.LocalAddr().String() {
+			t.Errorf("err:%v,data:%v,from:%v", err, string(buf[:n]), from)
+			return
+		}
+	}
+	if conn.LocalAddr() == nil {
+		t.Error("error")
+		return
+	}
+	_, err = conn.WriteTo([]byte("abc"), &Addr{Host: "xx", Port: 10})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+}
+
+func TestUDPAssociateSession(t *testing.T) {
+	release := make(chan int)
+	dialed := int32(0)
+	server := NewServer()
+	server.UDPTimeout = 100 * time.Millisecond
+	server.UDPMaxSessions = 2
+	server.Dialer = xio.PiperDialerF(func(uri string, bufferSize int) (raw xio.Piper, err error) {
+		atomic.AddInt32(&dialed, 1)
+		if uri == "udp://127.0.0.1:1" {
+			<-release
+		}
+		raw = xio.NewEchoPiper(bufferSize)
+		return
+	})
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	conn, err := DialUDP(listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	slow, fast, other := &Addr{Host: "127.0.0.1", Port: 1}, &Addr{Host: "127.0.0.1", Port: 2}, &Addr{Host: "127.0.0.1", Port: 3}
+	buf := make([]byte, 1024)
+	//slow dial is not blocking other target
+	conn.WriteTo([]byte("slow"), slow)
+	conn.WriteTo([]byte("fast"), fast)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err := conn.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "fast" || from.String() != fast.String() {
+		t.Errorf("err:%v,data:%v,from:%v", err, string(buf[:n]), from)
+		return
+	}
+	//max sessions
+	conn.WriteTo([]byte("other"), other)
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, _, err = conn.ReadFrom(buf); err == nil {
+		t.Error(err)
+		return
+	}
+	//datagram is queued while dialing
+	close(release)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err = conn.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "slow" || from.String() != slow.String() {
+		t.Errorf("err:%v,data:%v,from:%v", err, string(buf[:n]), from)
+		return
+	}
+	//idle session is closed and dialed again
+	time.Sleep(300 * time.Millisecond)
+	dialedBefore := atomic.LoadInt32(&dialed)
+	conn.WriteTo([]byte("other"), other)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, from, err = conn.ReadFrom(buf)
+	if err != nil || string(buf[:n]) != "other" || from.String() != other.String() || atomic.LoadInt32(&dialed) != dialedBefore+1 {
+		t.Errorf("err:%v,data:%v,from:%v,dialed:%v", err, string(buf[:n]), from, dialed)
+		return
+	}
+}
+
+func TestUDPDatagram(t *testing.T) {
+	for _, host := range []string{"127.0.0.1", "::1", "localhost"} {
+		datagram, err := EncodeUDPDatagram(nil, host, 80, []byte("abc"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		h, p, payload, err := DecodeUDPDatagram(datagram)
+		if err != nil || h != host || p != 80 || string(payload) != "abc" {
+			t.Errorf("err:%v,host:%v,port:%v,payload:%v", err, h, p, string(payload))
+			return
+		}
+	}
+	if _, _, _, err := DecodeUDPDatagram([]byte{0x00}); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, _, _, err := DecodeUDPDatagram([]byte{0x00, 0x00, 0x01, 0x01}); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, _, _, err := DecodeUDPDatagram([]byte{0x00, 0x00, 0x00, 0x09}); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, _, _, err := DecodeUDPDatagram([]byte{0x00, 0x00, 0x00, 0x01, 0x00}); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, _, _, err := DecodeUDPDatagram([]byte{0x00, 0x00, 0x00, 0x03}); err == nil {
+		t.Error("error")
+		return
+	}
+	if _, err := EncodeUDPDatagram(nil, string(make([]byte, 256)), 80, nil); err == nil {
+		t.Error("error")
+		return
+	}
+	addr := &Addr{Host: "localhost", Port: 80}
+	if addr.Network() != "udp" || addr.String() != "localhost:80" {
+		t.Error("error")
+		return
+	}
+}
@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/codingeasygo/util/xprop"
+)
+
+// ForwardEntry is one declarative forward of listen uri and router
+type ForwardEntry struct {
+	Name   string `json:"name"`
+	Listen string `json:"listen"`
+	Router string `json:"router"`
+}
+
+// ParseForwardEntry will parse forward entry from value like tcp://:8080 tcp://127.0.0.1:80
+func ParseForwardEntry(name, value string) (entry *ForwardEntry, err error) {
+	fields := strings.Fields(value)
+	if len(name) < 1 || len(fields) != 2 {
+		err = fmt.Errorf("forward %v=%v is invalid, the format is name=<listen> <router>", name, value)
+		return
+	}
+	_, err = url.Parse(fields[0])
+	if err != nil {
+		err = fmt.Errorf("forward %v listen %v is invalid by %v", name, fields[0], err)
+		return
+	}
+	entry = &ForwardEntry{Name: name, Listen: fields[0], Router: fields[1]}
+	return
+}
+
+// LoadForwardTable will load forward table from config section like
+//
+//	[forward]
+//	web=tcp://:8080 tcp://127.0.0.1:80
+//	dns=udp://:53 udp://8.8.8.8:53
+//	proxy=socks://:1080 ${HOST}
+//
+// the entries is sorted by name
+func LoadForwardTable(config *xprop.Config, section string) (entries []*ForwardEntry, err error) {
+	config.Range(section, func(key string, val interface{}) {
+		if err != nil {
+			return
+		}
+		var entry *ForwardEntry
+		entry, err = ParseForwardEntry(key, fmt.Sprintf("%v", val))
+		if err == nil {
+			entries = append(entries, entry)
+		}
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return
+}
+
+// LoadForwardTableFile will load forward table from properties file section
+func LoadForwardTableFile(filename, section string) (entries []*ForwardEntry, err error) {
+	config, err := xprop.LoadConfWait(filename, false)
+	if err == nil {
+		entries, err = LoadForwardTable(config, section)
+	}
+	return
+}
+
+// Reload will diff entries with running forward, the forward not in entries or changed is stopped and the new or changed is started.
+// The error is returned after all entries is processed.
+func (f *Forward) Reload(entries []*ForwardEntry) (err error) {
+	wanted := map[string]*ForwardEntry{}
+	for _, entry := range entries {
+		wanted[entry.Name] = entry
+	}
+	f.forwardLck.RLock()
+	running := map[string]*forwardEntry{}
+	for name, entry := range f.forwardAll {
+		running[name] = entry
+	}
+	f.forwardLck.RUnlock()
+	var started, stopped int
+	for name, entry := range running {
+		want := wanted[name]
+		if want != nil && want.Listen == entry.listen.String() && want.Router == entry.router {
+			delete(wanted, name)
+			continue
+		}
+		f.StopForward(name)
+		stopped++
+	}
+	failed := []string{}
+	for _, entry := range entries {
+		if wanted[entry.Name] == nil {
+			continue
+		}
+		listen, xerr := url.Parse(entry.Listen)
+		if xerr == nil {
+			_, xerr = f.StartForward(entry.Name, listen, entry.Router)
+		}
+		if xerr != nil {
+			failed = append(failed, fmt.Sprintf("%v:%v", entry.Name, xerr))
+			continue
+		}
+		started++
+	}
+	InfoLog("Forward(%v) reload forward table done with %v started, %v stopped, %v failed", f.Name, started, stopped, len(failed))
+	if len(failed) > 0 {
+		err = fmt.Errorf("reload forward fail with %v", strings.Join(failed, ", "))
+	}
+	return
+}
+
+// ReloadConfig will load forward table from config section and reload
+func (f *Forward) ReloadConfig(config *xprop.Config, section string) (err error) {
+	entries, err := LoadForwardTable(config, section)
+	if err == nil {
+		err = f.Reload(entries)
+	}
+	return
+}
+
+// ReloadFile will load forward table from file section and reload
+func (f *Forward) ReloadFile(filename, section string) (err error) {
+	entries, err := LoadForwardTableFile(filename, section)
+	if err == nil {
+		err = f.Reload(entries)
+	}
+	return
+}
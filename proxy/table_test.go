@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xprop"
+)
+
+func TestForwardTable(t *testing.T) {
+	forward := NewForward("Test")
+	forward.Dialer = xio.NewEchoDialer()
+	defer forward.Stop()
+	config := xprop.NewConfig()
+	config.LoadPropString(`
+[forward]
+a=tcp://127.0.0.1:0 tcp://echo-a
+b=tcp://127.0.0.1:0 tcp://echo-b
+c=udp://127.0.0.1:0 tcp://echo-c
+`)
+	err := forward.ReloadConfig(config, "forward")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	forwards := forward.List()
+	if len(forwards) != 3 || forwards[0].Name != "a" || forwards[2].Scheme != "udp" || forwards[1].Router != "tcp://echo-b" {
+		t.Error(forwards)
+		return
+	}
+	addrA := forwards[0].Address
+	{ //active
+		conn, err := net.Dial("tcp", addrA)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 1024)
+		n, err := conn.Read(buf)
+		if err != nil || string(buf[:n]) != "abc" {
+			t.Error(err)
+			return
+		}
+		if forwards := forward.List(); forwards[0].Active != 1 || forwards[1].Active != 0 {
+			t.Error(forwards[0].Active)
+			return
+		}
+		conn.Close()
+	}
+	{ //reload by diff
+		config := xprop.NewConfig()
+		config.LoadPropString(`
+[forward]
+a=tcp://127.0.0.1:0 tcp://echo-a
+b=tcp://127.0.0.1:0 tcp://echo-x
+d=tcp://127.0.0.1:0 tcp://echo-d
+`)
+		err = forward.ReloadConfig(config, "forward")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		forwards := forward.List()
+		if len(forwards) != 3 || forwards[0].Address != addrA || forwards[1].Router != "tcp://echo-x" || forwards[2].Name != "d" {
+			t.Error(forwards)
+			return
+		}
+	}
+	{ //file
+		dir, _ := ioutil.TempDir("", "forward")
+		defer os.RemoveAll(dir)
+		filename := filepath.Join(dir, "forward.properties")
+		ioutil.WriteFile(filename, []byte("[forward]\na=tcp://127.0.0.1:0 tcp://echo-a\n"), os.ModePerm)
+		err = forward.ReloadFile(filename, "forward")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		forwards := forward.List()
+		if len(forwards) != 1 || forwards[0].Address != addrA {
+			t.Error(forwards)
+			return
+		}
+	}
+	{ //error
+		err = forward.ReloadFile("/none/forward.properties", "forward")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		config := xprop.NewConfig()
+		config.LoadPropString("[forward]\na=tcp://127.0.0.1:0\n")
+		err = forward.ReloadConfig(config, "forward")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		config = xprop.NewConfig()
+		config.LoadPropString("[forward]\nx=tcp://127.0.0.1:x tcp://echo\ny=%zz tcp://echo\n")
+		err = forward.ReloadConfig(config, "forward")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		err = forward.Reload([]*ForwardEntry{{Name: "x", Listen: "%zz", Router: "tcp://echo"}})
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+}
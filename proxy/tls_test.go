@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/codingeasygo/util/proxy/http"
+	"github.com/codingeasygo/util/proxy/socks"
+	"github.com/codingeasygo/util/xcrypto"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xnet"
+)
+
+func TestForwardTLS(t *testing.T) {
+	_, _, rootCertPEM, _, _, serverCertPEM, serverKeyPEM, _, clientCertPEM, clientKeyPEM, err := xcrypto.GenerateWebServerClient("test", "test ca", "localhost", "127.0.0.1", 2048)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	serverConfig, _ := xcrypto.NewServerTLSConfig(serverCertPEM, serverKeyPEM, rootCertPEM)
+	clientConfig, _ := xcrypto.NewClientTLSConfig(rootCertPEM, clientCertPEM, clientKeyPEM)
+	echo, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer echo.Close()
+	go func() {
+		for {
+			conn, err := echo.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	testEcho := func(conn net.Conn, err error) {
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 3)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "abc" {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+	}
+	forward := NewForward("Test")
+	defer forward.Stop()
+	forward.TLSConfig = serverConfig
+	forward.Dialer = &xio.NetPiperDialer{TLSConfig: clientConfig}
+	start := func(name, listen, router string) (address string) {
+		listenURL, _ := url.Parse(listen)
+		listener, err := forward.StartForward(name, listenURL, router)
+		if err != nil {
+			panic(err)
+		}
+		address = listener.Addr().String()
+		return
+	}
+	tlsDialer := xnet.NewTLSDialer(clientConfig)
+	{ //tls listen and tls dial
+		tlsAddress := start("tls", "tls://127.0.0.1:0", "tcp://"+echo.Addr().String())
+		tcpAddress := start("tcp", "tcp://127.0.0.1:0", "tls://"+tlsAddress)
+		testEcho(net.Dial("tcp", tcpAddress))
+		testEcho(tlsDialer.Dial("tcp", tlsAddress))
+	}
+	{ //socks+tls
+		address := start("socks", "socks+tls://127.0.0.1:0", "${HOST}")
+		testEcho(socks.DialBy(tlsDialer, "socks5://"+address, echo.Addr().String()))
+	}
+	{ //https
+		address := start("https", "https://127.0.0.1:0", "${HOST}")
+		testEcho(http.DialBy(tlsDialer, "http://"+address, echo.Addr().String()))
+		testEcho(socks.DialBy(tlsDialer, "socks5://"+address, echo.Addr().String()))
+	}
+	{ //mutual tls fail
+		noCertConfig, _ := xcrypto.NewClientTLSConfig(rootCertPEM, nil, nil)
+		conn, err := xnet.NewTLSDialer(noCertConfig).Dial("tcp", forward.List()[3].Address)
+		if err == nil {
+			buf := make([]byte, 1)
+			_, err = conn.Read(buf)
+			conn.Close()
+		}
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //not tls config
+		forward.TLSConfig = nil
+		listenURL, _ := url.Parse("tls://127.0.0.1:0")
+		if _, err = forward.StartForward("none", listenURL, "tcp://"+echo.Addr().String()); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingeasygo/util/xdebug"
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xio/frame"
+)
+
+// udpListener is net.Listener wrapper for udp forward, the Accept is blocked until closed
+type udpListener struct {
+	net.PacketConn
+	done   chan int
+	closer sync.Once
+}
+
+func newUDPListener(conn net.PacketConn) (listener *udpListener) {
+	listener = &udpListener{
+		PacketConn: conn,
+		done:       make(chan int),
+	}
+	return
+}
+
+func (u *udpListener) Accept() (conn net.Conn, err error) {
+	<-u.done
+	err = fmt.Errorf("udp listener is closed")
+	return
+}
+
+func (u *udpListener) Close() (err error) {
+	err = u.PacketConn.Close()
+	u.closer.Do(func() { close(u.done) })
+	return
+}
+
+func (u *udpListener) Addr() net.Addr {
+	return u.PacketConn.LocalAddr()
+}
+
+// udpForwardSession is io.ReadWriteCloser for one udp client, each Read/Write is one datagram
+type udpForwardSession struct {
+	key    string
+	client net.Addr
+	conn   net.PacketConn
+	queue  chan []byte
+	done   chan int
+	timer  *time.Timer
+	closer sync.Once
+	remove func(session *udpForwardSession)
+}
+
+func (u *udpForwardSession) push(payload []byte) {
+	data := make([]byte, len(payload))
+	copy(data, payload)
+	select {
+	case u.queue <- data:
+	case <-u.done:
+	default: //drop datagram when queue is full
+	}
+}
+
+func (u *udpForwardSession) active(timeout time.Duration) {
+	if u.timer != nil {
+		u.timer.Reset(timeout)
+	}
+}
+
+func (u *udpForwardSession) stopTimer() {
+	if u.timer != nil {
+		u.timer.Stop()
+	}
+}
+
+func (u *udpForwardSession) Read(p []byte) (n int, err error) {
+	select {
+	case data := <-u.queue:
+		n = copy(p, data)
+	case <-u.done:
+		err = io.EOF
+	}
+	return
+}
+
+func (u *udpForwardSession) Write(p []byte) (n int, err error) {
+	select {
+	case <-u.done:
+		err = io.ErrClosedPipe
+		return
+	default:
+	}
+	n, err = u.conn.WriteTo(p, u.client)
+	return
+}
+
+func (u *udpForwardSession) Close() (err error) {
+	u.closer.Do(func() {
+		close(u.done)
+		u.stopTimer()
+		u.remove(u)
+	})
+	return
+}
+
+func (u *udpForwardSession) RemoteAddr() net.Addr {
+	return u.client
+}
+
+func (u *udpForwardSession) String() string {
+	return u.client.String()
+}
+
+// startUDPForward will listen udp on listen.Host and forward datagram to router by per client session,
+// the datagram is relayed directly when router is udp://, else it is framed by xio/frame to stream
+func (f *Forward) startUDPForward(entry *forwardEntry) (listener net.Listener, err error) {
+	conn, err := net.ListenPacket(entry.listen.Scheme, entry.listen.Host)
+	if err != nil {
+		return
+	}
+	listener = newUDPListener(conn)
+	entry.listener = listener
+	go f.loopUDPForward(entry, conn)
+	return
+}
+
+func (f *Forward) loopUDPForward(entry *forwardEntry, conn net.PacketConn) {
+	defer f.removeForward(entry)
+	l, uri := entry.listener, entry.router
+	framed := !strings.HasPrefix(uri, "udp://")
+	sessions := map[string]*udpForwardSession{}
+	lock := sync.RWMutex{}
+	remove := func(session *udpForwardSession) {
+		lock.Lock()
+		if sessions[session.key] == session {
+			delete(sessions, session.key)
+		}
+		lock.Unlock()
+		entry.conns.Done(session)
+	}
+	InfoLog("Forward(%v) udp forward(%v->%v) read runner is starting", f.Name, l.Addr(), uri)
+	buf := make([]byte, 64*1024)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			break
+		}
+		key := from.String()
+		lock.RLock()
+		session := sessions[key]
+		lock.RUnlock()
+		if session == nil {
+			session = &udpForwardSession{
+				key:    key,
+				client: from,
+				conn:   conn,
+				queue:  make(chan []byte, 64),
+				done:   make(chan int),
+				remove: remove,
+			}
+			if f.UDPTimeout > 0 {
+				session.timer = time.AfterFunc(f.UDPTimeout, func() { session.Close() })
+			}
+			if !entry.conns.Add(session) {
+				session.stopTimer()
+				continue
+			}
+			lock.Lock()
+			sessions[key] = session
+			lock.Unlock()
+			DebugLog("Forward(%v) udp forward(%v->%v) session from %v is started", f.Name, l.Addr(), uri, from)
+			go f.procUDPForward(entry, session, framed, uri)
+		}
+		session.active(f.UDPTimeout)
+		session.push(buf[:n])
+	}
+	lock.Lock()
+	all := sessions
+	sessions = map[string]*udpForwardSession{}
+	lock.Unlock()
+	for _, session := range all {
+		session.Close()
+	}
+	l.Close()
+	InfoLog("Forward(%v) udp forward(%v->%v) read runner is stopped", f.Name, l.Addr(), uri)
+}
+
+// procUDPForward will dial uri for session and pipe it, the datagram is queued in session while dialing,
+// so the dial is not blocking the shared read runner
+func (f *Forward) procUDPForward(entry *forwardEntry, session *udpForwardSession, framed bool, uri string) {
+	l := entry.listener
+	defer func() {
+		if perr := recover(); perr != nil {
+			ErrorLog("Forward(%v) transfer udp forward (%v->%v) is pance with %v, callstack is \n%v", f.Name, l.Addr(), uri, perr, xdebug.CallStack())
+		}
+	}()
+	piper, err := xio.DialPiperClient(session.key, f.dialer(), uri, f.BufferSize)
+	if err != nil {
+		WarnLog("Forward(%v) udp forward(%v->%v) from %v fail with %v", f.Name, l.Addr(), uri, session.client, err)
+		session.Close()
+		return
+	}
+	piper = f.Sessions.Wrap("forward", uri, entry.wrap(piper))
+	var conn io.ReadWriteCloser = session
+	if framed {
+		conn = frame.NewPassReadWriteCloser(nil, session, 64*1024)
+	}
+	err = piper.PipeConn(conn, uri)
+	if err != xio.ErrAsyncRunning {
+		session.Close()
+	}
+	DebugLog("Forward(%v) udp forward(%v->%v) session from %v is stopped by %v", f.Name, l.Addr(), uri, session.client, err)
+}
@@ -0,0 +1,153 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+func TestUDPForward(t *testing.T) {
+	echo, _ := net.ListenPacket("udp", "127.0.0.1:0")
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, from, err := echo.ReadFrom(buf)
+			if err != nil {
+				break
+			}
+			echo.WriteTo(buf[:n], from)
+		}
+	}()
+	forward := NewForward("Test")
+	forward.UDPTimeout = 100 * time.Millisecond
+	forward.Dialer = xio.PiperDialerF(func(uri string, bufferSize int) (raw xio.Piper, err error) {
+		if uri == "tcp://echo" {
+			raw = xio.NewEchoPiper(bufferSize)
+		} else {
+			raw, err = xio.DialNetPiper(uri, bufferSize)
+		}
+		return
+	})
+	testEcho := func(address string) {
+		conn, err := net.Dial("udp", address)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 1024)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(conn, "abc%v", i)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			n, err := conn.Read(buf)
+			if err != nil || string(buf[:n]) != fmt.Sprintf("abc%v", i) {
+				t.Errorf("%v,%v", string(buf[:n]), err)
+				return
+			}
+		}
+	}
+	{ //raw udp
+		listener, err := forward.StartForward("udp", &url.URL{Scheme: "udp", Host: "127.0.0.1:0"}, "udp://"+echo.LocalAddr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		testEcho(listener.Addr().String())
+		testEcho(listener.Addr().String())
+		if forward.Sessions.Size() != 2 {
+			t.Error(forward.Sessions.Size())
+			return
+		}
+		time.Sleep(300 * time.Millisecond)
+		if forward.Sessions.Size() != 0 {
+			t.Error(forward.Sessions.Size())
+			return
+		}
+		forward.StopForward("udp")
+		_, err = listener.Accept()
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //framed stream
+		listener, err := forward.StartForward("framed", &url.URL{Scheme: "udp", Host: "127.0.0.1:0"}, "tcp://echo")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		testEcho(listener.Addr().String())
+	}
+	{ //dial error
+		listener, err := forward.StartForward("error", &url.URL{Scheme: "udp", Host: "127.0.0.1:0"}, "xx://echo")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn, _ := net.Dial("udp", listener.Addr().String())
+		fmt.Fprintf(conn, "abc")
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		_, err = conn.Read(make([]byte, 1024))
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+	{ //listen error
+		_, err := forward.StartForward("listen", &url.URL{Scheme: "udp", Host: "127.0.0.1:x"}, "udp://echo")
+		if err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	forward.Stop()
+	time.Sleep(10 * time.Millisecond)
+}
+
+func TestUDPForwardSlowDial(t *testing.T) {
+	release := make(chan int)
+	dialed := int32(0)
+	forward := NewForward("Test")
+	forward.UDPTimeout = 0
+	forward.Dialer = xio.PiperDialerF(func(uri string, bufferSize int) (raw xio.Piper, err error) {
+		if atomic.AddInt32(&dialed, 1) == 1 {
+			<-release
+		}
+		raw = xio.NewEchoPiper(bufferSize)
+		return
+	})
+	defer forward.Stop()
+	listener, err := forward.StartForward("slow", &url.URL{Scheme: "udp", Host: "127.0.0.1:0"}, "tcp://echo")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	slow, _ := net.Dial("udp", listener.Addr().String())
+	defer slow.Close()
+	fmt.Fprintf(slow, "slow")
+	time.Sleep(10 * time.Millisecond)
+	//other client is not blocked by slow dial
+	fast, _ := net.Dial("udp", listener.Addr().String())
+	defer fast.Close()
+	buf := make([]byte, 1024)
+	fmt.Fprintf(fast, "fast")
+	fast.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := fast.Read(buf); err != nil || string(buf[:n]) != "fast" {
+		t.Errorf("%v,%v", string(buf[:n]), err)
+		return
+	}
+	//datagram is queued while dialing
+	close(release)
+	slow.SetReadDeadline(time.Now().Add(time.Second))
+	if n, err := slow.Read(buf); err != nil || string(buf[:n]) != "slow" {
+		t.Errorf("%v,%v", string(buf[:n]), err)
+		return
+	}
+}
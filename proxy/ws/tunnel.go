@@ -0,0 +1,364 @@
+package ws
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xio/frame"
+	"github.com/codingeasygo/util/xnet"
+	"golang.org/x/net/websocket"
+)
+
+// serveTunnel will upgrade request to websocket and register names to agent mux session until it is closed,
+// the register is authorized by Authorization: Bearer <TunnelToken> header and all register is denied when TunnelToken is empty,
+// the name which is already registered by other agent is rejected.
+func (s *Server) serveTunnel(w http.ResponseWriter, req *http.Request, register string) {
+	if !s.checkTunnelToken(req) {
+		WarnLog("Server register tunnel %v from %v fail with token is invalid", register, req.RemoteAddr)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintf(w, "tunnel token is invalid")
+		return
+	}
+	names := strings.Split(register, ",")
+	for _, name := range names {
+		if len(name) < 1 {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, "tunnel name is empty")
+			return
+		}
+		if s.Access == nil {
+			continue
+		}
+		if err := s.Access.Check(req.RemoteAddr, "tunnel://"+name); err != nil {
+			WarnLog("Server register tunnel %v from %v fail with %v", name, req.RemoteAddr, err)
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "%v", err)
+			return
+		}
+	}
+	s.tunnelLck.RLock()
+	registered := s.registeredTunnel(names)
+	s.tunnelLck.RUnlock()
+	if len(registered) > 0 {
+		WarnLog("Server register tunnel %v from %v fail with already registered", registered, req.RemoteAddr)
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "tunnel %v is already registered", registered)
+		return
+	}
+	handler := func(conn *websocket.Conn) {
+		conn.PayloadType = websocket.BinaryFrame
+		session := frame.NewMuxSession(frame.NewReadWriteCloser(nil, conn, s.BufferSize), false)
+		if !s.conns.Add(session) {
+			session.Close()
+			return
+		}
+		defer s.conns.Done(session)
+		s.tunnelLck.Lock()
+		registered := s.registeredTunnel(names)
+		if len(registered) < 1 {
+			for _, name := range names {
+				s.tunnels[name] = session
+			}
+		}
+		s.tunnelLck.Unlock()
+		if len(registered) > 0 {
+			WarnLog("Server register tunnel %v from %v fail with already registered", registered, req.RemoteAddr)
+			session.Close()
+			return
+		}
+		InfoLog("Server tunnel agent %v is registered by %v", req.RemoteAddr, names)
+		<-session.Done()
+		s.tunnelLck.Lock()
+		for _, name := range names {
+			if s.tunnels[name] == session {
+				delete(s.tunnels, name)
+			}
+		}
+		s.tunnelLck.Unlock()
+		InfoLog("Server tunnel agent %v is unregistered by %v", req.RemoteAddr, names)
+	}
+	websocket.Server{Handler: handler}.ServeHTTP(w, req)
+}
+
+func (s *Server) checkTunnelToken(req *http.Request) bool {
+	if len(s.TunnelToken) < 1 {
+		return false
+	}
+	auth := req.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.TunnelToken)) == 1
+}
+
+// registeredTunnel will return the first name which is registered, it must be called with tunnelLck
+func (s *Server) registeredTunnel(names []string) (name string) {
+	for _, n := range names {
+		if s.tunnels[n] != nil {
+			name = n
+			break
+		}
+	}
+	return
+}
+
+// Tunnels will return all registered tunnel name
+func (s *Server) Tunnels() (names []string) {
+	s.tunnelLck.RLock()
+	for name := range s.tunnels {
+		names = append(names, name)
+	}
+	s.tunnelLck.RUnlock()
+	sort.Strings(names)
+	return
+}
+
+// DialTunnel will open stream to agent service by name
+func (s *Server) DialTunnel(name string) (stream *frame.MuxStream, err error) {
+	s.tunnelLck.RLock()
+	session := s.tunnels[name]
+	s.tunnelLck.RUnlock()
+	if session == nil {
+		err = fmt.Errorf("tunnel %v is not registered", name)
+		return
+	}
+	stream, err = session.Open(name)
+	return
+}
+
+// DialTunnelPiper will open stream to agent service by uri like tunnel://name, it can be used as xio.PiperDialerF
+func (s *Server) DialTunnelPiper(uri string, bufferSize int) (raw xio.Piper, err error) {
+	stream, err := s.DialTunnel(strings.TrimPrefix(uri, "tunnel://"))
+	if err == nil {
+		raw = xio.NewCopyPiper(stream, bufferSize)
+	}
+	return
+}
+
+// StartTunnel will listen on address and forward the accepted connection to agent service by name
+func (s *Server) StartTunnel(name, network, addr string) (listener net.Listener, err error) {
+	listener, err = net.Listen(network, addr)
+	if err == nil {
+		s.listners[listener] = addr
+		InfoLog("Server listen tunnel %v on %v", name, addr)
+		s.waiter.Add(1)
+		go s.loopTunnel(name, listener)
+	}
+	return
+}
+
+func (s *Server) loopTunnel(name string, l net.Listener) {
+	defer s.waiter.Done()
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			break
+		}
+		if !s.conns.Add(conn) {
+			conn.Close()
+			continue
+		}
+		go s.procTunnel(name, conn)
+	}
+}
+
+func (s *Server) procTunnel(name string, conn net.Conn) {
+	defer s.conns.Done(conn)
+	raw, err := s.DialTunnelPiper(name, s.BufferSize)
+	if err != nil {
+		WarnLog("Server tunnel %v from %v fail with %v", name, conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	DebugLog("Server start tunnel %v to %v", conn.RemoteAddr(), name)
+	err = raw.PipeConn(conn, name)
+	if err != xio.ErrAsyncRunning {
+		conn.Close()
+	}
+	DebugLog("Server tunnel %v to %v is done with %v", conn.RemoteAddr(), name, err)
+}
+
+// Agent is reverse tunnel agent to register local service on ws.Server by websocket,
+// the stream opened by server is forwarded to local service, it reconnects to server automatically.
+// The Token is sent by Authorization: Bearer header and it must be same as TunnelToken of ws.Server.
+type Agent struct {
+	Server     string
+	Token      string
+	Services   map[string]string
+	Dialer     *xnet.WebsocketDialer
+	Local      xio.PiperDialer
+	BufferSize int
+	RetryDelay time.Duration
+	session    *frame.MuxSession
+	exiter     chan int
+	waiter     sync.WaitGroup
+	lock       sync.RWMutex
+}
+
+// NewAgent will return new Agent by server uri like ws://host:port, token and services of name to local uri like tcp://127.0.0.1:80
+func NewAgent(server, token string, services map[string]string) (agent *Agent) {
+	agent = &Agent{
+		Server:     server,
+		Token:      token,
+		Services:   services,
+		Dialer:     xnet.NewWebsocketDialer(),
+		Local:      xio.PiperDialerF(xio.DialNetPiper),
+		BufferSize: 32 * 1024,
+		RetryDelay: 3 * time.Second,
+		waiter:     sync.WaitGroup{},
+		lock:       sync.RWMutex{},
+	}
+	return
+}
+
+// Start will start the agent runner
+func (a *Agent) Start() {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.exiter != nil {
+		return
+	}
+	a.exiter = make(chan int)
+	a.waiter.Add(1)
+	go a.loopConnect(a.exiter)
+}
+
+// Stop will stop the agent runner and close the connection to server
+func (a *Agent) Stop() {
+	a.lock.Lock()
+	exiter, session := a.exiter, a.session
+	a.exiter = nil
+	if exiter != nil {
+		close(exiter)
+	}
+	a.lock.Unlock()
+	if exiter == nil {
+		return
+	}
+	if session != nil {
+		session.Close()
+	}
+	a.waiter.Wait()
+}
+
+// Connected will return if agent is connected to server
+func (a *Agent) Connected() bool {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return a.session != nil
+}
+
+func (a *Agent) registerURI() string {
+	names := []string{}
+	for name := range a.Services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	register := url.QueryEscape(strings.Join(names, ","))
+	if strings.Contains(a.Server, "?") {
+		return a.Server + "&_register=" + register
+	}
+	return a.Server + "?_register=" + register
+}
+
+func (a *Agent) loopConnect(exiter chan int) {
+	defer a.waiter.Done()
+	InfoLog("Agent connect runner to %v is starting", a.Server)
+	for {
+		err := a.procConnect(exiter)
+		select {
+		case <-exiter:
+			InfoLog("Agent connect runner to %v is stopped", a.Server)
+			return
+		default:
+		}
+		WarnLog("Agent connection to %v is closed by %v, will retry after %v", a.Server, err, a.RetryDelay)
+		select {
+		case <-exiter:
+			InfoLog("Agent connect runner to %v is stopped", a.Server)
+			return
+		case <-time.After(a.RetryDelay):
+		}
+	}
+}
+
+func (a *Agent) dialer() (dialer *xnet.WebsocketDialer) {
+	having := *a.Dialer
+	dialer = &having
+	dialer.HeaderGen = func(remote string) (header http.Header) {
+		if a.Dialer.HeaderGen != nil {
+			header = a.Dialer.HeaderGen(remote)
+		}
+		if header == nil {
+			header = http.Header{}
+		}
+		header.Set("Authorization", "Bearer "+a.Token)
+		return
+	}
+	return
+}
+
+func (a *Agent) procConnect(exiter chan int) (err error) {
+	raw, err := a.dialer().Dial(a.registerURI())
+	if err != nil {
+		return
+	}
+	if conn, ok := raw.(*websocket.Conn); ok {
+		conn.PayloadType = websocket.BinaryFrame
+	}
+	session := frame.NewMuxSession(frame.NewReadWriteCloser(nil, raw, a.BufferSize), true)
+	a.lock.Lock()
+	select {
+	case <-exiter:
+		a.lock.Unlock()
+		session.Close()
+		return
+	default:
+	}
+	a.session = session
+	a.lock.Unlock()
+	InfoLog("Agent is connected to %v", a.Server)
+	for {
+		var stream *frame.MuxStream
+		stream, err = session.Accept()
+		if err != nil {
+			break
+		}
+		go a.procStream(stream)
+	}
+	a.lock.Lock()
+	a.session = nil
+	a.lock.Unlock()
+	session.Close()
+	return
+}
+
+func (a *Agent) procStream(stream *frame.MuxStream) {
+	uri, ok := a.Services[stream.Target]
+	if !ok {
+		WarnLog("Agent service %v is not exists", stream.Target)
+		stream.Close()
+		return
+	}
+	raw, err := a.Local.DialPiper(uri, a.BufferSize)
+	if err != nil {
+		WarnLog("Agent dial service %v by %v fail with %v", stream.Target, uri, err)
+		stream.Close()
+		return
+	}
+	DebugLog("Agent start service %v to %v", stream.Target, uri)
+	err = raw.PipeConn(stream, uri)
+	if err != xio.ErrAsyncRunning {
+		stream.Close()
+	}
+	DebugLog("Agent service %v to %v is done with %v", stream.Target, uri, err)
+}
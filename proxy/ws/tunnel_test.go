@@ -0,0 +1,165 @@
+package ws
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/proxy/acl"
+)
+
+func TestTunnel(t *testing.T) {
+	testListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer testListener.Close()
+	go func() {
+		for {
+			conn, err := testListener.Accept()
+			if err != nil {
+				break
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				io.Copy(c, c)
+			}(conn)
+		}
+	}()
+	server := NewServer()
+	server.TunnelToken = "123"
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer server.Stop()
+	agent := NewAgent(fmt.Sprintf("ws://%v", listener.Addr()), "123", map[string]string{
+		"echo": "tcp://" + testListener.Addr().String(),
+		"none": "tcp://127.0.0.1:2",
+	})
+	agent.RetryDelay = 50 * time.Millisecond
+	agent.Start()
+	agent.Start()
+	defer agent.Stop()
+	waitRegistered := func() {
+		for i := 0; i < 100 && len(server.Tunnels()) < 2; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if len(server.Tunnels()) != 2 || !agent.Connected() {
+			panic("not registered")
+		}
+	}
+	waitRegistered()
+	tunnel, err := server.StartTunnel("echo", "tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	testEcho := func() {
+		conn, err := net.Dial("tcp", tunnel.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 3)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "abc" {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+	}
+	for i := 0; i < 3; i++ {
+		testEcho()
+	}
+	//duplicate and token
+	for _, token := range []string{"123", "xxx", ""} {
+		other := NewAgent(fmt.Sprintf("ws://%v", listener.Addr()), token, map[string]string{"echo": "tcp://127.0.0.1:2"})
+		other.RetryDelay = 10 * time.Millisecond
+		other.Start()
+		time.Sleep(50 * time.Millisecond)
+		other.Stop()
+		if other.Connected() {
+			t.Error(token)
+			return
+		}
+		testEcho()
+	}
+	//reconnect
+	server.tunnelLck.RLock()
+	session := server.tunnels["echo"]
+	server.tunnelLck.RUnlock()
+	session.Close()
+	time.Sleep(20 * time.Millisecond)
+	waitRegistered()
+	testEcho()
+	//error
+	if _, err = server.DialTunnel("xx"); err == nil {
+		t.Error(err)
+		return
+	}
+	stream, err := server.DialTunnel("none")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	buf := make([]byte, 3)
+	if _, err = stream.Read(buf); err != io.EOF {
+		t.Error(err)
+		return
+	}
+	//stop
+	agent.Stop()
+	for i := 0; i < 100 && len(server.Tunnels()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(server.Tunnels()) > 0 || agent.Connected() {
+		t.Error("error")
+		return
+	}
+	//access
+	server.Access = acl.CheckerF(func(client, uri string) (err error) {
+		if uri == "tunnel://echo" {
+			err = &acl.DeniedError{Client: client, URI: uri}
+		}
+		return
+	})
+	agent.Start()
+	time.Sleep(100 * time.Millisecond)
+	if len(server.Tunnels()) > 0 {
+		t.Error("error")
+		return
+	}
+}
+
+func TestTunnelShutdown(t *testing.T) {
+	server := NewServer()
+	server.TunnelToken = "123"
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	agent := NewAgent(fmt.Sprintf("ws://%v", listener.Addr()), "123", map[string]string{"echo": "tcp://127.0.0.1:2"})
+	agent.RetryDelay = time.Second
+	agent.Start()
+	defer agent.Stop()
+	for i := 0; i < 100 && len(server.Tunnels()) < 1; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	drained, killed, err := server.Shutdown(ctx)
+	if err != context.DeadlineExceeded || drained != 0 || killed != 1 {
+		t.Errorf("%v,%v,%v", drained, killed, err)
+		return
+	}
+	for i := 0; i < 100 && len(server.Tunnels()) > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(server.Tunnels()) > 0 {
+		t.Error("not unregistered")
+		return
+	}
+}
@@ -2,14 +2,18 @@ package ws
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/codingeasygo/util/proxy/acl"
 	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xio/frame"
 	"github.com/codingeasygo/util/xnet"
 	"golang.org/x/net/websocket"
 )
@@ -18,10 +22,18 @@ type ContextKey string
 
 type Server struct {
 	*websocket.Server
-	BufferSize int
-	Dialer     xio.PiperDialer
-	waiter     sync.WaitGroup
-	listners   map[net.Listener]string
+	BufferSize  int
+	TLSConfig   *tls.Config
+	ProxyProto  bool
+	Dialer      xio.PiperDialer
+	Access      acl.Checker
+	TunnelToken string
+	Resume      *xnet.ResumeServer
+	waiter      sync.WaitGroup
+	listners    map[net.Listener]string
+	conns       *xio.ConnGroup
+	tunnels     map[string]*frame.MuxSession
+	tunnelLck   sync.RWMutex
 }
 
 func NewServer() (server *Server) {
@@ -30,6 +42,10 @@ func NewServer() (server *Server) {
 		Dialer:     xio.PiperDialerF(xio.DialNetPiper),
 		waiter:     sync.WaitGroup{},
 		listners:   map[net.Listener]string{},
+		conns:      xio.NewConnGroup(),
+		tunnels:    map[string]*frame.MuxSession{},
+		tunnelLck:  sync.RWMutex{},
+		Resume:     xnet.NewResumeServer(),
 	}
 	server.Server = &websocket.Server{Handler: server.handler}
 	return
@@ -46,6 +62,12 @@ func (s *Server) loopAccept(l net.Listener) (err error) {
 func (s *Server) Run(addr string) (err error) {
 	listener, err := net.Listen("tcp", addr)
 	if err == nil {
+		if s.ProxyProto {
+			listener = xio.NewProxyProtoListener(listener)
+		}
+		if s.TLSConfig != nil {
+			listener = tls.NewListener(listener, s.TLSConfig)
+		}
 		s.listners[listener] = addr
 		InfoLog("Server listen http proxy on %v", addr)
 		s.waiter.Add(1)
@@ -58,6 +80,12 @@ func (s *Server) Run(addr string) (err error) {
 func (s *Server) Start(network, addr string) (listener net.Listener, err error) {
 	listener, err = net.Listen(network, addr)
 	if err == nil {
+		if s.ProxyProto {
+			listener = xio.NewProxyProtoListener(listener)
+		}
+		if s.TLSConfig != nil {
+			listener = tls.NewListener(listener, s.TLSConfig)
+		}
 		s.listners[listener] = addr
 		InfoLog("Server listen http proxy on %v", addr)
 		s.waiter.Add(1)
@@ -77,15 +105,41 @@ func (s *Server) Stop() (err error) {
 	return
 }
 
+// Shutdown will stop listener and wait running connection done until ctx is done, then close the remaining.
+// The drained is count of connection done by self and killed is count of connection closed by force.
+func (s *Server) Shutdown(ctx context.Context) (drained, killed int, err error) {
+	s.Stop()
+	drained, killed, err = s.conns.Shutdown(ctx)
+	InfoLog("Server websocket proxy is shutdown with %v drained, %v killed", drained, killed)
+	return
+}
+
 func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	req.ParseForm()
+	if register := req.Form.Get("_register"); len(register) > 0 {
+		s.serveTunnel(w, req, register)
+		return
+	}
 	uri := req.Form.Get("_uri")
 	if len(uri) < 1 {
 		w.WriteHeader(http.StatusBadRequest)
 		fmt.Fprintf(w, "_uri is required")
 		return
 	}
-	raw, err := s.Dialer.DialPiper(uri, s.BufferSize)
+	if s.Access != nil {
+		if err := s.Access.Check(req.RemoteAddr, uri); err != nil {
+			WarnLog("Server access to %v from %v fail with %v", uri, req.RemoteAddr, err)
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprintf(w, "%v", err)
+			return
+		}
+	}
+	if req.Form.Get("resume") == "1" && s.Resume != nil { //dial on new session, so the resumed connection is not dialing again
+		newReq := context.WithValue(req.Context(), ContextKey("resume"), uri)
+		s.Server.ServeHTTP(w, req.WithContext(newReq))
+		return
+	}
+	raw, err := xio.DialPiperClient(req.RemoteAddr, s.Dialer, uri, s.BufferSize)
 	if err != nil {
 		InfoLog("Server dial to %v fail with %v", uri, err)
 		w.WriteHeader(http.StatusBadGateway)
@@ -99,23 +153,76 @@ func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 func (s *Server) handler(conn *websocket.Conn) {
 	defer conn.Close()
 	req := conn.Request()
+	if uri, ok := req.Context().Value(ContextKey("resume")).(string); ok {
+		s.handleResume(conn, uri)
+		return
+	}
 	upstream := req.Context().Value(ContextKey("upstream")).([]interface{})
 	raw, uri := upstream[0].(xio.Piper), upstream[1].(string)
+	if !s.conns.Add(conn) {
+		raw.Close()
+		return
+	}
+	defer s.conns.Done(conn)
 	DebugLog("Server start forward %v to %v", req.RemoteAddr, uri)
 	err := raw.PipeConn(conn, uri)
 	DebugLog("Server forward %v to %v is done with %v", req.RemoteAddr, uri, err)
 }
 
+// handleResume will serve conn by Resume until it is broken, the uri is dialed when new session is accepted
+// and the session is kept for resuming by next connection of client when conn is broken
+func (s *Server) handleResume(conn *websocket.Conn, uri string) {
+	req := conn.Request()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	err := s.Resume.Serve(ctx, conn, func(resumed *xnet.ResumeConn) {
+		if !s.conns.Add(resumed) {
+			return
+		}
+		defer s.conns.Done(resumed)
+		raw, err := xio.DialPiperClient(req.RemoteAddr, s.Dialer, uri, s.BufferSize)
+		if err != nil {
+			InfoLog("Server dial to %v fail with %v", uri, err)
+			return
+		}
+		DebugLog("Server start forward %v to %v by %v", req.RemoteAddr, uri, resumed)
+		err = raw.PipeConn(resumed, uri)
+		DebugLog("Server forward %v to %v by %v is done with %v", req.RemoteAddr, uri, resumed, err)
+	})
+	DebugLog("Server resume connection from %v to %v is done with %v", req.RemoteAddr, uri, err)
+}
+
 // Dial will dial connection by proxy server
 func Dial(proxy, uri string) (conn net.Conn, err error) {
+	conn, err = DialBy(nil, proxy, uri)
+	return
+}
+
+// DialContext will dial connection by proxy server with context
+func DialContext(ctx context.Context, proxy, uri string) (conn net.Conn, err error) {
+	conn, err = DialByContext(ctx, nil, proxy, uri)
+	return
+}
+
+// DialBy will dial connection to uri by websocket proxy, the connection to proxy server is dialed by base or net.Dialer when nil
+func DialBy(base xnet.RawDialer, proxy, uri string) (conn net.Conn, err error) {
+	conn, err = DialByContext(context.Background(), base, proxy, uri)
+	return
+}
+
+// DialByContext will dial connection to uri by websocket proxy with context
+func DialByContext(ctx context.Context, base xnet.RawDialer, proxy, uri string) (conn net.Conn, err error) {
 	dialer := xnet.NewWebsocketDialer()
+	if base != nil {
+		dialer.Dialer = base
+	}
 	targetURI := proxy
 	if strings.Contains(proxy, "?") {
 		targetURI += fmt.Sprintf("&_uri=%v", url.QueryEscape(uri))
 	} else {
 		targetURI += fmt.Sprintf("?_uri=%v", url.QueryEscape(uri))
 	}
-	raw, err := dialer.Dial(targetURI)
+	raw, err := dialer.DialContext(ctx, targetURI)
 	if err == nil {
 		conn = raw.(net.Conn)
 	}
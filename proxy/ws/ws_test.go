@@ -1,10 +1,18 @@
 package ws
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
+	"net/url"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/proxy/acl"
+	"github.com/codingeasygo/util/xnet"
 )
 
 func TestProxy(t *testing.T) {
@@ -60,3 +68,140 @@ func TestProxy(t *testing.T) {
 		return
 	}
 }
+
+func TestAccess(t *testing.T) {
+	server := NewServer()
+	rules := acl.NewACL()
+	rules.Load("deny host=10.0.0.0/8")
+	server.Access = rules
+	listener, _ := server.Start("tcp", "127.0.0.1:0")
+	defer server.Stop()
+	_, err := Dial(fmt.Sprintf("ws://%v", listener.Addr()), "10.0.0.1:80")
+	if err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	testListener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer testListener.Close()
+	go func() {
+		for {
+			conn, err := testListener.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	server := NewServer()
+	listener, err := server.Start("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	proxyServer := fmt.Sprintf("ws://%v", listener.Addr())
+	drainConn, err := Dial(proxyServer, testListener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	killConn, err := Dial(proxyServer, testListener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer killConn.Close()
+	time.Sleep(50 * time.Millisecond)
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		drainConn.Close()
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	drained, killed, err := server.Shutdown(ctx)
+	if err != context.DeadlineExceeded || drained != 1 || killed != 1 {
+		t.Errorf("%v,%v,%v", drained, killed, err)
+		return
+	}
+}
+
+func TestResume(t *testing.T) {
+	backend, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer backend.Close()
+	dialed := int32(0)
+	go func() {
+		for {
+			conn, err := backend.Accept()
+			if err != nil {
+				break
+			}
+			atomic.AddInt32(&dialed, 1)
+			go io.Copy(conn, conn)
+		}
+	}()
+	server := NewServer()
+	listener, _ := server.Start("tcp", "127.0.0.1:0")
+	defer server.Stop()
+	//relay to break the websocket connection
+	relay, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer relay.Close()
+	relayed := []net.Conn{}
+	relayLck := sync.Mutex{}
+	go func() {
+		for {
+			conn, err := relay.Accept()
+			if err != nil {
+				break
+			}
+			remote, err := net.Dial("tcp", listener.Addr().String())
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			relayLck.Lock()
+			relayed = append(relayed, conn, remote)
+			relayLck.Unlock()
+			go io.Copy(remote, conn)
+			go io.Copy(conn, remote)
+		}
+	}()
+	dialer := xnet.NewReconnectDialer()
+	dialer.MinDelay = 10 * time.Millisecond
+	conn, err := dialer.Dial(fmt.Sprintf("ws://%v/?resume=1&_uri=%v", relay.Addr(), url.QueryEscape(backend.Addr().String())))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	buf := make([]byte, 3)
+	for i, data := range []string{"abc", "def", "ghi"} {
+		fmt.Fprintf(conn, "%v", data)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != data {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+		if i == 2 {
+			break
+		}
+		relayLck.Lock()
+		for _, c := range relayed {
+			c.Close()
+		}
+		relayed = nil
+		relayLck.Unlock()
+	}
+	if atomic.LoadInt32(&dialed) != 1 || server.Resume.Size() != 1 {
+		t.Errorf("%v,%v", atomic.LoadInt32(&dialed), server.Resume.Size())
+		return
+	}
+	conn.Close()
+	for i := 0; i < 100 && server.Resume.Size() > 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if server.Resume.Size() != 0 {
+		t.Error(server.Resume.Size())
+		return
+	}
+}
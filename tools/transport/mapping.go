@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xnet"
+	"github.com/codingeasygo/util/xprop"
+)
+
+// DrainTimeout is the timeout to wait running connection done when mapping is stopped by reload
+var DrainTimeout = 5 * time.Second
+
+// Mapping is one transport from local listen to remote
+type Mapping struct {
+	Name        string
+	Listen      string
+	Remote      string
+	Limiter     *xio.Limiter
+	Transporter xnet.Transporter
+	signature   string
+	layer       *xnet.LayerOption
+	listener    net.Listener
+	conns       *xio.ConnGroup
+	total       int64
+	rejected    int64
+	failed      int64
+}
+
+// NewMapping will return new Mapping by local/remote, the remote is transported by tcp or websocket
+func NewMapping(name, local, remote string, limiter *xio.Limiter, dialer *xnet.ReconnectDialer) (mapping *Mapping, err error) {
+	mapping = &Mapping{
+		Name:      name,
+		Listen:    local,
+		Remote:    remote,
+		Limiter:   limiter,
+		signature: local + " " + remote,
+		conns:     xio.NewConnGroup(),
+	}
+	if strings.HasPrefix(remote, "tcp://") {
+		mapping.Transporter = xnet.RawDialerF(net.Dial)
+	} else if strings.HasPrefix(remote, "ws://") || strings.HasPrefix(remote, "wss://") {
+		mapping.Transporter = dialer
+	} else {
+		err = fmt.Errorf("not supported remote %v", remote)
+	}
+	return
+}
+
+// LoadMapping will load Mapping from config section like
+//
+//	[web]
+//	listen=:8080?psk=xxx
+//	remote=wss://example.com/ws?compress=flate&psk=xxx&resume=1
+//	header.Authorization=Bearer xxx
+//	skip_verify=0
+//	server_name=example.com
+//	ca=/etc/ca.pem
+//	session_rate=0
+//	client_rate=0
+//	client_conn=0
+//	rate=0
+//	max_conn=0
+//
+// the rate is bytes per second and rate/max_conn is for all connection of mapping, the limit is default by base when it is not set,
+// the header and tls options is used by websocket remote. The websocket remote with resume=1 is redialed and resumed when it is broken,
+// so the transport is not closed by network change, the remote server must accept it by xnet.ResumeServer like proxy/ws,
+// and the transport is closed when server is restarted as the session is lost.
+func LoadMapping(config *xprop.Config, section string, base *xio.Limiter, dialer *xnet.ReconnectDialer) (mapping *Mapping, err error) {
+	values := []string{}
+	header := http.Header{}
+	prefix := section + "/"
+	config.Range(section, func(key string, val interface{}) {
+		if strings.Contains(key, "/") {
+			return //other section having same prefix
+		}
+		values = append(values, fmt.Sprintf("%v=%v", key, val))
+		if strings.HasPrefix(key, "header.") {
+			header.Set(strings.TrimPrefix(key, "header."), fmt.Sprintf("%v", val))
+		}
+	})
+	sort.Strings(values)
+	listen, remote := config.StrDef("", prefix+"listen"), config.StrDef("", prefix+"remote")
+	if len(listen) < 1 || len(remote) < 1 {
+		err = fmt.Errorf("mapping %v listen/remote is required", section)
+		return
+	}
+	limiter := xio.NewLimiter()
+	limiter.Session.Rate = config.Int64Def(base.Session.Rate, prefix+"session_rate")
+	limiter.Client.Rate = config.Int64Def(base.Client.Rate, prefix+"client_rate")
+	limiter.Client.MaxConn = config.IntDef(base.Client.MaxConn, prefix+"client_conn")
+	limiter.Global.Rate = config.Int64Def(base.Global.Rate, prefix+"rate")
+	limiter.Global.MaxConn = config.IntDef(base.Global.MaxConn, prefix+"max_conn")
+	tlsConfig := &tls.Config{
+		ServerName:         config.StrDef("", prefix+"server_name"),
+		InsecureSkipVerify: config.StrDef("0", prefix+"skip_verify") == "1",
+	}
+	if ca := config.StrDef("", prefix+"ca"); len(ca) > 0 {
+		var data []byte
+		data, err = ioutil.ReadFile(ca)
+		if err != nil {
+			err = fmt.Errorf("mapping %v load ca fail with %v", section, err)
+			return
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			err = fmt.Errorf("mapping %v ca %v is not having cert", section, ca)
+			return
+		}
+	}
+	reconnect := *dialer
+	reconnect.WebsocketDialer = &xnet.WebsocketDialer{
+		Dialer:    dialer.WebsocketDialer.Dialer,
+		TlsConfig: tlsConfig,
+	}
+	if len(header) > 0 {
+		reconnect.HeaderGen = func(remote string) http.Header { return header.Clone() }
+	}
+	mapping, err = NewMapping(section, listen, remote, limiter, &reconnect)
+	if err == nil {
+		mapping.signature = strings.Join(values, "\n")
+	}
+	return
+}
+
+// LoadMappings will load Mapping from all section of config, the mappings is sorted by name
+func LoadMappings(config *xprop.Config, base *xio.Limiter, dialer *xnet.ReconnectDialer) (mappings []*Mapping, err error) {
+	loaded := map[string]bool{}
+	for _, section := range config.Seces {
+		if loaded[section] {
+			continue
+		}
+		loaded[section] = true
+		var mapping *Mapping
+		mapping, err = LoadMapping(config, section, base, dialer)
+		if err != nil {
+			return
+		}
+		mappings = append(mappings, mapping)
+	}
+	sort.Slice(mappings, func(i, j int) bool { return mappings[i].Name < mappings[j].Name })
+	return
+}
+
+// hideLayer will return uri without compress/psk query to print on log
+func hideLayer(uri string) string {
+	if target, _, err := xnet.SplitLayerURI(uri); err == nil {
+		return target
+	}
+	return uri
+}
+
+// Start will listen on local and transport accepted connection to remote in background
+func (m *Mapping) Start() (err error) {
+	local, layer, err := xnet.SplitLayerURI(m.Listen)
+	if err != nil {
+		return
+	}
+	m.layer = layer
+	listener, err := net.Listen("tcp", local)
+	if err != nil {
+		return
+	}
+	m.listener = listener
+	InfoLog("start mapping %v on %v to %v", m.Name, listener.Addr(), hideLayer(m.Remote))
+	go m.loopAccept(listener)
+	return
+}
+
+func (m *Mapping) loopAccept(listener net.Listener) {
+	var err error
+	var conn net.Conn
+	for {
+		conn, err = listener.Accept()
+		if err != nil {
+			break
+		}
+		atomic.AddInt64(&m.total, 1)
+		limited, xerr := m.Limiter.Wrap(conn.RemoteAddr().String(), conn)
+		if xerr != nil {
+			WarnLog("reject transport %v to %v by %v", conn.RemoteAddr(), m.Name, xerr)
+			atomic.AddInt64(&m.rejected, 1)
+			conn.Close()
+			continue
+		}
+		if !m.conns.Add(limited) {
+			limited.Close()
+			continue
+		}
+		go m.transport(conn, limited)
+	}
+	InfoLog("mapping %v is done with %v", m.Name, err)
+}
+
+func (m *Mapping) transport(conn net.Conn, limited *xio.LimitReadWriteCloser) {
+	defer m.conns.Done(limited)
+	InfoLog("start transport %v to %v", conn.RemoteAddr(), m.Name)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	layered, err := xnet.ServerLayer(ctx, limited, m.layer)
+	cancel()
+	if err == nil {
+		err = m.Transporter.Transport(layered, m.Remote)
+	}
+	limited.Close()
+	if err != nil {
+		atomic.AddInt64(&m.failed, 1)
+	}
+	InfoLog("stop transport %v to %v by %v", conn.RemoteAddr(), m.Name, err)
+}
+
+// Stop will close the listener and close running connection after DrainTimeout
+func (m *Mapping) Stop() (err error) {
+	if m.listener == nil {
+		return
+	}
+	err = m.listener.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), DrainTimeout)
+	defer cancel()
+	drained, killed, _ := m.conns.Shutdown(ctx)
+	InfoLog("mapping %v is stopped with %v drained, %v killed", m.Name, drained, killed)
+	return
+}
+
+// Status will return the status line of mapping connection counts
+func (m *Mapping) Status() string {
+	return fmt.Sprintf("mapping %v on %v to %v having %v active, %v total, %v rejected, %v failed",
+		m.Name, hideLayer(m.Listen), hideLayer(m.Remote), m.conns.Size(), atomic.LoadInt64(&m.total), atomic.LoadInt64(&m.rejected), atomic.LoadInt64(&m.failed))
+}
+
+// MappingManager is the running mapping set which can be reloaded
+type MappingManager struct {
+	mappings map[string]*Mapping
+	waiter   sync.WaitGroup
+	lock     sync.RWMutex
+}
+
+// NewMappingManager will return new MappingManager
+func NewMappingManager() (manager *MappingManager) {
+	manager = &MappingManager{
+		mappings: map[string]*Mapping{},
+	}
+	return
+}
+
+// Reload will diff mappings with running, the mapping not in mappings is stopped, the new or changed is started,
+// and the untouched mapping is kept running. The changed mapping is started before the running one is stopped,
+// the running one is kept when the changed is failed to start. The error is returned after all mappings is processed.
+func (m *MappingManager) Reload(mappings []*Mapping) (err error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	wanted := map[string]*Mapping{}
+	for _, mapping := range mappings {
+		wanted[mapping.Name] = mapping
+	}
+	var started, stopped int
+	for name, running := range m.mappings {
+		if wanted[name] != nil {
+			continue
+		}
+		running.listener.Close() //release the address for other mapping
+		m.stop(running)
+		delete(m.mappings, name)
+		stopped++
+	}
+	failed := []string{}
+	for _, mapping := range mappings {
+		running := m.mappings[mapping.Name]
+		if running != nil && running.signature == mapping.signature {
+			continue
+		}
+		if xerr := m.replace(running, mapping); xerr != nil {
+			ErrorLog("mapping %v on %v to %v fail with %v", mapping.Name, hideLayer(mapping.Listen), hideLayer(mapping.Remote), xerr)
+			failed = append(failed, fmt.Sprintf("%v:%v", mapping.Name, xerr))
+			continue
+		}
+		if running != nil {
+			stopped++
+		}
+		started++
+	}
+	InfoLog("reload mapping done with %v started, %v stopped, %v failed", started, stopped, len(failed))
+	if len(failed) > 0 {
+		err = fmt.Errorf("reload mapping fail with %v", strings.Join(failed, ", "))
+	}
+	return
+}
+
+// replace will start mapping and stop running only when it is started, it must be called with lock.
+// The mapping is started again after running listener is released when the address is used by running,
+// and running is restarted when mapping is still failed.
+func (m *MappingManager) replace(running, mapping *Mapping) (err error) {
+	err = mapping.Start()
+	if err != nil && running != nil && errors.Is(err, syscall.EADDRINUSE) {
+		running.listener.Close()
+		if err = mapping.Start(); err != nil {
+			if xerr := running.Start(); xerr != nil {
+				ErrorLog("mapping %v on %v to %v restore fail with %v", running.Name, hideLayer(running.Listen), hideLayer(running.Remote), xerr)
+				m.stop(running)
+				delete(m.mappings, running.Name)
+			}
+			return
+		}
+	}
+	if err != nil {
+		return
+	}
+	if running != nil {
+		m.stop(running)
+	}
+	m.mappings[mapping.Name] = mapping
+	return
+}
+
+// stop will stop mapping in background, the Stop is waiting it done
+func (m *MappingManager) stop(mapping *Mapping) {
+	m.waiter.Add(1)
+	go func() {
+		defer m.waiter.Done()
+		mapping.Stop()
+	}()
+}
+
+// ReloadFile will load mappings from config file and reload
+func (m *MappingManager) ReloadFile(filename string, base *xio.Limiter, dialer *xnet.ReconnectDialer) (err error) {
+	config := xprop.NewConfig()
+	config.ShowLog = false
+	err = config.LoadWait(filename, false)
+	if err != nil {
+		return
+	}
+	mappings, err := LoadMappings(config, base, dialer)
+	if err == nil {
+		err = m.Reload(mappings)
+	}
+	return
+}
+
+// Mapping will return running mapping by name
+func (m *MappingManager) Mapping(name string) (mapping *Mapping) {
+	m.lock.RLock()
+	mapping = m.mappings[name]
+	m.lock.RUnlock()
+	return
+}
+
+// Stop will stop all running mapping and wait them done
+func (m *MappingManager) Stop() {
+	m.Reload(nil)
+	m.waiter.Wait()
+}
+
+// LogStatus will log the status line of each running mapping
+func (m *MappingManager) LogStatus() {
+	m.lock.RLock()
+	names := []string{}
+	for name := range m.mappings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		InfoLog("%v", m.mappings[name].Status())
+	}
+	m.lock.RUnlock()
+}
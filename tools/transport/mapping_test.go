@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+	"github.com/codingeasygo/util/xnet"
+	"github.com/codingeasygo/util/xprop"
+	"golang.org/x/net/websocket"
+)
+
+func runEchoServer() (listener net.Listener) {
+	listener, _ = net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return
+}
+
+func freeAddress() string {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func assertEcho(address string) (err error) {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	fmt.Fprintf(conn, "abc")
+	buf := make([]byte, 3)
+	if _, err = io.ReadFull(conn, buf); err == nil && string(buf) != "abc" {
+		err = fmt.Errorf("echo %v", string(buf))
+	}
+	return
+}
+
+func TestMappingManager(t *testing.T) {
+	echo := runEchoServer()
+	defer echo.Close()
+	//websocket remote checking header
+	ws, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer ws.Close()
+	go http.Serve(ws, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Token") != "123" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		websocket.Handler(func(conn *websocket.Conn) { io.Copy(conn, conn) }).ServeHTTP(w, r)
+	}))
+	dialer := xnet.NewReconnectDialer()
+	dialer.MaxAttempts = 1
+	DrainTimeout = 100 * time.Millisecond
+	webAddr, dnsAddr, wsAddr, pskAddr, pskServer := freeAddress(), freeAddress(), freeAddress(), freeAddress(), freeAddress()
+	data := fmt.Sprintf(`
+[web]
+listen=%v
+remote=tcp://%v
+[dns]
+listen=%v
+remote=tcp://%v
+max_conn=10
+[ws]
+listen=%v
+remote=ws://%v/ws
+header.X-Token=123
+[psk]
+listen=%v
+remote=tcp://%v?psk=123&compress=flate
+[pskserver]
+listen=%v?psk=123
+remote=tcp://%v
+`, webAddr, echo.Addr(), dnsAddr, echo.Addr(), wsAddr, ws.Addr(), pskAddr, pskServer, pskServer, echo.Addr())
+	config := xprop.NewConfig()
+	config.ShowLog = false
+	config.LoadPropString(data)
+	mappings, err := LoadMappings(config, xio.NewLimiter(), dialer)
+	if err != nil || len(mappings) != 5 || mappings[0].Name != "dns" || mappings[0].Limiter.Global.MaxConn != 10 {
+		t.Errorf("%v,%v", err, mappings)
+		return
+	}
+	manager := NewMappingManager()
+	if err = manager.Reload(mappings); err != nil {
+		t.Error(err)
+		return
+	}
+	for _, address := range []string{webAddr, dnsAddr, wsAddr, pskAddr} {
+		if err = assertEcho(address); err != nil {
+			t.Errorf("%v,%v", address, err)
+			return
+		}
+	}
+	web, wsMapping := manager.Mapping("web"), manager.Mapping("ws")
+	if status := web.Status(); !strings.Contains(status, "1 total") {
+		t.Error(status)
+		return
+	}
+	manager.LogStatus()
+	//reload with dns removed, web untouched, ws changed
+	filename := filepath.Join(t.TempDir(), "transport.properties")
+	ioutil.WriteFile(filename, []byte(fmt.Sprintf(`
+[web]
+listen=%v
+remote=tcp://%v
+[ws]
+listen=%v
+remote=ws://%v/ws
+header.X-Token=456
+`, webAddr, echo.Addr(), wsAddr, ws.Addr())), os.ModePerm)
+	if err = manager.ReloadFile(filename, xio.NewLimiter(), dialer); err != nil {
+		t.Error(err)
+		return
+	}
+	if manager.Mapping("web") != web || manager.Mapping("dns") != nil || manager.Mapping("ws") == nil || manager.Mapping("ws") == wsMapping {
+		t.Error("not reload")
+		return
+	}
+	if err = assertEcho(webAddr); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = assertEcho(dnsAddr); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = assertEcho(wsAddr); err == nil {
+		t.Error(err)
+		return
+	}
+	//reload error
+	if err = manager.ReloadFile(filepath.Join(t.TempDir(), "none.properties"), xio.NewLimiter(), dialer); err == nil {
+		t.Error(err)
+		return
+	}
+	ioutil.WriteFile(filename, []byte(fmt.Sprintf(`
+[web]
+listen=%v
+remote=tcp://%v
+[busy]
+listen=%v
+remote=tcp://%v
+`, webAddr, echo.Addr(), webAddr, echo.Addr())), os.ModePerm)
+	if err = manager.ReloadFile(filename, xio.NewLimiter(), dialer); err == nil || manager.Mapping("web") != web {
+		t.Error(err)
+		return
+	}
+	//changed mapping fail to start, the running is kept
+	busy, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer busy.Close()
+	for _, listen := range []string{webAddr + "?compress=xx", busy.Addr().String()} {
+		changed, _ := NewMapping("web", listen, "tcp://"+echo.Addr().String(), xio.NewLimiter(), dialer)
+		if err = manager.Reload([]*Mapping{changed}); err == nil || manager.Mapping("web") != web {
+			t.Errorf("%v,%v", listen, err)
+			return
+		}
+		if err = assertEcho(webAddr); err != nil {
+			t.Errorf("%v,%v", listen, err)
+			return
+		}
+	}
+	//changed mapping to other address
+	otherAddr := freeAddress()
+	changed, _ := NewMapping("web", otherAddr, "tcp://"+echo.Addr().String(), xio.NewLimiter(), dialer)
+	if err = manager.Reload([]*Mapping{changed}); err != nil || manager.Mapping("web") != changed {
+		t.Error(err)
+		return
+	}
+	if err = assertEcho(otherAddr); err != nil {
+		t.Error(err)
+		return
+	}
+	if err = assertEcho(webAddr); err == nil {
+		t.Error(err)
+		return
+	}
+	manager.Stop()
+	if err = assertEcho(otherAddr); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestLoadMappingError(t *testing.T) {
+	dialer := xnet.NewReconnectDialer()
+	for _, data := range []string{
+		"[a]\nlisten=:0",
+		"[a]\nlisten=:0\nremote=xx://127.0.0.1",
+		"[a]\nlisten=:0\nremote=ws://127.0.0.1\nca=/none/ca.pem",
+		"[a]\nlisten=:0\nremote=ws://127.0.0.1\nca=" + os.Args[0],
+	} {
+		config := xprop.NewConfig()
+		config.ShowLog = false
+		config.LoadPropString(data)
+		if _, err := LoadMappings(config, xio.NewLimiter(), dialer); err == nil {
+			t.Error(data)
+			return
+		}
+	}
+	mapping, _ := NewMapping("a", "127.0.0.1:0?compress=xx", "tcp://127.0.0.1", xio.NewLimiter(), dialer)
+	if err := mapping.Start(); err == nil {
+		t.Error(err)
+		return
+	}
+	if hideLayer("xx?%zz") != "xx?%zz" || hideLayer(":80?psk=1") != ":80" {
+		t.Error("hide")
+		return
+	}
+}
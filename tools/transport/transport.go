@@ -1,64 +1,113 @@
 package main
 
 import (
+	"flag"
 	"fmt"
-	"net"
 	"os"
-	"strings"
-	"sync"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/codingeasygo/util/xio"
 	"github.com/codingeasygo/util/xnet"
 )
 
-var waiter = sync.WaitGroup{}
+var limiter = xio.NewLimiter()
+var reconnect = xnet.NewReconnectDialer()
 
 func main() {
-	if len(os.Args) < 3 {
-		fmt.Printf(`Usage: transport <local> <remote> <local> <remote> ...`)
-		return
+	var configFile string
+	var status time.Duration
+	flags := flag.NewFlagSet("transport", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", "", "the mapping config file by section per mapping, it is reloaded by SIGHUP")
+	flags.DurationVar(&status, "status", time.Minute, "interval of logging connection counts of each mapping, 0 is disabled")
+	flags.Int64Var(&limiter.Session.Rate, "session-rate", 0, "max bytes per second of each connection, 0 is unlimited")
+	flags.Int64Var(&limiter.Client.Rate, "client-rate", 0, "max bytes per second of each client ip, 0 is unlimited")
+	flags.Int64Var(&limiter.Global.Rate, "global-rate", 0, "max bytes per second of all connection, 0 is unlimited")
+	flags.IntVar(&limiter.Client.MaxConn, "client-conn", 0, "max concurrent connection of each client ip, 0 is unlimited")
+	flags.IntVar(&limiter.Global.MaxConn, "global-conn", 0, "max concurrent connection of all, 0 is unlimited")
+	flags.IntVar(&reconnect.MaxAttempts, "retry", reconnect.MaxAttempts, "max attempts to dial websocket remote, 0 is unlimited")
+	flags.DurationVar(&reconnect.MinDelay, "retry-delay", reconnect.MinDelay, "min delay before retry to dial websocket remote, it is doubled by each attempt")
+	flags.DurationVar(&reconnect.MaxDelay, "retry-max-delay", reconnect.MaxDelay, "max delay before retry to dial websocket remote")
+	flags.DurationVar(&reconnect.KeepAlive, "keepalive", reconnect.KeepAlive, "interval of websocket ping, 0 is disabled")
+	flags.DurationVar(&reconnect.Timeout, "keepalive-timeout", reconnect.Timeout, "close websocket when nothing is received from remote in timeout")
+	flags.Usage = func() {
+		fmt.Printf("Usage: transport [options] <local> <remote> <local> <remote> ...\n")
+		fmt.Printf("       transport [options] -config <file>\n")
+		fmt.Printf("  the stream is compressed/encrypted by ?compress=flate&psk=xxx on remote, and accepted by ?psk=xxx on local of peer transport\n")
+		fmt.Printf("  the compress is applied before encrypt, do not combine them when the stream mixes secret with data influenced by attacker\n")
+		fmt.Printf("  the websocket remote with ?resume=1 is redialed and resumed without losing data when it is broken, the server must support resume\n")
+		flags.PrintDefaults()
 	}
-	mappings := os.Args[1:]
-	n := len(mappings) / 2
-	InfoLog("transport starting %v mapping by %v", n, mappings)
-	for i := 0; i < n; i++ {
-		waiter.Add(1)
-		go runMapping(mappings[i*2], mappings[i*2+1])
+	flags.Parse(os.Args[1:])
+	reconnect.OnState = onReconnectState
+	mappings := flags.Args()
+	if len(configFile) < 1 && len(mappings) < 2 {
+		flags.Usage()
+		return
 	}
-	waiter.Wait()
-	InfoLog("transport is done")
-}
-
-func runMapping(local, remote string) {
-	defer waiter.Done()
-	InfoLog("start mapping %v to %v", local, remote)
-	var transporter xnet.Transporter
-	if strings.HasPrefix(remote, "tcp://") {
-		transporter = xnet.RawDialerF(net.Dial)
-	} else if strings.HasPrefix(remote, "ws://") || strings.HasPrefix(remote, "wss://") {
-		transporter = xnet.NewWebsocketDialer()
+	manager := NewMappingManager()
+	if len(configFile) > 0 {
+		InfoLog("transport starting by config %v", configFile)
+		if err := manager.ReloadFile(configFile, limiter, reconnect); err != nil {
+			ErrorLog("transport load config %v fail with %v", configFile, err)
+		}
 	} else {
-		err := fmt.Errorf("not supported remote %v", remote)
-		ErrorLog("mapping %v to %v fail with %v", local, remote, err)
-		return
+		n := len(mappings) / 2
+		InfoLog("transport starting %v mapping", n)
+		loaded := []*Mapping{}
+		for i := 0; i < n; i++ {
+			mapping, err := NewMapping(fmt.Sprintf("mapping-%v", i), mappings[i*2], mappings[i*2+1], limiter, reconnect)
+			if err != nil {
+				ErrorLog("mapping %v to %v fail with %v", hideLayer(mappings[i*2]), hideLayer(mappings[i*2+1]), err)
+				continue
+			}
+			loaded = append(loaded, mapping)
+		}
+		manager.Reload(loaded)
 	}
-	ln, err := net.Listen("tcp", local)
-	if err != nil {
-		ErrorLog("mapping %v to %v fail with %v", local, remote, err)
-		return
+	var statusC <-chan time.Time
+	if status > 0 {
+		ticker := time.NewTicker(status)
+		defer ticker.Stop()
+		statusC = ticker.C
 	}
-	var conn net.Conn
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
 	for {
-		conn, err = ln.Accept()
-		if err != nil {
-			break
+		select {
+		case <-statusC:
+			manager.LogStatus()
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				InfoLog("transport is stopping by %v", sig)
+				manager.Stop()
+				InfoLog("transport is done")
+				return
+			}
+			if len(configFile) < 1 {
+				WarnLog("transport reload is skipped by not config mode")
+				continue
+			}
+			InfoLog("transport reloading config %v", configFile)
+			if err := manager.ReloadFile(configFile, limiter, reconnect); err != nil {
+				ErrorLog("transport reload config %v fail with %v", configFile, err)
+			}
 		}
-		waiter.Add(1)
-		go func() {
-			defer waiter.Done()
-			InfoLog("start transport %v to %v", conn.RemoteAddr(), remote)
-			xerr := transporter.Transport(conn, remote)
-			InfoLog("stop transport %v to %v by %v", conn.RemoteAddr(), remote, xerr)
-		}()
 	}
-	InfoLog("mapping %v to %v is done with %v", local, remote, err)
+}
+
+func onReconnectState(remote string, state xnet.ReconnectState, attempt int, err error) {
+	switch state {
+	case xnet.ReconnectConnecting:
+		DebugLog("connecting to %v by attempt %v", remote, attempt)
+	case xnet.ReconnectConnected:
+		DebugLog("connected to %v by attempt %v", remote, attempt)
+	case xnet.ReconnectRetrying:
+		WarnLog("connect to %v by attempt %v fail with %v, will retry", remote, attempt, err)
+	case xnet.ReconnectFailed:
+		ErrorLog("connect to %v fail with %v after %v attempts", remote, err, attempt)
+	case xnet.ReconnectDisconnected:
+		WarnLog("connection to %v is disconnected by %v", remote, err)
+	}
 }
@@ -0,0 +1,84 @@
+package xcrypto
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+func newCertPool(caPEM []byte) (pool *x509.CertPool, err error) {
+	pool = x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		err = fmt.Errorf("parse ca certificate fail")
+	}
+	return
+}
+
+// NewServerTLSConfig will return server tls config by cert/key pem, the client cert is required and verified by clientCAPEM when it is not empty
+func NewServerTLSConfig(certPEM, keyPEM, clientCAPEM []byte) (config *tls.Config, err error) {
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return
+	}
+	config = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+	if len(clientCAPEM) > 0 {
+		config.ClientCAs, err = newCertPool(clientCAPEM)
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return
+}
+
+// NewClientTLSConfig will return client tls config, the server cert is verified by caPEM when it is not empty, else by system roots,
+// the client cert is sent for mutual tls when certPEM/keyPEM is not empty
+func NewClientTLSConfig(caPEM, certPEM, keyPEM []byte) (config *tls.Config, err error) {
+	config = &tls.Config{}
+	if len(caPEM) > 0 {
+		config.RootCAs, err = newCertPool(caPEM)
+		if err != nil {
+			return
+		}
+	}
+	if len(certPEM) > 0 || len(keyPEM) > 0 {
+		var cert tls.Certificate
+		cert, err = tls.X509KeyPair(certPEM, keyPEM)
+		if err == nil {
+			config.Certificates = []tls.Certificate{cert}
+		}
+	}
+	return
+}
+
+func readFiles(filenames ...string) (datas [][]byte, err error) {
+	for _, filename := range filenames {
+		var data []byte
+		if len(filename) > 0 {
+			data, err = ioutil.ReadFile(filename)
+			if err != nil {
+				return
+			}
+		}
+		datas = append(datas, data)
+	}
+	return
+}
+
+// LoadServerTLSConfig will load server tls config from file, the mutual tls is enabled when clientCAFile is not empty
+func LoadServerTLSConfig(certFile, keyFile, clientCAFile string) (config *tls.Config, err error) {
+	datas, err := readFiles(certFile, keyFile, clientCAFile)
+	if err == nil {
+		config, err = NewServerTLSConfig(datas[0], datas[1], datas[2])
+	}
+	return
+}
+
+// LoadClientTLSConfig will load client tls config from file, the empty file is skipped
+func LoadClientTLSConfig(caFile, certFile, keyFile string) (config *tls.Config, err error) {
+	datas, err := readFiles(caFile, certFile, keyFile)
+	if err == nil {
+		config, err = NewClientTLSConfig(datas[0], datas[1], datas[2])
+	}
+	return
+}
@@ -0,0 +1,112 @@
+package xcrypto
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTLSConfig(t *testing.T) {
+	_, _, rootCertPEM, _, _, serverCertPEM, serverKeyPEM, _, clientCertPEM, clientKeyPEM, err := GenerateWebServerClient("test", "test ca", "a.test.com", "127.0.0.1", 2048)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	serverConfig, err := NewServerTLSConfig(serverCertPEM, serverKeyPEM, rootCertPEM)
+	if err != nil || serverConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Error(err)
+		return
+	}
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go func(c net.Conn) {
+				defer c.Close()
+				c.Write([]byte("ok"))
+			}(conn)
+		}
+	}()
+	dial := func(config *tls.Config) (err error) {
+		conn, err := tls.Dial("tcp", listener.Addr().String(), config)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 2)
+		_, err = conn.Read(buf)
+		return
+	}
+	clientConfig, err := NewClientTLSConfig(rootCertPEM, clientCertPEM, clientKeyPEM)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = dial(clientConfig); err != nil {
+		t.Error(err)
+		return
+	}
+	//not client cert
+	noCertConfig, _ := NewClientTLSConfig(rootCertPEM, nil, nil)
+	if err = dial(noCertConfig); err == nil {
+		t.Error(err)
+		return
+	}
+	//file
+	dir, _ := ioutil.TempDir("", "tls")
+	defer os.RemoveAll(dir)
+	files := map[string][]byte{"ca.pem": rootCertPEM, "server.pem": serverCertPEM, "server.key": serverKeyPEM, "client.pem": clientCertPEM, "client.key": clientKeyPEM}
+	for name, data := range files {
+		ioutil.WriteFile(filepath.Join(dir, name), data, 0600)
+	}
+	_, err = LoadServerTLSConfig(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	fileConfig, err := LoadClientTLSConfig(filepath.Join(dir, "ca.pem"), filepath.Join(dir, "client.pem"), filepath.Join(dir, "client.key"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if err = dial(fileConfig); err != nil {
+		t.Error(err)
+		return
+	}
+	//error
+	if _, err = NewServerTLSConfig(nil, nil, nil); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = NewServerTLSConfig(serverCertPEM, serverKeyPEM, []byte("xx")); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = NewClientTLSConfig([]byte("xx"), nil, nil); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = NewClientTLSConfig(nil, []byte("xx"), nil); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = LoadServerTLSConfig("none.pem", "", ""); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = LoadClientTLSConfig("none.pem", "", ""); err == nil {
+		t.Error(err)
+		return
+	}
+}
@@ -0,0 +1,564 @@
+package frame
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+const (
+	//MuxCmdOpen is mux command to open stream, the payload is target
+	MuxCmdOpen byte = 0x01
+	//MuxCmdData is mux command to send stream data
+	MuxCmdData byte = 0x02
+	//MuxCmdClose is mux command to close stream
+	MuxCmdClose byte = 0x03
+	//MuxCmdWindow is mux command to increase stream send window, the payload is delta(4 bytes)
+	MuxCmdWindow byte = 0x04
+	//MuxCmdPing is mux command to keepalive session
+	MuxCmdPing byte = 0x05
+	//MuxCmdPong is mux command to reply ping
+	MuxCmdPong byte = 0x06
+)
+
+// muxHeadLength is the mux head length of cmd(1 byte)+stream id(4 bytes)
+const muxHeadLength = 5
+
+// MuxInitialWindow is the initial send window of each stream, the larger receive window is advertised by MuxCmdWindow after stream is created
+const MuxInitialWindow = 64 * 1024
+
+// ErrMuxClosed is the error when mux session is closed
+var ErrMuxClosed = fmt.Errorf("%v", "mux session is closed")
+
+// MuxConfig is the config of MuxSession
+type MuxConfig struct {
+	//Window is the receive window of each stream, it is not less than MuxInitialWindow
+	Window uint32
+	//KeepAlive is the delay of sending ping, zero is disabled
+	KeepAlive time.Duration
+	//Timeout is the max time of receiving nothing before session is closed, zero is 3*KeepAlive
+	Timeout time.Duration
+	//AcceptQueue is the max count of stream waiting accept
+	AcceptQueue int
+}
+
+// DefaultMuxConfig will return the default MuxConfig
+func DefaultMuxConfig() (config *MuxConfig) {
+	config = &MuxConfig{
+		Window:      256 * 1024,
+		KeepAlive:   30 * time.Second,
+		AcceptQueue: 64,
+	}
+	return
+}
+
+// MuxAddr is net.Addr of MuxStream
+type MuxAddr struct {
+	ID     uint32
+	Target string
+}
+
+// Network is net.Addr implement
+func (m *MuxAddr) Network() string {
+	return "mux"
+}
+
+func (m *MuxAddr) String() string {
+	return fmt.Sprintf("mux(%v,%v)", m.ID, m.Target)
+}
+
+// MuxStream is one logical stream of MuxSession, it is net.Conn
+type MuxStream struct {
+	ID            uint32
+	Target        string
+	session       *MuxSession
+	buffer        []byte
+	consumed      uint32
+	sendWindow    int64
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readable      chan int
+	writable      chan int
+	done          chan int
+	closer        sync.Once
+	lock          sync.RWMutex
+}
+
+func newMuxStream(session *MuxSession, id uint32, target string) (stream *MuxStream) {
+	stream = &MuxStream{
+		ID:         id,
+		Target:     target,
+		session:    session,
+		sendWindow: MuxInitialWindow,
+		readable:   make(chan int, 1),
+		writable:   make(chan int, 1),
+		done:       make(chan int),
+		lock:       sync.RWMutex{},
+	}
+	return
+}
+
+func notify(c chan int) {
+	select {
+	case c <- 1:
+	default:
+	}
+}
+
+func deadlineTimer(deadline time.Time) (timer *time.Timer, timeout <-chan time.Time, err error) {
+	if deadline.IsZero() {
+		return
+	}
+	delay := time.Until(deadline)
+	if delay <= 0 {
+		err = os.ErrDeadlineExceeded
+		return
+	}
+	timer = time.NewTimer(delay)
+	timeout = timer.C
+	return
+}
+
+// Read will read data of stream, io.EOF is returned after stream is closed and all data is readed
+func (m *MuxStream) Read(p []byte) (n int, err error) {
+	closed := false
+	for {
+		m.lock.Lock()
+		if len(m.buffer) > 0 {
+			var delta uint32
+			n = copy(p, m.buffer)
+			m.buffer = m.buffer[n:]
+			m.consumed += uint32(n)
+			if m.consumed >= m.session.config.Window/2 {
+				delta, m.consumed = m.consumed, 0
+			}
+			m.lock.Unlock()
+			if delta > 0 {
+				m.session.writeWindow(m.ID, delta)
+			}
+			return
+		}
+		deadline := m.readDeadline
+		m.lock.Unlock()
+		if closed {
+			err = io.EOF
+			return
+		}
+		timer, timeout, xerr := deadlineTimer(deadline)
+		if xerr != nil {
+			err = xerr
+			return
+		}
+		select {
+		case <-m.readable:
+		case <-m.done:
+			closed = true
+		case <-timeout:
+			err = os.ErrDeadlineExceeded
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Write will write data to stream, the data is splited to multi frame by session max payload and blocked when send window is empty
+func (m *MuxStream) Write(p []byte) (n int, err error) {
+	max := int64(m.session.maxPayload())
+	for n < len(p) {
+		m.lock.Lock()
+		window, deadline := m.sendWindow, m.writeDeadline
+		size := int64(len(p) - n)
+		if size > max {
+			size = max
+		}
+		if size > window {
+			size = window
+		}
+		m.sendWindow -= size
+		m.lock.Unlock()
+		select {
+		case <-m.done:
+			err = io.ErrClosedPipe
+			return
+		default:
+		}
+		if size < 1 {
+			timer, timeout, xerr := deadlineTimer(deadline)
+			if xerr != nil {
+				err = xerr
+				return
+			}
+			select {
+			case <-m.writable:
+			case <-m.done:
+				err = io.ErrClosedPipe
+			case <-timeout:
+				err = os.ErrDeadlineExceeded
+			}
+			if timer != nil {
+				timer.Stop()
+			}
+			if err != nil {
+				return
+			}
+			continue
+		}
+		err = m.session.writeFrame(MuxCmdData, m.ID, p[n:n+int(size)])
+		if err != nil {
+			break
+		}
+		n += int(size)
+	}
+	return
+}
+
+// Close will close the stream and notify remote
+func (m *MuxStream) Close() (err error) {
+	m.closeBy(false)
+	return
+}
+
+func (m *MuxStream) closeBy(remote bool) {
+	m.closer.Do(func() {
+		close(m.done)
+		m.session.removeStream(m)
+		if !remote {
+			m.session.writeFrame(MuxCmdClose, m.ID, nil)
+		}
+	})
+}
+
+func (m *MuxStream) push(data []byte) {
+	m.lock.Lock()
+	m.buffer = append(m.buffer, data...)
+	m.lock.Unlock()
+	notify(m.readable)
+}
+
+func (m *MuxStream) increase(delta uint32) {
+	m.lock.Lock()
+	m.sendWindow += int64(delta)
+	m.lock.Unlock()
+	notify(m.writable)
+}
+
+// LocalAddr is net.Conn implement
+func (m *MuxStream) LocalAddr() net.Addr {
+	return &MuxAddr{ID: m.ID, Target: m.Target}
+}
+
+// RemoteAddr is net.Conn implement
+func (m *MuxStream) RemoteAddr() net.Addr {
+	return &MuxAddr{ID: m.ID, Target: m.Target}
+}
+
+// SetDeadline is net.Conn implement
+func (m *MuxStream) SetDeadline(t time.Time) error {
+	m.SetReadDeadline(t)
+	m.SetWriteDeadline(t)
+	return nil
+}
+
+// SetReadDeadline is net.Conn implement
+func (m *MuxStream) SetReadDeadline(t time.Time) error {
+	m.lock.Lock()
+	m.readDeadline = t
+	m.lock.Unlock()
+	notify(m.readable)
+	return nil
+}
+
+// SetWriteDeadline is net.Conn implement
+func (m *MuxStream) SetWriteDeadline(t time.Time) error {
+	m.lock.Lock()
+	m.writeDeadline = t
+	m.lock.Unlock()
+	notify(m.writable)
+	return nil
+}
+
+func (m *MuxStream) String() string {
+	return fmt.Sprintf("mux(%v,%v)", m.ID, m.Target)
+}
+
+// MuxSession is session to multiplex many MuxStream over one ReadWriteCloser
+type MuxSession struct {
+	Raw      ReadWriteCloser
+	config   *MuxConfig
+	streams  map[uint32]*MuxStream
+	accept   chan *MuxStream
+	sequence uint32
+	received int64
+	done     chan int
+	closer   sync.Once
+	lock     sync.RWMutex
+}
+
+// NewMuxSession will return new MuxSession by default config and start runner, the client and server side must be different to allocate stream id
+func NewMuxSession(raw ReadWriteCloser, client bool) (session *MuxSession) {
+	session = NewMuxSessionConfig(raw, client, DefaultMuxConfig())
+	return
+}
+
+// NewMuxSessionConfig will return new MuxSession by config and start runner
+func NewMuxSessionConfig(raw ReadWriteCloser, client bool, config *MuxConfig) (session *MuxSession) {
+	if config.Timeout <= 0 {
+		config.Timeout = 3 * config.KeepAlive
+	}
+	if config.Window < MuxInitialWindow {
+		config.Window = MuxInitialWindow
+	}
+	if config.AcceptQueue < 1 {
+		config.AcceptQueue = 1
+	}
+	session = &MuxSession{
+		Raw:      raw,
+		config:   config,
+		streams:  map[uint32]*MuxStream{},
+		accept:   make(chan *MuxStream, config.AcceptQueue),
+		received: time.Now().UnixNano(),
+		done:     make(chan int),
+		lock:     sync.RWMutex{},
+	}
+	if client {
+		session.sequence = 1
+	}
+	go session.loopRead()
+	if config.KeepAlive > 0 {
+		go session.loopPing()
+	}
+	return
+}
+
+func (m *MuxSession) maxPayload() int {
+	return m.Raw.BufferSize() - m.Raw.GetDataOffset() - muxHeadLength
+}
+
+func (m *MuxSession) writeFrame(cmd byte, id uint32, payload []byte) (err error) {
+	offset := m.Raw.GetDataOffset()
+	buf := make([]byte, offset+muxHeadLength+len(payload))
+	buf[offset] = cmd
+	binary.BigEndian.PutUint32(buf[offset+1:], id)
+	copy(buf[offset+muxHeadLength:], payload)
+	_, err = m.Raw.WriteFrame(buf)
+	if err != nil {
+		m.Close()
+	}
+	return
+}
+
+func (m *MuxSession) writeWindow(id uint32, delta uint32) (err error) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, delta)
+	err = m.writeFrame(MuxCmdWindow, id, buf)
+	return
+}
+
+// advertise will send the receive window larger than MuxInitialWindow to remote
+func (m *MuxSession) advertise(id uint32) (err error) {
+	if m.config.Window > MuxInitialWindow {
+		err = m.writeWindow(id, m.config.Window-MuxInitialWindow)
+	}
+	return
+}
+
+func (m *MuxSession) addStream(stream *MuxStream) {
+	m.lock.Lock()
+	m.streams[stream.ID] = stream
+	m.lock.Unlock()
+}
+
+func (m *MuxSession) findStream(id uint32) (stream *MuxStream) {
+	m.lock.RLock()
+	stream = m.streams[id]
+	m.lock.RUnlock()
+	return
+}
+
+func (m *MuxSession) removeStream(stream *MuxStream) {
+	m.lock.Lock()
+	if m.streams[stream.ID] == stream {
+		delete(m.streams, stream.ID)
+	}
+	m.lock.Unlock()
+}
+
+func (m *MuxSession) loopRead() {
+	offset := m.Raw.GetDataOffset()
+	for {
+		data, err := m.Raw.ReadFrame()
+		if err != nil {
+			break
+		}
+		atomic.StoreInt64(&m.received, time.Now().UnixNano())
+		if len(data) < offset+muxHeadLength {
+			continue
+		}
+		cmd, id, payload := data[offset], binary.BigEndian.Uint32(data[offset+1:]), data[offset+muxHeadLength:]
+		switch cmd {
+		case MuxCmdOpen:
+			stream := newMuxStream(m, id, string(payload))
+			m.addStream(stream)
+			select {
+			case m.accept <- stream:
+				go m.advertise(id)
+			default: //accept queue is full
+				stream.Close()
+			}
+		case MuxCmdData:
+			if stream := m.findStream(id); stream != nil {
+				stream.push(payload)
+			}
+		case MuxCmdClose:
+			if stream := m.findStream(id); stream != nil {
+				stream.closeBy(true)
+			}
+		case MuxCmdWindow:
+			if stream := m.findStream(id); stream != nil && len(payload) >= 4 {
+				stream.increase(binary.BigEndian.Uint32(payload))
+			}
+		case MuxCmdPing:
+			go m.writeFrame(MuxCmdPong, id, nil)
+		}
+	}
+	m.Close()
+}
+
+func (m *MuxSession) loopPing() {
+	ticker := time.NewTicker(m.config.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		if time.Since(time.Unix(0, atomic.LoadInt64(&m.received))) > m.config.Timeout {
+			m.Close()
+			return
+		}
+		m.writeFrame(MuxCmdPing, 0, nil)
+	}
+}
+
+// Open will open new stream to target
+func (m *MuxSession) Open(target string) (stream *MuxStream, err error) {
+	select {
+	case <-m.done:
+		err = ErrMuxClosed
+		return
+	default:
+	}
+	stream = newMuxStream(m, atomic.AddUint32(&m.sequence, 2), target)
+	m.addStream(stream)
+	err = m.writeFrame(MuxCmdOpen, stream.ID, []byte(target))
+	if err == nil {
+		err = m.advertise(stream.ID)
+	}
+	if err != nil {
+		m.removeStream(stream)
+		stream = nil
+	}
+	return
+}
+
+// Accept will wait the stream opened by remote
+func (m *MuxSession) Accept() (stream *MuxStream, err error) {
+	select {
+	case stream = <-m.accept:
+	case <-m.done:
+		err = ErrMuxClosed
+	}
+	return
+}
+
+// Dial will open new stream to network://address, it is xnet.RawDialer implement
+func (m *MuxSession) Dial(network, address string) (conn net.Conn, err error) {
+	stream, err := m.Open(network + "://" + address)
+	if err == nil {
+		conn = stream
+	}
+	return
+}
+
+// DialPiper will open new stream to uri and return piper, it is xio.PiperDialer implement
+func (m *MuxSession) DialPiper(uri string, bufferSize int) (raw xio.Piper, err error) {
+	stream, err := m.Open(uri)
+	if err == nil {
+		raw = xio.NewCopyPiper(stream, bufferSize)
+	}
+	return
+}
+
+// Listener will return net.Listener to accept stream opened by remote, the session is closed when listener is closed
+func (m *MuxSession) Listener() net.Listener {
+	return &muxListener{session: m}
+}
+
+// Done will return the chan closed when session is closed
+func (m *MuxSession) Done() <-chan int {
+	return m.done
+}
+
+// Size will return the running stream count
+func (m *MuxSession) Size() (n int) {
+	m.lock.RLock()
+	n = len(m.streams)
+	m.lock.RUnlock()
+	return
+}
+
+// Close will close the raw and all stream
+func (m *MuxSession) Close() (err error) {
+	m.closer.Do(func() {
+		close(m.done)
+		err = m.Raw.Close()
+		m.lock.RLock()
+		streams := []*MuxStream{}
+		for _, stream := range m.streams {
+			streams = append(streams, stream)
+		}
+		m.lock.RUnlock()
+		for _, stream := range streams {
+			stream.closeBy(true)
+		}
+	})
+	return
+}
+
+func (m *MuxSession) String() string {
+	return fmt.Sprintf("MuxSession(%v)", m.Raw)
+}
+
+// muxListener is net.Listener implement by MuxSession
+type muxListener struct {
+	session *MuxSession
+}
+
+func (m *muxListener) Accept() (conn net.Conn, err error) {
+	stream, err := m.session.Accept()
+	if err == nil {
+		conn = stream
+	}
+	return
+}
+
+func (m *muxListener) Close() (err error) {
+	err = m.session.Close()
+	return
+}
+
+func (m *muxListener) Addr() net.Addr {
+	return &MuxAddr{Target: "listener"}
+}
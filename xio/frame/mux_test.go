@@ -0,0 +1,236 @@
+package frame
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/codingeasygo/util/xio"
+)
+
+func TestMux(t *testing.T) {
+	a, b, _ := xio.CreatePipedConn()
+	client := NewMuxSession(NewReadWriteCloser(nil, a, 1024), true)
+	server := NewMuxSession(NewReadWriteCloser(nil, b, 1024), false)
+	go func() {
+		for {
+			stream, err := server.Accept()
+			if err != nil {
+				break
+			}
+			if stream.Target == "reject" {
+				stream.Close()
+				continue
+			}
+			go func() {
+				io.Copy(stream, stream)
+				stream.Close()
+			}()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		stream, err := client.Open("echo")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		data := bytes.Repeat([]byte{byte('a' + i)}, 3000)
+		go stream.Write(data)
+		buf := make([]byte, len(data))
+		_, err = io.ReadFull(stream, buf)
+		if err != nil || !bytes.Equal(data, buf) {
+			t.Error(err)
+			return
+		}
+		stream.Close()
+		if _, err = stream.Write(data); err == nil {
+			t.Error(err)
+			return
+		}
+		if stream.String() == "" {
+			t.Error("error")
+			return
+		}
+	}
+	{ //reject
+		stream, _ := client.Open("reject")
+		buf := make([]byte, 10)
+		if _, err := stream.Read(buf); err != io.EOF {
+			t.Error(err)
+			return
+		}
+	}
+	{ //close session
+		stream, _ := client.Open("echo")
+		client.Close()
+		<-server.Done()
+		buf := make([]byte, 10)
+		if _, err := stream.Read(buf); err != io.EOF {
+			t.Error(err)
+			return
+		}
+		if _, err := client.Open("echo"); err != ErrMuxClosed {
+			t.Error(err)
+			return
+		}
+		if _, err := server.Accept(); err != ErrMuxClosed {
+			t.Error(err)
+			return
+		}
+		if client.Size() != 0 || client.String() == "" {
+			t.Error("error")
+			return
+		}
+	}
+}
+
+func TestMuxFlowControl(t *testing.T) {
+	a, b, _ := xio.CreatePipedConn()
+	config := DefaultMuxConfig()
+	config.Window = 1024
+	client := NewMuxSessionConfig(NewReadWriteCloser(nil, a, 1024), true, DefaultMuxConfig())
+	server := NewMuxSessionConfig(NewReadWriteCloser(nil, b, 1024), false, config)
+	defer client.Close()
+	stream, _ := client.Open("test")
+	remote, _ := server.Accept()
+	data := bytes.Repeat([]byte("a"), 512*1024)
+	written := make(chan int, 1)
+	go func() {
+		n, _ := stream.Write(data)
+		written <- n
+	}()
+	//blocked by window
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case <-written:
+		t.Error("not blocked")
+		return
+	default:
+	}
+	buf := make([]byte, len(data))
+	if _, err := io.ReadFull(remote, buf); err != nil || !bytes.Equal(buf, data) {
+		t.Error(err)
+		return
+	}
+	if n := <-written; n != len(data) {
+		t.Error(n)
+		return
+	}
+	//write deadline
+	stream.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	if _, err := stream.Write(data); err != os.ErrDeadlineExceeded {
+		t.Error(err)
+		return
+	}
+	stream.SetWriteDeadline(time.Now().Add(-time.Second))
+	if _, err := stream.Write(data); err != os.ErrDeadlineExceeded {
+		t.Error(err)
+		return
+	}
+	//read deadline
+	remote.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	io.ReadFull(remote, buf[:MuxInitialWindow])
+	if _, err := remote.Read(buf); err != os.ErrDeadlineExceeded {
+		t.Error(err)
+		return
+	}
+	if remote.LocalAddr().Network() != "mux" || remote.RemoteAddr().String() == "" {
+		t.Error("error")
+		return
+	}
+}
+
+func TestMuxKeepAlive(t *testing.T) {
+	a, b, _ := xio.CreatePipedConn()
+	config := DefaultMuxConfig()
+	config.KeepAlive = 20 * time.Millisecond
+	client := NewMuxSessionConfig(NewReadWriteCloser(nil, a, 1024), true, config)
+	server := NewMuxSessionConfig(NewReadWriteCloser(nil, b, 1024), false, DefaultMuxConfig())
+	time.Sleep(150 * time.Millisecond)
+	select {
+	case <-client.Done():
+		t.Error("closed")
+		return
+	default:
+	}
+	//not response
+	c, d, _ := xio.CreatePipedConn()
+	go io.Copy(ioutil.Discard, d)
+	timeout := NewMuxSessionConfig(NewReadWriteCloser(nil, c, 1024), true, config)
+	select {
+	case <-timeout.Done():
+	case <-time.After(time.Second):
+		t.Error("not closed")
+		return
+	}
+	client.Close()
+	server.Close()
+}
+
+func TestMuxListener(t *testing.T) {
+	a, b, _ := xio.CreatePipedConn()
+	client := NewMuxSession(NewReadWriteCloser(nil, a, 1024), true)
+	server := NewMuxSession(NewReadWriteCloser(nil, b, 1024), false)
+	listener := server.Listener()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go xio.NewEchoPiper(1024).PipeConn(conn, conn.(*MuxStream).Target)
+		}
+	}()
+	{ //piper
+		piper, err := client.DialPiper("tcp://echo", 1024)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		conn, raw, _ := xio.CreatePipedConn()
+		go piper.PipeConn(raw, "tcp://echo")
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 3)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "abc" {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+	{ //dial
+		conn, err := client.Dial("tcp", "echo")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 3)
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "abc" {
+			t.Error(err)
+			return
+		}
+		conn.Close()
+	}
+	if listener.Addr().Network() != "mux" {
+		t.Error("error")
+		return
+	}
+	listener.Close()
+	if _, err := listener.Accept(); err == nil {
+		t.Error(err)
+		return
+	}
+	<-client.Done()
+	if _, err := client.DialPiper("tcp://echo", 1024); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err := client.Dial("tcp", "echo"); err == nil {
+		t.Error(err)
+		return
+	}
+}
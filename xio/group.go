@@ -0,0 +1,102 @@
+package xio
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// ShutdownPollDelay is the delay of polling running connection when shutdown
+var ShutdownPollDelay = 10 * time.Millisecond
+
+// ConnGroup is the group of running connection to shutdown gracefully
+type ConnGroup struct {
+	conns   map[io.Closer]bool
+	closing bool
+	lock    sync.RWMutex
+}
+
+// NewConnGroup will return new ConnGroup
+func NewConnGroup() (group *ConnGroup) {
+	group = &ConnGroup{
+		conns: map[io.Closer]bool{},
+		lock:  sync.RWMutex{},
+	}
+	return
+}
+
+// Add will add running connection to group, false is returned when group is shutting down
+func (c *ConnGroup) Add(conn io.Closer) (ok bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closing {
+		return
+	}
+	c.conns[conn] = true
+	ok = true
+	return
+}
+
+// Done will remove connection from group
+func (c *ConnGroup) Done(conn io.Closer) {
+	c.lock.Lock()
+	delete(c.conns, conn)
+	c.lock.Unlock()
+}
+
+// Size will return running connection count
+func (c *ConnGroup) Size() (n int) {
+	c.lock.RLock()
+	n = len(c.conns)
+	c.lock.RUnlock()
+	return
+}
+
+func (c *ConnGroup) closeAll() (n int) {
+	c.lock.RLock()
+	conns := []io.Closer{}
+	for conn := range c.conns {
+		conns = append(conns, conn)
+	}
+	c.lock.RUnlock()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	n = len(conns)
+	return
+}
+
+// Shutdown will reject new connection and wait running connection done until ctx is done, then close the remaining.
+// The drained is count of connection done by self and killed is count of connection closed by force.
+func (c *ConnGroup) Shutdown(ctx context.Context) (drained, killed int, err error) {
+	c.lock.Lock()
+	c.closing = true
+	c.lock.Unlock()
+	drained, killed, err = WaitDrain(ctx, c.Size, c.closeAll)
+	return
+}
+
+// WaitDrain will poll size until it is zero or ctx is done, the kill is called to close remaining when ctx is done
+func WaitDrain(ctx context.Context, size func() int, kill func() int) (drained, killed int, err error) {
+	total := size()
+	ticker := time.NewTicker(ShutdownPollDelay)
+	defer ticker.Stop()
+	for {
+		if size() < 1 {
+			drained = total
+			return
+		}
+		select {
+		case <-ctx.Done():
+			killed = kill()
+			drained = total - killed
+			if drained < 0 {
+				drained = 0
+			}
+			err = ctx.Err()
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package xio
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConnGroup(t *testing.T) {
+	{ //drained
+		group := NewConnGroup()
+		a, b, _ := CreatePipedConn()
+		group.Add(a)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			group.Done(a)
+		}()
+		drained, killed, err := group.Shutdown(context.Background())
+		if err != nil || drained != 1 || killed != 0 {
+			t.Errorf("%v,%v,%v", drained, killed, err)
+			return
+		}
+		if group.Add(b) || group.Size() != 0 {
+			t.Error("error")
+			return
+		}
+		a.Close()
+		b.Close()
+	}
+	{ //killed
+		group := NewConnGroup()
+		a, b, _ := CreatePipedConn()
+		c, d, _ := CreatePipedConn()
+		group.Add(a)
+		group.Add(c)
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			group.Done(a)
+		}()
+		go func() {
+			buf := make([]byte, 1)
+			c.Read(buf)
+			group.Done(c)
+		}()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		drained, killed, err := group.Shutdown(ctx)
+		if err != context.DeadlineExceeded || drained != 1 || killed != 1 {
+			t.Errorf("%v,%v,%v", drained, killed, err)
+			return
+		}
+		b.Close()
+		d.Close()
+	}
+}
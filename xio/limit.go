@@ -0,0 +1,340 @@
+package xio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrConnLimited is error for max concurrent connection is reached
+var ErrConnLimited = fmt.Errorf("max connection limited")
+
+// RateLimiter is token bucket to limit bytes per second
+type RateLimiter struct {
+	Rate   int64
+	Burst  int64
+	tokens float64
+	last   time.Time
+	lock   sync.Mutex
+}
+
+// NewRateLimiter will return new RateLimiter by bytes per second, the burst is same as rate
+func NewRateLimiter(rate int64) (limiter *RateLimiter) {
+	limiter = &RateLimiter{
+		Rate:  rate,
+		Burst: rate,
+		lock:  sync.Mutex{},
+	}
+	return
+}
+
+// Wait will take n tokens from bucket and sleep until tokens is enough
+func (r *RateLimiter) Wait(n int) {
+	if r == nil || r.Rate <= 0 || n <= 0 {
+		return
+	}
+	r.lock.Lock()
+	now := time.Now()
+	if r.last.IsZero() {
+		r.tokens = float64(r.Burst)
+	} else {
+		r.tokens += now.Sub(r.last).Seconds() * float64(r.Rate)
+		if r.tokens > float64(r.Burst) {
+			r.tokens = float64(r.Burst)
+		}
+	}
+	r.last = now
+	r.tokens -= float64(n)
+	var delay time.Duration
+	if r.tokens < 0 {
+		delay = time.Duration(-r.tokens / float64(r.Rate) * float64(time.Second))
+	}
+	r.lock.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// chunk will return the max bytes for once wait
+func (r *RateLimiter) chunk() int {
+	if r == nil || r.Rate <= 0 || r.Burst <= 0 {
+		return 0
+	}
+	return int(r.Burst)
+}
+
+// LimitReadWriteCloser is ReadWriteCloser to limit read/write bytes per second by multi RateLimiter
+type LimitReadWriteCloser struct {
+	io.ReadWriteCloser
+	ReadLimiter  []*RateLimiter
+	WriteLimiter []*RateLimiter
+	OnClose      func()
+	closer       sync.Once
+}
+
+// NewLimitReadWriteCloser will return new LimitReadWriteCloser
+func NewLimitReadWriteCloser(raw io.ReadWriteCloser, read, write []*RateLimiter) (limit *LimitReadWriteCloser) {
+	limit = &LimitReadWriteCloser{
+		ReadWriteCloser: raw,
+		ReadLimiter:     read,
+		WriteLimiter:    write,
+	}
+	return
+}
+
+func minChunk(limiters []*RateLimiter, size int) int {
+	for _, limiter := range limiters {
+		if chunk := limiter.chunk(); chunk > 0 && chunk < size {
+			size = chunk
+		}
+	}
+	return size
+}
+
+func (l *LimitReadWriteCloser) Read(p []byte) (n int, err error) {
+	if len(p) < 1 {
+		return l.ReadWriteCloser.Read(p)
+	}
+	n, err = l.ReadWriteCloser.Read(p[:minChunk(l.ReadLimiter, len(p))])
+	for _, limiter := range l.ReadLimiter {
+		limiter.Wait(n)
+	}
+	return
+}
+
+func (l *LimitReadWriteCloser) Write(p []byte) (n int, err error) {
+	size := minChunk(l.WriteLimiter, len(p))
+	for n < len(p) {
+		end := n + size
+		if end > len(p) {
+			end = len(p)
+		}
+		for _, limiter := range l.WriteLimiter {
+			limiter.Wait(end - n)
+		}
+		var w int
+		w, err = l.ReadWriteCloser.Write(p[n:end])
+		n += w
+		if err != nil {
+			break
+		}
+	}
+	return
+}
+
+// Close will close raw and call OnClose once
+func (l *LimitReadWriteCloser) Close() (err error) {
+	err = l.ReadWriteCloser.Close()
+	l.closer.Do(func() {
+		if l.OnClose != nil {
+			l.OnClose()
+		}
+	})
+	return
+}
+
+func (l *LimitReadWriteCloser) String() string {
+	return RemoteAddr(l.ReadWriteCloser)
+}
+
+// RemoteAddr will return the remote address of raw, it is nil when raw is not having address
+func (l *LimitReadWriteCloser) RemoteAddr() (addr net.Addr) {
+	if conn, ok := l.ReadWriteCloser.(interface{ RemoteAddr() net.Addr }); ok {
+		addr = conn.RemoteAddr()
+	}
+	return
+}
+
+// LocalAddr will return the local address of raw, it is nil when raw is not having address
+func (l *LimitReadWriteCloser) LocalAddr() (addr net.Addr) {
+	if conn, ok := l.ReadWriteCloser.(interface{ LocalAddr() net.Addr }); ok {
+		addr = conn.LocalAddr()
+	}
+	return
+}
+
+// LimitConfig is config of bytes per second and max concurrent connection, zero is unlimited
+type LimitConfig struct {
+	Rate    int64
+	MaxConn int
+}
+
+type limitState struct {
+	read  *RateLimiter
+	write *RateLimiter
+	conn  int
+}
+
+func newLimitState(rate int64) (state *limitState) {
+	state = &limitState{
+		read:  NewRateLimiter(rate),
+		write: NewRateLimiter(rate),
+	}
+	return
+}
+
+// Limiter is limiter for bytes per second and max concurrent connection by per session, per client ip and global
+type Limiter struct {
+	Session LimitConfig
+	Client  LimitConfig
+	Global  LimitConfig
+	clients map[string]*limitState
+	global  *limitState
+	lock    sync.Mutex
+}
+
+// NewLimiter will return new Limiter
+func NewLimiter() (limiter *Limiter) {
+	limiter = &Limiter{
+		clients: map[string]*limitState{},
+		global:  &limitState{},
+		lock:    sync.Mutex{},
+	}
+	return
+}
+
+// Wrap will return limited ReadWriteCloser for client, ErrConnLimited is returned when max connection is reached
+func (l *Limiter) Wrap(client string, conn io.ReadWriteCloser) (limited *LimitReadWriteCloser, err error) {
+	slot, err := l.acquire(client)
+	if err == nil {
+		limited = slot.wrap(conn)
+	}
+	return
+}
+
+// limitSlot is one connection taken from Limiter, it is released once when the limited connection is closed
+type limitSlot struct {
+	limiter *Limiter
+	client  string
+	state   *limitState
+	global  *limitState
+	session int64
+	closer  sync.Once
+}
+
+// acquire will take one connection of client and global, ErrConnLimited is returned when max connection is reached
+func (l *Limiter) acquire(client string) (slot *limitSlot, err error) {
+	if host, _, xerr := net.SplitHostPort(client); xerr == nil {
+		client = host
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.global.read == nil || l.global.read.Rate != l.Global.Rate {
+		l.global.read, l.global.write = NewRateLimiter(l.Global.Rate), NewRateLimiter(l.Global.Rate)
+	}
+	clientState := l.clients[client]
+	if clientState == nil {
+		clientState = newLimitState(l.Client.Rate)
+	}
+	if (l.Global.MaxConn > 0 && l.global.conn >= l.Global.MaxConn) || (l.Client.MaxConn > 0 && clientState.conn >= l.Client.MaxConn) {
+		err = ErrConnLimited
+		return
+	}
+	l.global.conn++
+	clientState.conn++
+	l.clients[client] = clientState
+	slot = &limitSlot{
+		limiter: l,
+		client:  client,
+		state:   clientState,
+		global:  l.global,
+		session: l.Session.Rate,
+	}
+	return
+}
+
+func (s *limitSlot) wrap(conn io.ReadWriteCloser) (limited *LimitReadWriteCloser) {
+	read := []*RateLimiter{NewRateLimiter(s.session), s.state.read, s.global.read}
+	write := []*RateLimiter{NewRateLimiter(s.session), s.state.write, s.global.write}
+	limited = NewLimitReadWriteCloser(conn, read, write)
+	limited.OnClose = s.release
+	return
+}
+
+func (s *limitSlot) release() {
+	s.closer.Do(func() {
+		l := s.limiter
+		l.lock.Lock()
+		s.global.conn--
+		s.state.conn--
+		if s.state.conn < 1 {
+			delete(l.clients, s.client)
+		}
+		l.lock.Unlock()
+	})
+}
+
+// Conns will return current connection count of client ip and global
+func (l *Limiter) Conns(client string) (clientConn, globalConn int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if state := l.clients[client]; state != nil {
+		clientConn = state.conn
+	}
+	globalConn = l.global.conn
+	return
+}
+
+// Piper will return Piper to limit the connection on PipeConn, the max connection is checked on PipeConn after raw is dialed,
+// so Dialer should be used to check it before dial
+func (l *Limiter) Piper(raw Piper) (piper Piper) {
+	piper = &limitPiper{Piper: raw, limiter: l}
+	return
+}
+
+// Dialer will return PiperDialer to limit the connection dialed by next, the max connection is checked before dial
+// when ctx is carrying client by ContextWithClient, else it is checked on PipeConn by the connection remote address
+func (l *Limiter) Dialer(next PiperDialer) (dialer PiperDialer) {
+	dialer = PiperContextDialerF(func(ctx context.Context, uri string, bufferSize int) (raw Piper, err error) {
+		var slot *limitSlot
+		if client := ClientFromContext(ctx); len(client) > 0 {
+			if slot, err = l.acquire(client); err != nil {
+				return
+			}
+		}
+		raw, err = DialPiperContext(ctx, next, uri, bufferSize)
+		if err != nil {
+			if slot != nil {
+				slot.release()
+			}
+			return
+		}
+		raw = &limitPiper{Piper: raw, limiter: l, slot: slot}
+		return
+	})
+	return
+}
+
+type limitPiper struct {
+	Piper
+	limiter *Limiter
+	slot    *limitSlot
+}
+
+func (l *limitPiper) PipeConn(conn io.ReadWriteCloser, target string) (err error) {
+	var limited *LimitReadWriteCloser
+	if l.slot != nil {
+		limited = l.slot.wrap(conn)
+	} else if limited, err = l.limiter.Wrap(RemoteAddr(conn), conn); err != nil {
+		conn.Close()
+		l.Piper.Close()
+		return
+	}
+	err = l.Piper.PipeConn(limited, target)
+	if err != ErrAsyncRunning {
+		limited.Close()
+	}
+	return
+}
+
+// Close will release the connection taken before dial and close the piper
+func (l *limitPiper) Close() (err error) {
+	if l.slot != nil {
+		l.slot.release()
+	}
+	err = l.Piper.Close()
+	return
+}
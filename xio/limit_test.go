@@ -0,0 +1,219 @@
+package xio
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := NewRateLimiter(1000)
+	begin := time.Now()
+	limiter.Wait(1000)
+	if time.Since(begin) > 100*time.Millisecond {
+		t.Error("error")
+		return
+	}
+	limiter.Wait(300)
+	used := time.Since(begin)
+	if used < 250*time.Millisecond || used > 500*time.Millisecond {
+		t.Error(used)
+		return
+	}
+	//unlimited
+	var nilLimiter *RateLimiter
+	nilLimiter.Wait(100)
+	NewRateLimiter(0).Wait(100)
+}
+
+func TestLimitReadWriteCloser(t *testing.T) {
+	conna, connb, _ := CreatePipedConn()
+	closed := 0
+	limit := NewLimitReadWriteCloser(conna, []*RateLimiter{NewRateLimiter(1000)}, []*RateLimiter{NewRateLimiter(1000)})
+	limit.OnClose = func() { closed++ }
+	go func() {
+		buffer := make([]byte, 1024)
+		for {
+			n, err := connb.Read(buffer)
+			if err != nil {
+				break
+			}
+			connb.Write(buffer[:n])
+		}
+	}()
+	data := bytes.Repeat([]byte("a"), 1500)
+	begin := time.Now()
+	n, err := limit.Write(data)
+	if err != nil || n != len(data) {
+		t.Error(err)
+		return
+	}
+	if used := time.Since(begin); used < 400*time.Millisecond {
+		t.Error(used)
+		return
+	}
+	buffer := make([]byte, 2048)
+	n, err = limit.Read(buffer)
+	if err != nil || n > 1000 {
+		t.Errorf("%v,%v", n, err)
+		return
+	}
+	limit.Read(nil)
+	if limit.String() != RemoteAddr(conna) || limit.RemoteAddr() != conna.RemoteAddr() || limit.LocalAddr() != conna.LocalAddr() {
+		t.Error(limit.String())
+		return
+	}
+	if read, write := limit.ReadLimiter[0], limit.WriteLimiter[0]; read.tokens != float64(read.Burst-int64(n)) || write.tokens >= float64(write.Burst) {
+		t.Errorf("%v,%v", read.tokens, write.tokens)
+		return
+	}
+	limit.Close()
+	limit.Close()
+	if closed != 1 {
+		t.Error(closed)
+		return
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter()
+	limiter.Client.MaxConn = 1
+	limiter.Global.MaxConn = 2
+	a, err := limiter.Wrap("127.0.0.1:100", NewEchoConn())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = limiter.Wrap("127.0.0.1:101", NewEchoConn())
+	if err != ErrConnLimited {
+		t.Error(err)
+		return
+	}
+	b, err := limiter.Wrap("127.0.0.2:100", NewEchoConn())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	_, err = limiter.Wrap("127.0.0.3:100", NewEchoConn())
+	if err != ErrConnLimited {
+		t.Error(err)
+		return
+	}
+	if client, global := limiter.Conns("127.0.0.1"); client != 1 || global != 2 {
+		t.Errorf("%v,%v", client, global)
+		return
+	}
+	a.Close()
+	b.Close()
+	if client, global := limiter.Conns("127.0.0.1"); client != 0 || global != 0 {
+		t.Errorf("%v,%v", client, global)
+		return
+	}
+	//piper
+	limiter.Client.MaxConn = 0
+	limiter.Global.MaxConn = 1
+	limiter.Session.Rate = 1000
+	dialer := limiter.Dialer(NewEchoDialer())
+	piper, _ := dialer.DialPiper("xxx", 1024)
+	conna, connb, _ := CreatePipedConn()
+	waiter := make(chan error, 1)
+	go func() {
+		waiter <- piper.PipeConn(connb, "xxx")
+	}()
+	fmt.Fprintf(conna, "abc")
+	buffer := make([]byte, 1024)
+	err = FullBuffer(conna, buffer, 3, nil)
+	if err != nil || string(buffer[0:3]) != "abc" {
+		t.Error(err)
+		return
+	}
+	conna2, connb2, _ := CreatePipedConn()
+	err = limiter.Piper(NewEchoPiper(1024)).PipeConn(connb2, "xxx")
+	if err != ErrConnLimited {
+		t.Error(err)
+		return
+	}
+	if _, err = conna2.Read(buffer); err == nil { //limited conn is closed
+		t.Error(err)
+		return
+	}
+	conna2.Close()
+	conna.Close()
+	<-waiter
+	if _, global := limiter.Conns(""); global != 0 {
+		t.Error(global)
+		return
+	}
+	//error
+	dialer = limiter.Dialer(PiperDialerF(func(uri string, bufferSize int) (raw Piper, err error) {
+		err = fmt.Errorf("error")
+		return
+	}))
+	_, err = dialer.DialPiper("xxx", 1024)
+	if err == nil {
+		t.Error(err)
+		return
+	}
+	_, err = DialPiperClient("127.0.0.1:100", dialer, "xxx", 1024)
+	if _, global := limiter.Conns(""); err == nil || global != 0 {
+		t.Errorf("%v,%v", err, global)
+		return
+	}
+}
+
+func TestLimiterDialerClient(t *testing.T) {
+	limiter := NewLimiter()
+	limiter.Client.MaxConn = 1
+	dialed := 0
+	dialer := limiter.Dialer(PiperDialerF(func(uri string, bufferSize int) (raw Piper, err error) {
+		dialed++
+		raw = NewEchoPiper(bufferSize)
+		return
+	}))
+	piper, err := DialPiperClient("127.0.0.1:100", dialer, "xxx", 1024)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if client, global := limiter.Conns("127.0.0.1"); client != 1 || global != 1 {
+		t.Errorf("%v,%v", client, global)
+		return
+	}
+	//limited before dial
+	_, err = DialPiperClient("127.0.0.1:101", dialer, "xxx", 1024)
+	if err != ErrConnLimited || dialed != 1 {
+		t.Errorf("%v,%v", err, dialed)
+		return
+	}
+	//other client
+	other, err := DialPiperClient("127.0.0.2:100", dialer, "xxx", 1024)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	other.Close()
+	//pipe by taken connection
+	conna, connb, _ := CreatePipedConn()
+	waiter := make(chan error, 1)
+	go func() {
+		waiter <- piper.PipeConn(connb, "xxx")
+	}()
+	fmt.Fprintf(conna, "abc")
+	buffer := make([]byte, 1024)
+	if err = FullBuffer(conna, buffer, 3, nil); err != nil || string(buffer[0:3]) != "abc" {
+		t.Error(err)
+		return
+	}
+	if client, global := limiter.Conns("127.0.0.1"); client != 1 || global != 1 {
+		t.Errorf("%v,%v", client, global)
+		return
+	}
+	conna.Close()
+	<-waiter
+	piper.Close()
+	if client, global := limiter.Conns("127.0.0.1"); client != 0 || global != 0 {
+		t.Errorf("%v,%v", client, global)
+		return
+	}
+}
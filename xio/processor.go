@@ -1,6 +1,8 @@
 package xio
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
@@ -57,14 +59,131 @@ func (p PiperDialerF) DialPiper(uri string, bufferSize int) (raw Piper, err erro
 	return
 }
 
+// PiperContextDialer is interface for implement piper dialer with context
+type PiperContextDialer interface {
+	DialPiperContext(ctx context.Context, uri string, bufferSize int) (raw Piper, err error)
+}
+
+// PiperContextDialerF is func to implement PiperDialer and PiperContextDialer
+type PiperContextDialerF func(ctx context.Context, uri string, bufferSize int) (raw Piper, err error)
+
+// DialPiper will dial one piper by uri with background context
+func (p PiperContextDialerF) DialPiper(uri string, bufferSize int) (raw Piper, err error) {
+	raw, err = p(context.Background(), uri, bufferSize)
+	return
+}
+
+// DialPiperContext will dial one piper by uri
+func (p PiperContextDialerF) DialPiperContext(ctx context.Context, uri string, bufferSize int) (raw Piper, err error) {
+	raw, err = p(ctx, uri, bufferSize)
+	return
+}
+
+type dialPiperResult struct {
+	raw Piper
+	err error
+}
+
+// DialPiperContext will dial piper by DialPiperContext when dialer is PiperContextDialer,
+// else dial by DialPiper in background and close the piper when ctx is done first
+func DialPiperContext(ctx context.Context, dialer PiperDialer, uri string, bufferSize int) (raw Piper, err error) {
+	if ctxDialer, ok := dialer.(PiperContextDialer); ok {
+		raw, err = ctxDialer.DialPiperContext(ctx, uri, bufferSize)
+		return
+	}
+	if ctx.Done() == nil {
+		raw, err = dialer.DialPiper(uri, bufferSize)
+		return
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	result := make(chan dialPiperResult, 1)
+	go func() {
+		raw, err := dialer.DialPiper(uri, bufferSize)
+		result <- dialPiperResult{raw: raw, err: err}
+	}()
+	select {
+	case r := <-result:
+		raw, err = r.raw, r.err
+	case <-ctx.Done():
+		err = ctx.Err()
+		go func() {
+			if r := <-result; r.err == nil {
+				r.raw.Close()
+			}
+		}()
+	}
+	return
+}
+
+type clientContextKey struct{}
+
+// ContextWithClient will return ctx carrying the client address of accepted connection, it is read by ClientFromContext on dialer
+func ContextWithClient(ctx context.Context, client string) context.Context {
+	return context.WithValue(ctx, clientContextKey{}, client)
+}
+
+// ClientFromContext will return the client address carried by ContextWithClient, it is empty when not carried
+func ClientFromContext(ctx context.Context) (client string) {
+	client, _ = ctx.Value(clientContextKey{}).(string)
+	return
+}
+
+// DialPiperClient will dial piper by dialer with background context carrying the client address
+func DialPiperClient(client string, dialer PiperDialer, uri string, bufferSize int) (raw Piper, err error) {
+	raw, err = DialPiperContext(ContextWithClient(context.Background(), client), dialer, uri, bufferSize)
+	return
+}
+
 // NetPiper is Piper implement by net.Dial
 type NetPiper struct {
 	net.Conn
 	CopyPiper
+	ProxyProtocol int
 }
 
-// DialNetPiper will return new NetPiper by net.Dial
+// PipeConn will send PROXY protocol header by conn address when ProxyProtocol is 1 or 2, then pipe connection to raw
+func (n *NetPiper) PipeConn(conn io.ReadWriteCloser, target string) (err error) {
+	if n.ProxyProtocol > 0 {
+		err = WriteProxyHeader(n.Conn, n.ProxyProtocol, conn)
+		if err != nil {
+			n.Conn.Close()
+			conn.Close()
+			return
+		}
+	}
+	err = n.CopyPiper.PipeConn(conn, target)
+	return
+}
+
+// DialNetPiper will return new NetPiper by net.Dial, the tls://host:port is dialed by tls with default config
 func DialNetPiper(uri string, bufferSize int) (piper Piper, err error) {
+	piper, err = (&NetPiperDialer{}).DialPiper(uri, bufferSize)
+	return
+}
+
+// DialNetPiperContext will return new NetPiper by net.Dialer with context, the tls://host:port is dialed by tls with default config
+func DialNetPiperContext(ctx context.Context, uri string, bufferSize int) (piper Piper, err error) {
+	piper, err = (&NetPiperDialer{}).DialPiperContext(ctx, uri, bufferSize)
+	return
+}
+
+// NetPiperDialer is PiperDialer to dial NetPiper by net.Dial, the tls://host:port is dialed by tls with TLSConfig,
+// the PROXY protocol header of version ProxyProtocol is sent to backend before piping when it is 1 or 2
+type NetPiperDialer struct {
+	TLSConfig     *tls.Config
+	ProxyProtocol int
+}
+
+// DialPiper will return new NetPiper by uri like tcp://host:port, tls://host:port or host:port
+func (n *NetPiperDialer) DialPiper(uri string, bufferSize int) (piper Piper, err error) {
+	piper, err = n.DialPiperContext(context.Background(), uri, bufferSize)
+	return
+}
+
+// DialPiperContext will return new NetPiper by uri with context, the uri is same as DialPiper
+func (n *NetPiperDialer) DialPiperContext(ctx context.Context, uri string, bufferSize int) (piper Piper, err error) {
 	var network, address string
 	parts := strings.SplitN(uri, "://", 2)
 	if len(parts) < 2 {
@@ -74,7 +193,12 @@ func DialNetPiper(uri string, bufferSize int) (piper Piper, err error) {
 		network = parts[0]
 		address = parts[1]
 	}
-	conn, err := net.Dial(network, address)
+	var conn net.Conn
+	if network == "tls" {
+		conn, err = (&tls.Dialer{Config: n.TLSConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		conn, err = (&net.Dialer{}).DialContext(ctx, network, address)
+	}
 	if err == nil {
 		piper = &NetPiper{
 			Conn: conn,
@@ -82,6 +206,7 @@ func DialNetPiper(uri string, bufferSize int) (piper Piper, err error) {
 				ReadWriteCloser: conn,
 				BufferSize:      bufferSize,
 			},
+			ProxyProtocol: n.ProxyProtocol,
 		}
 	}
 	return
@@ -232,3 +357,30 @@ func (b *ByteDistributeProcessor) Close() (err error) {
 	b.locker.Unlock()
 	return
 }
+
+// Shutdown will close all listener and wait running connection done until ctx is done, then close the remaining.
+// The drained is count of connection done by self and killed is count of connection closed by force.
+func (b *ByteDistributeProcessor) Shutdown(ctx context.Context) (drained, killed int, err error) {
+	b.locker.Lock()
+	for _, listener := range b.listeners {
+		listener.Close()
+	}
+	b.locker.Unlock()
+	size := func() (n int) {
+		b.locker.RLock()
+		n = len(b.conns)
+		b.locker.RUnlock()
+		return
+	}
+	kill := func() (n int) {
+		b.locker.Lock()
+		for _, conn := range b.conns {
+			conn.Close()
+			n++
+		}
+		b.locker.Unlock()
+		return
+	}
+	drained, killed, err = WaitDrain(ctx, size, kill)
+	return
+}
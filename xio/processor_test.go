@@ -1,10 +1,12 @@
 package xio
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"net"
 	_ "net/http/pprof"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -61,6 +63,79 @@ func TestNetPiper(t *testing.T) {
 	}
 }
 
+func TestDialPiperContext(t *testing.T) {
+	var closed int32
+	slow := PiperDialerF(func(uri string, bufferSize int) (raw Piper, err error) {
+		time.Sleep(100 * time.Millisecond)
+		raw = PiperF(func(conn io.ReadWriteCloser, target string) error { return nil })
+		raw = &closeCountPiper{Piper: raw, closed: &closed}
+		return
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := DialPiperContext(ctx, slow, "tcp://127.0.0.1:80", 1024)
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+		return
+	}
+	time.Sleep(200 * time.Millisecond)
+	if atomic.LoadInt32(&closed) != 1 {
+		t.Error("not closed")
+		return
+	}
+	if _, err = DialPiperContext(context.Background(), slow, "tcp://127.0.0.1:80", 1024); err != nil {
+		t.Error(err)
+		return
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = DialPiperContext(canceled, slow, "tcp://127.0.0.1:80", 1024); err != context.Canceled {
+		t.Error(err)
+		return
+	}
+	//context dialer
+	var called string
+	ctxDialer := PiperContextDialerF(func(ctx context.Context, uri string, bufferSize int) (raw Piper, err error) {
+		called, err = uri, ctx.Err()
+		return
+	})
+	if _, err = DialPiperContext(canceled, ctxDialer, "tcp://127.0.0.1:80", 1024); err != context.Canceled || called != "tcp://127.0.0.1:80" {
+		t.Error(err)
+		return
+	}
+	if _, err = ctxDialer.DialPiper("tcp://127.0.0.1:81", 1024); err != nil || called != "tcp://127.0.0.1:81" {
+		t.Error(err)
+		return
+	}
+	//net piper
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	piper, err := DialNetPiperContext(context.Background(), "tcp://"+listener.Addr().String(), 1024)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	piper.Close()
+	if _, err = DialNetPiperContext(canceled, "tcp://"+listener.Addr().String(), 1024); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = (&NetPiperDialer{}).DialPiperContext(canceled, "tls://"+listener.Addr().String(), 1024); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+type closeCountPiper struct {
+	Piper
+	closed *int32
+}
+
+func (c *closeCountPiper) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return c.Piper.Close()
+}
+
 func TestByteDistribute(t *testing.T) {
 	accept := make(chan net.Conn, 1)
 	processor := NewByteDistributeProcessor()
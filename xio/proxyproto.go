@@ -0,0 +1,317 @@
+package xio
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// proxyV2Signature is the signature of PROXY protocol v2 header
+var proxyV2Signature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+// ErrProxyHeaderRequired is the error when PROXY protocol header is required but not exists
+var ErrProxyHeaderRequired = fmt.Errorf("%v", "proxy protocol header is required")
+
+// ProxyHeader is the header of HAProxy PROXY protocol v1/v2
+type ProxyHeader struct {
+	Version     int
+	Local       bool
+	Source      net.Addr
+	Destination net.Addr
+}
+
+// NewProxyHeader will return new ProxyHeader by version and address, it is local when address is not tcp/udp address
+func NewProxyHeader(version int, source, destination net.Addr) (header *ProxyHeader) {
+	header = &ProxyHeader{Version: version, Source: source, Destination: destination}
+	header.Local = proxyAddrIP(source) == nil || proxyAddrIP(destination) == nil
+	return
+}
+
+func proxyAddrIP(addr net.Addr) (ip net.IP) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		ip = addr.IP
+	case *net.UDPAddr:
+		ip = addr.IP
+	}
+	return
+}
+
+func proxyAddrPort(addr net.Addr) (port int) {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		port = addr.Port
+	case *net.UDPAddr:
+		port = addr.Port
+	}
+	return
+}
+
+func proxyAddr(udp bool, ip net.IP, port int) net.Addr {
+	if udp {
+		return &net.UDPAddr{IP: ip, Port: port}
+	}
+	return &net.TCPAddr{IP: ip, Port: port}
+}
+
+// Bytes will return the encoded header by Version
+func (p *ProxyHeader) Bytes() (data []byte) {
+	if p.Version == 2 {
+		data = p.bytesV2()
+	} else {
+		data = p.bytesV1()
+	}
+	return
+}
+
+func (p *ProxyHeader) bytesV1() (data []byte) {
+	src, dst := proxyAddrIP(p.Source), proxyAddrIP(p.Destination)
+	if p.Local || src == nil || dst == nil {
+		return []byte("PROXY UNKNOWN\r\n")
+	}
+	family := "TCP4"
+	if src.To4() == nil || dst.To4() == nil {
+		family = "TCP6"
+	}
+	return []byte(fmt.Sprintf("PROXY %v %v %v %v %v\r\n", family, src, dst, proxyAddrPort(p.Source), proxyAddrPort(p.Destination)))
+}
+
+func (p *ProxyHeader) bytesV2() (data []byte) {
+	buf := bytes.NewBuffer(nil)
+	buf.Write(proxyV2Signature)
+	src, dst := proxyAddrIP(p.Source), proxyAddrIP(p.Destination)
+	if p.Local || src == nil || dst == nil {
+		buf.Write([]byte{0x20, 0x00, 0x00, 0x00})
+		return buf.Bytes()
+	}
+	_, udp := p.Source.(*net.UDPAddr)
+	var family byte
+	var addrs []byte
+	if src.To4() != nil && dst.To4() != nil {
+		family = 0x10
+		addrs = append(append(addrs, src.To4()...), dst.To4()...)
+	} else {
+		family = 0x20
+		addrs = append(append(addrs, src.To16()...), dst.To16()...)
+	}
+	if udp {
+		family |= 0x02
+	} else {
+		family |= 0x01
+	}
+	ports := make([]byte, 4)
+	binary.BigEndian.PutUint16(ports, uint16(proxyAddrPort(p.Source)))
+	binary.BigEndian.PutUint16(ports[2:], uint16(proxyAddrPort(p.Destination)))
+	addrs = append(addrs, ports...)
+	buf.Write([]byte{0x21, family})
+	binary.Write(buf, binary.BigEndian, uint16(len(addrs)))
+	buf.Write(addrs)
+	return buf.Bytes()
+}
+
+// ReadProxyHeader will read PROXY protocol v1/v2 header from conn, the header is nil and readed data is saved to conn prefix when header is not exists
+func ReadProxyHeader(conn *PrefixConn) (header *ProxyHeader, err error) {
+	first := make([]byte, 1)
+	if _, err = io.ReadFull(conn.Conn, first); err != nil {
+		return
+	}
+	switch first[0] {
+	case 'P':
+		header, err = readProxyHeaderV1(conn, first)
+	case proxyV2Signature[0]:
+		header, err = readProxyHeaderV2(conn, first)
+	default:
+		conn.Prefix = first
+	}
+	return
+}
+
+func readProxyHeaderV1(conn *PrefixConn, readed []byte) (header *ProxyHeader, err error) {
+	prefix := make([]byte, 6)
+	copy(prefix, readed)
+	if _, err = io.ReadFull(conn.Conn, prefix[1:]); err != nil {
+		return
+	}
+	if string(prefix) != "PROXY " {
+		conn.Prefix = prefix
+		return
+	}
+	line := prefix
+	one := make([]byte, 1)
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) >= 107 {
+			err = fmt.Errorf("proxy protocol v1 header is too long")
+			return
+		}
+		if _, err = io.ReadFull(conn.Conn, one); err != nil {
+			return
+		}
+		line = append(line, one[0])
+	}
+	fields := strings.Fields(string(line))
+	header = &ProxyHeader{Version: 1}
+	if len(fields) > 1 && fields[1] == "UNKNOWN" {
+		header.Local = true
+		return
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		err = fmt.Errorf("proxy protocol v1 header %v is invalid", strings.TrimSpace(string(line)))
+		return
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, srcErr := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, dstErr := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || srcErr != nil || dstErr != nil {
+		err = fmt.Errorf("proxy protocol v1 header %v is invalid", strings.TrimSpace(string(line)))
+		return
+	}
+	header.Source = &net.TCPAddr{IP: src, Port: int(srcPort)}
+	header.Destination = &net.TCPAddr{IP: dst, Port: int(dstPort)}
+	return
+}
+
+func readProxyHeaderV2(conn *PrefixConn, readed []byte) (header *ProxyHeader, err error) {
+	head := make([]byte, 16)
+	copy(head, readed)
+	for i := 1; i < len(proxyV2Signature); i++ {
+		if _, err = io.ReadFull(conn.Conn, head[i:i+1]); err != nil {
+			return
+		}
+		if head[i] != proxyV2Signature[i] {
+			conn.Prefix = head[:i+1]
+			return
+		}
+	}
+	if _, err = io.ReadFull(conn.Conn, head[12:]); err != nil {
+		return
+	}
+	if head[12]>>4 != 0x02 {
+		err = fmt.Errorf("proxy protocol v2 version %v is not supported", head[12]>>4)
+		return
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err = io.ReadFull(conn.Conn, body); err != nil {
+		return
+	}
+	header = &ProxyHeader{Version: 2}
+	family, udp := head[13]>>4, head[13]&0x0F == 0x02
+	switch {
+	case head[12]&0x0F == 0x00: //LOCAL
+		header.Local = true
+	case family == 0x01 && len(body) >= 12:
+		header.Source = proxyAddr(udp, net.IP(body[0:4]), int(binary.BigEndian.Uint16(body[8:])))
+		header.Destination = proxyAddr(udp, net.IP(body[4:8]), int(binary.BigEndian.Uint16(body[10:])))
+	case family == 0x02 && len(body) >= 36:
+		header.Source = proxyAddr(udp, net.IP(body[0:16]), int(binary.BigEndian.Uint16(body[32:])))
+		header.Destination = proxyAddr(udp, net.IP(body[16:32]), int(binary.BigEndian.Uint16(body[34:])))
+	default: //unspec or unix
+		header.Local = true
+	}
+	return
+}
+
+// ProxyProtoConn is net.Conn to parse PROXY protocol header on first Read or RemoteAddr/LocalAddr,
+// the RemoteAddr/LocalAddr is the source/destination of header when it exists
+type ProxyProtoConn struct {
+	*PrefixConn
+	Required bool
+	Timeout  time.Duration
+	header   *ProxyHeader
+	err      error
+	once     sync.Once
+}
+
+// NewProxyProtoConn will return new ProxyProtoConn
+func NewProxyProtoConn(conn net.Conn) (proxy *ProxyProtoConn) {
+	proxy = &ProxyProtoConn{PrefixConn: NewPrefixConn(conn)}
+	return
+}
+
+// Header will parse and return the PROXY protocol header, it is nil when header is not exists
+func (p *ProxyProtoConn) Header() (header *ProxyHeader, err error) {
+	p.once.Do(func() {
+		if p.Timeout > 0 {
+			p.Conn.SetReadDeadline(time.Now().Add(p.Timeout))
+		}
+		p.header, p.err = ReadProxyHeader(p.PrefixConn)
+		if p.err == nil && p.header == nil && p.Required {
+			p.err = ErrProxyHeaderRequired
+		}
+		if p.Timeout > 0 {
+			p.Conn.SetReadDeadline(time.Time{})
+		}
+	})
+	header, err = p.header, p.err
+	return
+}
+
+func (p *ProxyProtoConn) Read(b []byte) (n int, err error) {
+	if _, err = p.Header(); err == nil {
+		n, err = p.PrefixConn.Read(b)
+	}
+	return
+}
+
+// RemoteAddr will return the source address of header or raw remote address
+func (p *ProxyProtoConn) RemoteAddr() net.Addr {
+	if header, _ := p.Header(); header != nil && !header.Local {
+		return header.Source
+	}
+	return p.Conn.RemoteAddr()
+}
+
+// LocalAddr will return the destination address of header or raw local address
+func (p *ProxyProtoConn) LocalAddr() net.Addr {
+	if header, _ := p.Header(); header != nil && !header.Local {
+		return header.Destination
+	}
+	return p.Conn.LocalAddr()
+}
+
+func (p *ProxyProtoConn) String() string {
+	return RemoteAddr(p)
+}
+
+// ProxyProtoListener is net.Listener to wrap accepted conn to ProxyProtoConn.
+// The header should only be accepted from trusted load balancer, so Required is true by default to reject the conn without header.
+type ProxyProtoListener struct {
+	net.Listener
+	Required bool
+	Timeout  time.Duration
+}
+
+// NewProxyProtoListener will return new ProxyProtoListener
+func NewProxyProtoListener(listener net.Listener) (proxy *ProxyProtoListener) {
+	proxy = &ProxyProtoListener{Listener: listener, Required: true, Timeout: 5 * time.Second}
+	return
+}
+
+// Accept will accept conn and wrap to ProxyProtoConn
+func (p *ProxyProtoListener) Accept() (conn net.Conn, err error) {
+	raw, err := p.Listener.Accept()
+	if err == nil {
+		proxy := NewProxyProtoConn(raw)
+		proxy.Required, proxy.Timeout = p.Required, p.Timeout
+		conn = proxy
+	}
+	return
+}
+
+// WriteProxyHeader will write PROXY protocol header to writer by source/destination of conn
+func WriteProxyHeader(writer io.Writer, version int, conn interface{}) (err error) {
+	var source, destination net.Addr
+	if addr, ok := conn.(interface {
+		RemoteAddr() net.Addr
+		LocalAddr() net.Addr
+	}); ok {
+		source, destination = addr.RemoteAddr(), addr.LocalAddr()
+	}
+	_, err = writer.Write(NewProxyHeader(version, source, destination).Bytes())
+	return
+}
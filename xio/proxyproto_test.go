@@ -0,0 +1,154 @@
+package xio
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func readProxyProtoConn(data []byte, required bool) (conn *ProxyProtoConn, err error) {
+	local, remote := net.Pipe()
+	go func() {
+		local.Write(data)
+		local.Close()
+	}()
+	conn = NewProxyProtoConn(remote)
+	conn.Required = required
+	_, err = conn.Header()
+	return
+}
+
+func TestProxyHeader(t *testing.T) {
+	source := &net.TCPAddr{IP: net.ParseIP("192.168.1.10"), Port: 1000}
+	destination := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80}
+	source6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 1000}
+	destination6 := &net.TCPAddr{IP: net.ParseIP("2001:db8::2"), Port: 80}
+	for _, version := range []int{1, 2} {
+		for _, addrs := range [][]net.Addr{{source, destination}, {source6, destination6}} {
+			header := NewProxyHeader(version, addrs[0], addrs[1])
+			conn, err := readProxyProtoConn(append(header.Bytes(), []byte("abc")...), true)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if conn.RemoteAddr().String() != addrs[0].String() || conn.LocalAddr().String() != addrs[1].String() {
+				t.Errorf("%v,%v", conn.RemoteAddr(), conn.LocalAddr())
+				return
+			}
+			buf := make([]byte, 3)
+			if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != "abc" {
+				t.Errorf("%v,%v", err, string(buf))
+				return
+			}
+		}
+		//local
+		header := NewProxyHeader(version, nil, nil)
+		conn, err := readProxyProtoConn(header.Bytes(), true)
+		if err != nil || conn.header == nil || !conn.header.Local || conn.RemoteAddr().String() != "pipe" {
+			t.Errorf("%v,%v", err, conn.header)
+			return
+		}
+	}
+	//udp v2
+	udpSource := &net.UDPAddr{IP: net.ParseIP("192.168.1.10"), Port: 1000}
+	udpDestination := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 53}
+	conn, err := readProxyProtoConn(NewProxyHeader(2, udpSource, udpDestination).Bytes(), true)
+	if err != nil || conn.RemoteAddr().Network() != "udp" || conn.RemoteAddr().String() != udpSource.String() {
+		t.Errorf("%v,%v", err, conn.RemoteAddr())
+		return
+	}
+}
+
+func TestProxyHeaderNotExists(t *testing.T) {
+	for _, data := range []string{"\x05\x01\x00", "POST / HTTP/1.1\r\n", "GET / HTTP/1.1\r\n", "\r\n\r\nabc"} {
+		conn, err := readProxyProtoConn([]byte(data), false)
+		if err != nil || conn.header != nil {
+			t.Errorf("%v,%v", err, conn.header)
+			return
+		}
+		buf := make([]byte, len(data))
+		if _, err = io.ReadFull(conn, buf); err != nil || string(buf) != data {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+	}
+	//required
+	conn, err := readProxyProtoConn([]byte("GET / HTTP/1.1\r\n"), true)
+	if err != ErrProxyHeaderRequired {
+		t.Error(err)
+		return
+	}
+	if _, err = conn.Read(make([]byte, 1)); err != ErrProxyHeaderRequired {
+		t.Error(err)
+		return
+	}
+	//invalid
+	for _, data := range []string{"PROXY TCP4 abc\r\n", "PROXY TCP4 1.1.1.1 2.2.2.2 x 80\r\n", "PROXY " + string(make([]byte, 200)), "\r\n\r\n\x00\r\nQUIT\n\x11\x21\x00\x00"} {
+		if _, err = readProxyProtoConn([]byte(data), false); err == nil {
+			t.Error(data)
+			return
+		}
+	}
+}
+
+func TestProxyProtoListener(t *testing.T) {
+	raw, _ := net.Listen("tcp", "127.0.0.1:0")
+	listener := NewProxyProtoListener(raw)
+	listener.Timeout = 100 * time.Millisecond
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				fmt.Fprintf(conn, "%v", conn.RemoteAddr())
+				conn.Close()
+			}()
+		}
+	}()
+	backend := listener.Addr().String()
+	for _, version := range []int{1, 2} {
+		front, _ := net.Listen("tcp", "127.0.0.1:0")
+		go func() {
+			conn, err := front.Accept()
+			if err != nil {
+				return
+			}
+			piper, err := (&NetPiperDialer{ProxyProtocol: version}).DialPiper("tcp://"+backend, 1024)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			piper.PipeConn(conn, backend)
+		}()
+		client, _ := net.Dial("tcp", front.Addr().String())
+		data, err := io.ReadAll(client)
+		client.Close()
+		front.Close()
+		if err != nil || string(data) != client.LocalAddr().String() {
+			t.Errorf("%v,%v", err, string(data))
+			return
+		}
+	}
+	//tcp source
+	client, _ := net.Dial("tcp", backend)
+	fmt.Fprintf(client, "PROXY TCP4 1.2.3.4 5.6.7.8 1000 80\r\n")
+	data, err := io.ReadAll(client)
+	client.Close()
+	if err != nil || string(data) != "1.2.3.4:1000" {
+		t.Errorf("%v,%v", err, string(data))
+		return
+	}
+	//not header
+	client, _ = net.Dial("tcp", backend)
+	data, _ = io.ReadAll(client)
+	client.Close()
+	if string(data) != client.LocalAddr().String() {
+		t.Errorf("%v", string(data))
+		return
+	}
+}
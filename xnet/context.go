@@ -0,0 +1,123 @@
+package xnet
+
+import (
+	"context"
+	"io"
+	"net"
+)
+
+// ContextDialer is interface for dial raw connect by string with context
+type ContextDialer interface {
+	DialContext(ctx context.Context, remote string) (raw io.ReadWriteCloser, err error)
+}
+
+// RawContextDialer is an interface to dial raw connection with context, the net.Dialer is implemented
+type RawContextDialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// RawContextDialerF is an the implementation of RawDialer and RawContextDialer by func
+type RawContextDialerF func(ctx context.Context, network, address string) (net.Conn, error)
+
+// Dial dial to remote by func with background context
+func (d RawContextDialerF) Dial(network, address string) (raw net.Conn, err error) {
+	raw, err = d(context.Background(), network, address)
+	return
+}
+
+// DialContext dial to remote by func
+func (d RawContextDialerF) DialContext(ctx context.Context, network, address string) (raw net.Conn, err error) {
+	raw, err = d(ctx, network, address)
+	return
+}
+
+// DialRawContext will dial by DialContext when dialer is RawContextDialer,
+// else dial by Dial in background and close the connection when ctx is done first
+func DialRawContext(ctx context.Context, dialer RawDialer, network, address string) (conn net.Conn, err error) {
+	if ctxDialer, ok := dialer.(RawContextDialer); ok {
+		conn, err = ctxDialer.DialContext(ctx, network, address)
+		return
+	}
+	raw, err := dialAsync(ctx, func() (io.ReadWriteCloser, error) {
+		return dialer.Dial(network, address)
+	})
+	if err == nil {
+		conn = raw.(net.Conn)
+	}
+	return
+}
+
+// DialContext will dial by DialContext when dialer is ContextDialer,
+// else dial by Dial in background and close the connection when ctx is done first
+func DialContext(ctx context.Context, dialer Dialer, remote string) (raw io.ReadWriteCloser, err error) {
+	if ctxDialer, ok := dialer.(ContextDialer); ok {
+		raw, err = ctxDialer.DialContext(ctx, remote)
+		return
+	}
+	raw, err = dialAsync(ctx, func() (io.ReadWriteCloser, error) {
+		return dialer.Dial(remote)
+	})
+	return
+}
+
+type dialResult struct {
+	raw io.ReadWriteCloser
+	err error
+}
+
+func dialAsync(ctx context.Context, dial func() (io.ReadWriteCloser, error)) (raw io.ReadWriteCloser, err error) {
+	if ctx.Done() == nil {
+		raw, err = dial()
+		return
+	}
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	result := make(chan dialResult, 1)
+	go func() {
+		raw, err := dial()
+		result <- dialResult{raw: raw, err: err}
+	}()
+	select {
+	case r := <-result:
+		raw, err = r.raw, r.err
+	case <-ctx.Done():
+		err = ctx.Err()
+		go func() {
+			if r := <-result; r.err == nil {
+				r.raw.Close()
+			}
+		}()
+	}
+	return
+}
+
+// CloseOnDone will close closer when ctx is done before the returned stop is called,
+// it is used to cancel the blocking handshake on connection, the stop returns ctx.Err() when closer is closed by ctx
+func CloseOnDone(ctx context.Context, closer io.Closer) (stop func() error) {
+	if ctx.Done() == nil {
+		return func() error { return nil }
+	}
+	exiter := make(chan int)
+	done := make(chan error, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			closer.Close()
+			done <- ctx.Err()
+		case <-exiter:
+			done <- nil
+		}
+	}()
+	var stopped bool
+	var err error
+	stop = func() error {
+		if !stopped {
+			stopped = true
+			close(exiter)
+			err = <-done
+		}
+		return err
+	}
+	return
+}
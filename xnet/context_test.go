@@ -0,0 +1,159 @@
+package xnet
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type closeCountConn struct {
+	net.Conn
+	closed *int32
+}
+
+func (c *closeCountConn) Close() error {
+	atomic.AddInt32(c.closed, 1)
+	return c.Conn.Close()
+}
+
+func runBlackhole() (listener net.Listener) {
+	listener, _ = net.Listen("tcp", "127.0.0.1:0")
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(io.Discard, conn)
+		}
+	}()
+	return
+}
+
+func TestDialRawContext(t *testing.T) {
+	var closed int32
+	slow := RawDialerF(func(network, address string) (net.Conn, error) {
+		time.Sleep(100 * time.Millisecond)
+		local, _ := net.Pipe()
+		return &closeCountConn{Conn: local, closed: &closed}, nil
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := DialRawContext(ctx, slow, "tcp", "127.0.0.1:80")
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+		return
+	}
+	time.Sleep(200 * time.Millisecond)
+	if atomic.LoadInt32(&closed) != 1 {
+		t.Error("not closed")
+		return
+	}
+	conn, err := DialRawContext(context.Background(), slow, "tcp", "127.0.0.1:80")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+	//context dialer
+	var called string
+	ctxDialer := RawContextDialerF(func(ctx context.Context, network, address string) (net.Conn, error) {
+		called = address
+		return nil, fmt.Errorf("test")
+	})
+	if _, err = DialRawContext(ctx, ctxDialer, "tcp", "127.0.0.1:80"); err == nil || called != "127.0.0.1:80" {
+		t.Error(err)
+		return
+	}
+	if _, err = ctxDialer.Dial("tcp", "127.0.0.1:81"); err == nil || called != "127.0.0.1:81" {
+		t.Error(err)
+		return
+	}
+	//canceled
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = DialRawContext(canceled, slow, "tcp", "127.0.0.1:80"); err != context.Canceled {
+		t.Error(err)
+		return
+	}
+	//wrapper
+	blackhole := runBlackhole()
+	defer blackhole.Close()
+	raw, err := DialContext(context.Background(), NewNetDailer(), "tcp://"+blackhole.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	raw.Close()
+	if _, err = DialContext(canceled, NewNetDailer(), "tcp://"+blackhole.Addr().String()); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestCloseOnDone(t *testing.T) {
+	var closed int32
+	local, _ := net.Pipe()
+	conn := &closeCountConn{Conn: local, closed: &closed}
+	stop := CloseOnDone(context.Background(), conn)
+	if err := stop(); err != nil || atomic.LoadInt32(&closed) != 0 {
+		t.Error(err)
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stop = CloseOnDone(ctx, conn)
+	if err := stop(); err != nil || stop() != nil || atomic.LoadInt32(&closed) != 0 {
+		t.Error(err)
+		return
+	}
+	stop = CloseOnDone(ctx, conn)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	if err := stop(); err != context.Canceled || atomic.LoadInt32(&closed) != 1 {
+		t.Error(err)
+		return
+	}
+}
+
+func TestDialContextTimeout(t *testing.T) {
+	blackhole := runBlackhole()
+	defer blackhole.Close()
+	address := blackhole.Addr().String()
+	{ //tls
+		dialer := NewTLSDialer(&tls.Config{InsecureSkipVerify: true})
+		dialer.Timeout = 0
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := dialer.DialContext(ctx, "tcp", address)
+		cancel()
+		if err == nil {
+			t.Error(err)
+			return
+		}
+		dialer.Timeout = 50 * time.Millisecond
+		if _, err = dialer.Dial("tcp", address); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //websocket
+		dialer := NewWebsocketDialer()
+		begin := time.Now()
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		_, err := dialer.DialContext(ctx, "ws://"+address)
+		cancel()
+		if err == nil || time.Since(begin) > time.Second {
+			t.Error(err)
+			return
+		}
+		if _, err = dialer.DialContext(context.Background(), "wss://"+address+"?timeout=1"); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package xnet
 
 import (
+	"context"
 	"io"
 	"net"
 	"net/url"
@@ -42,6 +43,12 @@ func NewNetDailer() (dialer *RawDialerWrapper) {
 }
 
 func (w RawDialerWrapper) Dial(remote string) (raw io.ReadWriteCloser, err error) {
+	raw, err = w.DialContext(context.Background(), remote)
+	return
+}
+
+// DialContext will dial remote by raw dialer with context
+func (w RawDialerWrapper) DialContext(ctx context.Context, remote string) (raw io.ReadWriteCloser, err error) {
 	remoteURI, err := url.Parse(remote)
 	if err != nil {
 		return
@@ -58,6 +65,6 @@ func (w RawDialerWrapper) Dial(remote string) (raw io.ReadWriteCloser, err error
 			network = "tcp"
 		}
 	}
-	raw, err = w.RawDialer.Dial(network, address)
+	raw, err = DialRawContext(ctx, w.RawDialer, network, address)
 	return
 }
@@ -0,0 +1,459 @@
+package xnet
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/codingeasygo/util/xio/frame"
+)
+
+const (
+	layerVersion    = 1
+	layerSaltSize   = 16
+	layerMacSize    = sha256.Size
+	layerFrameSize  = 16 * 1024
+	layerFlagFlate  = 0x01
+	layerFlagPSK    = 0x02
+	layerStatusOK   = 0x00
+	layerStatusPSK  = 0x01
+	layerStatusFlag = 0x02
+)
+
+var layerMagic = []byte("XNL")
+
+// ErrLayerPSK is the error when pre-shared key of layer is not matched by peer
+var ErrLayerPSK = fmt.Errorf("layer pre-shared key is not matched")
+
+// LayerOption is the option of stream layer, the stream is compressed by Compress and encrypted by AES-GCM with key derived from PSK,
+// the compress is requested by client and accepted by server when it is supported, the PSK must be same on both ends.
+// The handshake is authorized by key derived from PSK and the traffic key of each direction is derived from PSK and the salts of both ends by HKDF-SHA256.
+//
+// The compress is applied before encrypt, so the length of ciphertext leaks how the plaintext is compressed (like CRIME),
+// do not combine compress with PSK when the stream is mixing secret with data which can be influenced by attacker.
+type LayerOption struct {
+	Compress string
+	PSK      []byte
+}
+
+// ParseLayerOption will parse LayerOption from compress/psk of query, it is nil when both are empty
+func ParseLayerOption(query url.Values) (option *LayerOption, err error) {
+	compress, psk := query.Get("compress"), query.Get("psk")
+	if len(compress) < 1 && len(psk) < 1 {
+		return
+	}
+	if len(compress) > 0 && compress != "flate" {
+		err = fmt.Errorf("not supported compress %v", compress)
+		return
+	}
+	option = &LayerOption{Compress: compress}
+	if len(psk) > 0 {
+		option.PSK = []byte(psk)
+	}
+	return
+}
+
+// SplitLayerURI will parse LayerOption from query of uri and return the uri without compress/psk,
+// the uri can be url like ws://host/path?psk=xx or address like :8080?psk=xx
+func SplitLayerURI(uri string) (target string, option *LayerOption, err error) {
+	target = uri
+	queryPos := strings.Index(uri, "?")
+	if queryPos < 0 {
+		return
+	}
+	query, err := url.ParseQuery(uri[queryPos+1:])
+	if err != nil {
+		return
+	}
+	option, err = ParseLayerOption(query)
+	if err != nil || option == nil {
+		return
+	}
+	query.Del("compress")
+	query.Del("psk")
+	target = uri[:queryPos]
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return
+}
+
+func (l *LayerOption) flags() (flags byte) {
+	if l.Compress == "flate" {
+		flags |= layerFlagFlate
+	}
+	if len(l.PSK) > 0 {
+		flags |= layerFlagPSK
+	}
+	return
+}
+
+// sign will return the handshake mac of data by key derived from PSK
+func (l *LayerOption) sign(data ...[]byte) []byte {
+	mac := hmac.New(sha256.New, hkdf(l.PSK, nil, []byte("xnet layer auth"), sha256.Size))
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// key will return the AES-256 key of direction derived from PSK and the salts of both ends
+func (l *LayerOption) key(direction string, clientSalt, serverSalt []byte) []byte {
+	salt := make([]byte, 0, len(clientSalt)+len(serverSalt))
+	salt = append(salt, clientSalt...)
+	salt = append(salt, serverSalt...)
+	return hkdf(l.PSK, salt, []byte("xnet layer "+direction), 32)
+}
+
+// hkdf will derive key of size from secret by HKDF-SHA256 in RFC 5869 with salt and info
+func hkdf(secret, salt, info []byte, size int) (key []byte) {
+	if len(salt) < 1 {
+		salt = make([]byte, sha256.Size)
+	}
+	extractor := hmac.New(sha256.New, salt)
+	extractor.Write(secret)
+	prk := extractor.Sum(nil)
+	var block []byte
+	for counter := byte(1); len(key) < size; counter++ {
+		expander := hmac.New(sha256.New, prk)
+		expander.Write(block)
+		expander.Write(info)
+		expander.Write([]byte{counter})
+		block = expander.Sum(nil)
+		key = append(key, block...)
+	}
+	key = key[:size]
+	return
+}
+
+// ClientLayer will send layer handshake on raw and return the wrapped connection by option,
+// the raw is returned when option is nil, the raw is closed when handshake fail or ctx is done
+func ClientLayer(ctx context.Context, raw io.ReadWriteCloser, option *LayerOption) (conn io.ReadWriteCloser, err error) {
+	if option == nil {
+		conn = raw
+		return
+	}
+	stop := CloseOnDone(ctx, raw)
+	conn, err = clientLayer(raw, option)
+	if xerr := stop(); xerr != nil {
+		err = xerr
+	}
+	if err != nil {
+		raw.Close()
+		conn = nil
+	}
+	return
+}
+
+func clientLayer(raw io.ReadWriteCloser, option *LayerOption) (conn io.ReadWriteCloser, err error) {
+	flags := option.flags()
+	hello := make([]byte, 0, len(layerMagic)+2+layerSaltSize+layerMacSize)
+	hello = append(hello, layerMagic...)
+	hello = append(hello, layerVersion, flags)
+	clientSalt := make([]byte, layerSaltSize)
+	if _, err = rand.Read(clientSalt); err != nil {
+		return
+	}
+	hello = append(hello, clientSalt...)
+	if flags&layerFlagPSK == layerFlagPSK {
+		hello = append(hello, option.sign(hello)...)
+	}
+	if _, err = raw.Write(hello); err != nil {
+		return
+	}
+	reply := make([]byte, len(layerMagic)+3)
+	if _, err = io.ReadFull(raw, reply); err != nil {
+		return
+	}
+	if !bytes.Equal(reply[:len(layerMagic)], layerMagic) || reply[len(layerMagic)] != layerVersion {
+		err = fmt.Errorf("invalid layer reply")
+		return
+	}
+	switch reply[len(layerMagic)+1] {
+	case layerStatusOK:
+	case layerStatusPSK:
+		err = ErrLayerPSK
+		return
+	default:
+		err = fmt.Errorf("layer flags %x is not supported by server", flags)
+		return
+	}
+	if reply[len(layerMagic)+2] != flags {
+		err = fmt.Errorf("layer flags %x is not matched to %x", reply[len(layerMagic)+2], flags)
+		return
+	}
+	var serverSalt []byte
+	if flags&layerFlagPSK == layerFlagPSK {
+		more := make([]byte, layerSaltSize+layerMacSize)
+		if _, err = io.ReadFull(raw, more); err != nil {
+			return
+		}
+		serverSalt = more[:layerSaltSize]
+		if !hmac.Equal(more[layerSaltSize:], option.sign(reply, serverSalt, clientSalt)) {
+			err = ErrLayerPSK
+			return
+		}
+	}
+	conn, err = newLayerConn(raw, option, flags, option.key("client", clientSalt, serverSalt), option.key("server", clientSalt, serverSalt))
+	return
+}
+
+// ServerLayer will receive layer handshake on raw and return the wrapped connection by option and client request,
+// the raw is returned when option is nil, the raw is closed when handshake fail or ctx is done
+func ServerLayer(ctx context.Context, raw io.ReadWriteCloser, option *LayerOption) (conn io.ReadWriteCloser, err error) {
+	if option == nil {
+		conn = raw
+		return
+	}
+	stop := CloseOnDone(ctx, raw)
+	conn, err = serverLayer(raw, option)
+	if xerr := stop(); xerr != nil {
+		err = xerr
+	}
+	if err != nil {
+		raw.Close()
+		conn = nil
+	}
+	return
+}
+
+func serverLayer(raw io.ReadWriteCloser, option *LayerOption) (conn io.ReadWriteCloser, err error) {
+	hello := make([]byte, len(layerMagic)+2+layerSaltSize, len(layerMagic)+2+layerSaltSize+layerMacSize)
+	if _, err = io.ReadFull(raw, hello); err != nil {
+		return
+	}
+	if !bytes.Equal(hello[:len(layerMagic)], layerMagic) || hello[len(layerMagic)] != layerVersion {
+		err = fmt.Errorf("invalid layer hello")
+		return
+	}
+	flags := hello[len(layerMagic)+1]
+	clientSalt := hello[len(layerMagic)+2:]
+	reply := append([]byte{}, layerMagic...)
+	reply = append(reply, layerVersion, layerStatusOK, flags)
+	if flags&layerFlagPSK == layerFlagPSK {
+		mac := make([]byte, layerMacSize)
+		if _, err = io.ReadFull(raw, mac); err != nil {
+			return
+		}
+		if len(option.PSK) < 1 || !hmac.Equal(mac, option.sign(hello)) {
+			err = ErrLayerPSK
+		}
+	} else if len(option.PSK) > 0 {
+		err = ErrLayerPSK
+	}
+	if err == nil && flags&^(layerFlagFlate|layerFlagPSK) != 0 {
+		err = fmt.Errorf("layer flags %x is not supported", flags)
+		reply[len(layerMagic)+1] = layerStatusFlag
+	}
+	if err != nil {
+		if err == ErrLayerPSK {
+			reply[len(layerMagic)+1] = layerStatusPSK
+		}
+		raw.Write(reply)
+		return
+	}
+	var serverSalt []byte
+	if flags&layerFlagPSK == layerFlagPSK {
+		serverSalt = make([]byte, layerSaltSize)
+		if _, err = rand.Read(serverSalt); err != nil {
+			return
+		}
+		mac := option.sign(reply, serverSalt, clientSalt)
+		reply = append(reply, serverSalt...)
+		reply = append(reply, mac...)
+	}
+	if _, err = raw.Write(reply); err != nil {
+		return
+	}
+	conn, err = newLayerConn(raw, option, flags, option.key("server", clientSalt, serverSalt), option.key("client", clientSalt, serverSalt))
+	return
+}
+
+// LayerConn is the connection wrapped by compress/encrypt layer, it is returned by ClientLayer/ServerLayer
+type LayerConn struct {
+	Raw    io.ReadWriteCloser
+	reader io.Reader
+	writer io.Writer
+}
+
+func newLayerConn(raw io.ReadWriteCloser, option *LayerOption, flags byte, writeKey, readKey []byte) (conn *LayerConn, err error) {
+	conn = &LayerConn{Raw: raw, reader: raw, writer: raw}
+	if flags&layerFlagPSK == layerFlagPSK {
+		var reader *aeadReader
+		var writer *aeadWriter
+		framer := frame.NewReadWriter(nil, raw, layerFrameSize+64)
+		if reader, err = newAeadReader(framer, readKey); err != nil {
+			return
+		}
+		if writer, err = newAeadWriter(framer, writeKey); err != nil {
+			return
+		}
+		conn.reader, conn.writer = reader, writer
+	}
+	if flags&layerFlagFlate == layerFlagFlate {
+		var writer *flateWriter
+		if writer, err = newFlateWriter(conn.writer); err != nil {
+			return
+		}
+		conn.reader, conn.writer = flate.NewReader(conn.reader), writer
+	}
+	return
+}
+
+func (l *LayerConn) Read(p []byte) (n int, err error) {
+	n, err = l.reader.Read(p)
+	return
+}
+
+func (l *LayerConn) Write(p []byte) (n int, err error) {
+	n, err = l.writer.Write(p)
+	return
+}
+
+// Close will close the raw connection
+func (l *LayerConn) Close() (err error) {
+	err = l.Raw.Close()
+	return
+}
+
+// RemoteAddr will return the remote address of raw, it is nil when raw is not having address
+func (l *LayerConn) RemoteAddr() (addr net.Addr) {
+	if conn, ok := l.Raw.(interface{ RemoteAddr() net.Addr }); ok {
+		addr = conn.RemoteAddr()
+	}
+	return
+}
+
+// LocalAddr will return the local address of raw, it is nil when raw is not having address
+func (l *LayerConn) LocalAddr() (addr net.Addr) {
+	if conn, ok := l.Raw.(interface{ LocalAddr() net.Addr }); ok {
+		addr = conn.LocalAddr()
+	}
+	return
+}
+
+func (l *LayerConn) String() string {
+	return fmt.Sprintf("layer(%v)", l.RemoteAddr())
+}
+
+// flateWriter is io.Writer to compress and flush on each write, so the peer can read it without waiting more data
+type flateWriter struct {
+	*flate.Writer
+	lock sync.Mutex
+}
+
+func newFlateWriter(raw io.Writer) (writer *flateWriter, err error) {
+	compressor, err := flate.NewWriter(raw, flate.DefaultCompression)
+	if err == nil {
+		writer = &flateWriter{Writer: compressor}
+	}
+	return
+}
+
+func (f *flateWriter) Write(p []byte) (n int, err error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	n, err = f.Writer.Write(p)
+	if err == nil {
+		err = f.Writer.Flush()
+	}
+	return
+}
+
+func newAEAD(key []byte) (aead cipher.AEAD, err error) {
+	block, err := aes.NewCipher(key)
+	if err == nil {
+		aead, err = cipher.NewGCM(block)
+	}
+	return
+}
+
+// aeadWriter is io.Writer to seal data by AES-GCM and write it as frame, the nonce is the frame sequence
+type aeadWriter struct {
+	writer frame.Writer
+	aead   cipher.AEAD
+	nonce  []byte
+	seq    uint64
+	lock   sync.Mutex
+}
+
+func newAeadWriter(writer frame.Writer, key []byte) (a *aeadWriter, err error) {
+	aead, err := newAEAD(key)
+	if err == nil {
+		a = &aeadWriter{writer: writer, aead: aead, nonce: make([]byte, aead.NonceSize())}
+	}
+	return
+}
+
+func (a *aeadWriter) Write(p []byte) (n int, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	offset := a.writer.GetDataOffset()
+	for n < len(p) {
+		size := len(p) - n
+		if size > layerFrameSize {
+			size = layerFrameSize
+		}
+		binary.BigEndian.PutUint64(a.nonce[len(a.nonce)-8:], a.seq)
+		a.seq++
+		buffer := make([]byte, offset, offset+size+a.aead.Overhead())
+		buffer = a.aead.Seal(buffer, a.nonce, p[n:n+size], nil)
+		if _, err = a.writer.WriteFrame(buffer); err != nil {
+			break
+		}
+		n += size
+	}
+	return
+}
+
+// aeadReader is io.Reader to read frame and open it by AES-GCM, the nonce is the frame sequence
+type aeadReader struct {
+	reader frame.Reader
+	aead   cipher.AEAD
+	nonce  []byte
+	seq    uint64
+	plain  []byte
+	remain []byte
+	lock   sync.Mutex
+}
+
+func newAeadReader(reader frame.Reader, key []byte) (a *aeadReader, err error) {
+	aead, err := newAEAD(key)
+	if err == nil {
+		a = &aeadReader{reader: reader, aead: aead, nonce: make([]byte, aead.NonceSize()), plain: make([]byte, 0, layerFrameSize)}
+	}
+	return
+}
+
+func (a *aeadReader) Read(p []byte) (n int, err error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if len(a.remain) < 1 {
+		var data []byte
+		if data, err = a.reader.ReadFrame(); err != nil {
+			return
+		}
+		binary.BigEndian.PutUint64(a.nonce[len(a.nonce)-8:], a.seq)
+		a.seq++
+		a.remain, err = a.aead.Open(a.plain[:0], a.nonce, data[a.reader.GetDataOffset():], nil)
+		if err != nil {
+			err = fmt.Errorf("layer open frame fail with %v", err)
+			return
+		}
+	}
+	n = copy(p, a.remain)
+	a.remain = a.remain[n:]
+	return
+}
@@ -0,0 +1,244 @@
+package xnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+func runLayerEcho(client, server *LayerOption) (conn io.ReadWriteCloser, err error) {
+	conna, connb := net.Pipe()
+	go func() {
+		layered, xerr := ServerLayer(context.Background(), connb, server)
+		if xerr == nil {
+			io.Copy(layered, layered)
+			layered.Close()
+		}
+	}()
+	conn, err = ClientLayer(context.Background(), conna, client)
+	return
+}
+
+func TestLayer(t *testing.T) {
+	data := make([]byte, 100*1024)
+	rand.Read(data[:1024])
+	for _, option := range []*LayerOption{nil, {Compress: "flate"}, {PSK: []byte("123")}, {Compress: "flate", PSK: []byte("123")}} {
+		var server *LayerOption
+		if option != nil {
+			server = &LayerOption{PSK: option.PSK}
+		}
+		conn, err := runLayerEcho(option, server)
+		if err != nil {
+			t.Errorf("%v,%v", option, err)
+			return
+		}
+		go conn.Write(data)
+		readed := make([]byte, len(data))
+		if _, err = io.ReadFull(conn, readed); err != nil || !bytes.Equal(data, readed) {
+			t.Errorf("%v,%v", option, err)
+			return
+		}
+		if option != nil {
+			if layered, ok := conn.(*LayerConn); !ok || layered.RemoteAddr() == nil || layered.LocalAddr() == nil || len(layered.String()) < 1 {
+				t.Error(conn)
+				return
+			}
+		}
+		conn.Close()
+	}
+}
+
+func TestLayerError(t *testing.T) {
+	//psk not matched
+	if _, err := runLayerEcho(&LayerOption{PSK: []byte("123")}, &LayerOption{PSK: []byte("abc")}); err != ErrLayerPSK {
+		t.Error(err)
+		return
+	}
+	//psk required
+	if _, err := runLayerEcho(&LayerOption{Compress: "flate"}, &LayerOption{PSK: []byte("abc")}); err != ErrLayerPSK {
+		t.Error(err)
+		return
+	}
+	//psk not having on server
+	if _, err := runLayerEcho(&LayerOption{PSK: []byte("123")}, &LayerOption{}); err != ErrLayerPSK {
+		t.Error(err)
+		return
+	}
+	//invalid reply
+	conna, connb := net.Pipe()
+	go func() {
+		io.ReadFull(connb, make([]byte, 21))
+		connb.Write([]byte("xxxxxx"))
+	}()
+	if _, err := ClientLayer(context.Background(), conna, &LayerOption{Compress: "flate"}); err == nil {
+		t.Error(err)
+		return
+	}
+	//invalid hello
+	conna, connb = net.Pipe()
+	go connb.Write(make([]byte, 21))
+	if _, err := ServerLayer(context.Background(), conna, &LayerOption{}); err == nil {
+		t.Error(err)
+		return
+	}
+	//unknown flags
+	conna, connb = net.Pipe()
+	go ServerLayer(context.Background(), connb, &LayerOption{})
+	hello := append([]byte("XNL"), layerVersion, 0x80)
+	conna.Write(append(hello, make([]byte, layerSaltSize)...))
+	reply := make([]byte, 6)
+	if _, err := io.ReadFull(conna, reply); err != nil || reply[4] != layerStatusFlag {
+		t.Errorf("%v,%v", err, reply)
+		return
+	}
+	//timeout
+	conna, _ = net.Pipe()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := ClientLayer(ctx, conna, &LayerOption{Compress: "flate"}); err != context.DeadlineExceeded {
+		t.Error(err)
+		return
+	}
+	//bad frame
+	conn, err := runLayerEcho(&LayerOption{PSK: []byte("123")}, &LayerOption{PSK: []byte("123")})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	layered := conn.(*LayerConn)
+	layered.Raw.Write([]byte{0, 0, 0, 8, 1, 2, 3, 4})
+	if _, err = conn.Read(make([]byte, 8)); err == nil {
+		t.Error(err)
+		return
+	}
+	conn.Close()
+}
+
+func TestLayerKey(t *testing.T) {
+	//RFC 5869 test case 1
+	secret := bytes.Repeat([]byte{0x0b}, 22)
+	salt, _ := hex.DecodeString("000102030405060708090a0b0c")
+	info, _ := hex.DecodeString("f0f1f2f3f4f5f6f7f8f9")
+	if key := hex.EncodeToString(hkdf(secret, salt, info, 42)); key != "3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865" {
+		t.Error(key)
+		return
+	}
+	option := &LayerOption{PSK: []byte("123")}
+	clientSalt, serverSalt := bytes.Repeat([]byte{1}, layerSaltSize), bytes.Repeat([]byte{2}, layerSaltSize)
+	client := option.key("client", clientSalt, serverSalt)
+	if len(client) != 32 || bytes.Contains(client, option.PSK) ||
+		bytes.Equal(client, option.key("server", clientSalt, serverSalt)) ||
+		bytes.Equal(client, option.key("client", clientSalt, clientSalt)) ||
+		!bytes.Equal(client, option.key("client", clientSalt, serverSalt)) {
+		t.Error("key")
+		return
+	}
+}
+
+func TestSplitLayerURI(t *testing.T) {
+	target, option, err := SplitLayerURI("ws://127.0.0.1/ws?compress=flate&psk=123&timeout=5")
+	if err != nil || target != "ws://127.0.0.1/ws?timeout=5" || option.Compress != "flate" || string(option.PSK) != "123" {
+		t.Errorf("%v,%v,%v", target, option, err)
+		return
+	}
+	target, option, err = SplitLayerURI(":8080?psk=123")
+	if err != nil || target != ":8080" || option.Compress != "" || string(option.PSK) != "123" {
+		t.Errorf("%v,%v,%v", target, option, err)
+		return
+	}
+	target, option, err = SplitLayerURI("ws://127.0.0.1/ws?timeout=5")
+	if err != nil || target != "ws://127.0.0.1/ws?timeout=5" || option != nil {
+		t.Errorf("%v,%v,%v", target, option, err)
+		return
+	}
+	if _, _, err = SplitLayerURI("ws://127.0.0.1/ws?compress=xx"); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, _, err = SplitLayerURI("ws://127.0.0.1/ws?%zz"); err == nil {
+		t.Error(err)
+		return
+	}
+}
+
+func TestWebsocketDialerLayer(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	runWebsocketServer(listener, func(conn *websocket.Conn) {
+		if strings.Contains(conn.Request().URL.RawQuery, "psk") {
+			conn.Close()
+			return
+		}
+		conn.PayloadType = websocket.BinaryFrame
+		layered, err := ServerLayer(context.Background(), conn, &LayerOption{PSK: []byte("123")})
+		if err == nil {
+			io.Copy(layered, layered)
+			layered.Close()
+		}
+	})
+	remote := "ws://" + listener.Addr().String() + "/ws?compress=flate&psk=123"
+	dialer, _ := newTestReconnectDialer()
+	for _, d := range []Dialer{NewWebsocketDialer(), dialer} {
+		raw, err := d.Dial(remote)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf := make([]byte, 3)
+		raw.Write([]byte("abc"))
+		if _, err = io.ReadFull(raw, buf); err != nil || string(buf) != "abc" {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+		raw.Close()
+	}
+	if _, err := NewWebsocketDialer().Dial("ws://" + listener.Addr().String() + "/ws?psk=abc"); err != ErrLayerPSK {
+		t.Error(err)
+		return
+	}
+	if _, err := NewWebsocketDialer().Dial("ws://" + listener.Addr().String() + "/ws?compress=xx"); err == nil {
+		t.Error(err)
+		return
+	}
+	//transport by raw dialer
+	tcp, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer tcp.Close()
+	go func() {
+		for {
+			conn, err := tcp.Accept()
+			if err != nil {
+				break
+			}
+			go func() {
+				layered, err := ServerLayer(context.Background(), conn, &LayerOption{PSK: []byte("123")})
+				if err == nil {
+					io.Copy(layered, layered)
+					layered.Close()
+				}
+			}()
+		}
+	}()
+	conna, connb := net.Pipe()
+	go RawDialerF(net.Dial).Transport(connb, "tcp://"+tcp.Addr().String()+"?psk=123&compress=flate")
+	buf := make([]byte, 3)
+	conna.Write([]byte("abc"))
+	if _, err := io.ReadFull(conna, buf); err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", err, string(buf))
+		return
+	}
+	conna.Close()
+	conna, connb = net.Pipe()
+	if err := RawDialerF(net.Dial).Transport(connb, "tcp://"+tcp.Addr().String()+"?compress=xx"); err == nil {
+		t.Error(err)
+		return
+	}
+	conna.Close()
+}
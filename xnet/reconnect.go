@@ -0,0 +1,339 @@
+package xnet
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// ReconnectState is the state reported by ReconnectDialer
+type ReconnectState int
+
+const (
+	// ReconnectConnecting is state before dial attempt
+	ReconnectConnecting ReconnectState = iota
+	// ReconnectConnected is state after dial success
+	ReconnectConnected
+	// ReconnectRetrying is state after dial fail and will retry after backoff delay
+	ReconnectRetrying
+	// ReconnectFailed is state after all attempts fail
+	ReconnectFailed
+	// ReconnectDisconnected is state after established connection is broken or peer is not responding keepalive,
+	// it is redialed and resumed when remote is having resume=1
+	ReconnectDisconnected
+	// ReconnectResumed is state after broken connection is resumed on new connection
+	ReconnectResumed
+)
+
+func (r ReconnectState) String() string {
+	switch r {
+	case ReconnectConnecting:
+		return "connecting"
+	case ReconnectConnected:
+		return "connected"
+	case ReconnectRetrying:
+		return "retrying"
+	case ReconnectFailed:
+		return "failed"
+	case ReconnectDisconnected:
+		return "disconnected"
+	case ReconnectResumed:
+		return "resumed"
+	default:
+		return fmt.Sprintf("unknown(%d)", int(r))
+	}
+}
+
+// ErrKeepAliveTimeout is the error when peer is not responding keepalive ping
+var ErrKeepAliveTimeout = fmt.Errorf("keepalive timeout")
+
+// ReconnectDialer is Dialer and Transporter to dial websocket with retry by exponential backoff and jitter when dial fail,
+// the dialed connection is kept alive by ping and closed when nothing is received from peer in Timeout,
+// the pong is only received when connection is reading, so it should be always reading like Transport.
+//
+// When remote is having resume=1 like ws://host/ws?resume=1, the returned connection is ResumeConn, it is redialed with same backoff
+// after it is broken and the stream is resumed without losing data, so the server must accept it by ResumeServer.
+// The connection is closed when it is not resumed in MaxAttempts or the session is expired on server,
+// otherwise the broken connection is closed and the data in flight is lost, so the caller should dial again for next one.
+type ReconnectDialer struct {
+	*WebsocketDialer
+	MinDelay    time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+	MaxAttempts int
+	KeepAlive   time.Duration
+	Timeout     time.Duration
+	MaxBuffer   int
+	OnState     func(remote string, state ReconnectState, attempt int, err error)
+}
+
+// NewReconnectDialer will return new ReconnectDialer by new WebsocketDialer
+func NewReconnectDialer() (dialer *ReconnectDialer) {
+	dialer = &ReconnectDialer{
+		WebsocketDialer: NewWebsocketDialer(),
+		MinDelay:        500 * time.Millisecond,
+		MaxDelay:        30 * time.Second,
+		Jitter:          0.2,
+		MaxAttempts:     10,
+		KeepAlive:       15 * time.Second,
+		Timeout:         45 * time.Second,
+		MaxBuffer:       1024 * 1024,
+	}
+	return
+}
+
+func (r *ReconnectDialer) state(remote string, state ReconnectState, attempt int, err error) {
+	if r.OnState != nil {
+		r.OnState(remote, state, attempt, err)
+	}
+}
+
+// Backoff will return the delay before next attempt, it is doubled by attempt from MinDelay to MaxDelay and randomized by Jitter
+func (r *ReconnectDialer) Backoff(attempt int) (delay time.Duration) {
+	delay = r.MinDelay
+	for i := 1; i < attempt && delay < r.MaxDelay; i++ {
+		delay *= 2
+	}
+	if r.MaxDelay > 0 && delay > r.MaxDelay {
+		delay = r.MaxDelay
+	}
+	if r.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + r.Jitter*(rand.Float64()*2-1)))
+	}
+	return
+}
+
+// Dial will dial remote by websocket and retry when dial fail
+func (r *ReconnectDialer) Dial(remote string) (raw io.ReadWriteCloser, err error) {
+	raw, err = r.DialContext(context.Background(), remote)
+	return
+}
+
+// DialContext will dial remote by websocket and retry when dial fail until MaxAttempts is reached or ctx is done,
+// the ResumeConn is returned when remote is having resume=1
+func (r *ReconnectDialer) DialContext(ctx context.Context, remote string) (raw io.ReadWriteCloser, err error) {
+	if IsResumeURI(remote) {
+		var conn *ResumeConn
+		if conn, err = r.dialResume(ctx, remote); err == nil {
+			raw = conn
+		}
+	} else {
+		raw, err = r.dialRetry(ctx, remote, nil)
+	}
+	return
+}
+
+// dialRetry will dial remote and run handshake on it with retry, it is not retried when handshake fail by ErrResumeNotFound
+func (r *ReconnectDialer) dialRetry(ctx context.Context, remote string, handshake func(raw io.ReadWriteCloser) error) (raw io.ReadWriteCloser, err error) {
+	for attempt := 1; ; attempt++ {
+		r.state(remote, ReconnectConnecting, attempt, nil)
+		raw, err = r.dialOnce(ctx, remote)
+		if err == nil && handshake != nil {
+			if err = handshake(raw); err != nil {
+				raw = nil
+			}
+		}
+		if err == nil {
+			r.state(remote, ReconnectConnected, attempt, nil)
+			return
+		}
+		if ctx.Err() != nil || err == ErrResumeNotFound || (r.MaxAttempts > 0 && attempt >= r.MaxAttempts) {
+			r.state(remote, ReconnectFailed, attempt, err)
+			return
+		}
+		r.state(remote, ReconnectRetrying, attempt, err)
+		select {
+		case <-ctx.Done():
+			err = ctx.Err()
+			r.state(remote, ReconnectFailed, attempt, err)
+			return
+		case <-time.After(r.Backoff(attempt)):
+		}
+	}
+}
+
+func (r *ReconnectDialer) dialResume(ctx context.Context, remote string) (conn *ResumeConn, err error) {
+	conn, err = NewResumeClient(r.MaxBuffer)
+	if err != nil {
+		return
+	}
+	raw, err := r.dialRetry(ctx, remote, r.resumeHandshake(ctx, remote, conn))
+	if err != nil {
+		return
+	}
+	go r.loopResume(conn, remote, raw)
+	return
+}
+
+func (r *ReconnectDialer) resumeHandshake(ctx context.Context, remote string, conn *ResumeConn) func(raw io.ReadWriteCloser) error {
+	return func(raw io.ReadWriteCloser) error {
+		resumeCtx, cancel := r.dialContext(ctx, remote)
+		defer cancel()
+		return ClientResume(resumeCtx, raw, conn)
+	}
+}
+
+// loopResume will serve raw of conn and redial to resume when it is broken until conn is closed
+func (r *ReconnectDialer) loopResume(conn *ResumeConn, remote string, raw io.ReadWriteCloser) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-conn.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	handshake := r.resumeHandshake(ctx, remote, conn)
+	for {
+		err := conn.serve(raw)
+		if conn.isDone() {
+			return
+		}
+		r.state(remote, ReconnectDisconnected, 0, err)
+		raw, err = r.dialRetry(ctx, remote, handshake)
+		if err != nil {
+			conn.closeBy(err)
+			return
+		}
+		r.state(remote, ReconnectResumed, 0, nil)
+	}
+}
+
+func (r *ReconnectDialer) dialOnce(ctx context.Context, remote string) (raw io.ReadWriteCloser, err error) {
+	remote, option, err := SplitLayerURI(remote)
+	if err != nil {
+		return
+	}
+	ctx, cancel := r.dialContext(ctx, remote)
+	defer cancel()
+	var activity *activityConn
+	dialer := *r.WebsocketDialer
+	dialer.Dialer = RawContextDialerF(func(ctx context.Context, network, address string) (conn net.Conn, err error) {
+		conn, err = DialRawContext(ctx, r.WebsocketDialer.Dialer, network, address)
+		if err == nil {
+			activity = newActivityConn(conn)
+			conn = activity
+		}
+		return
+	})
+	raw, err = dialer.dialWebsocket(ctx, remote)
+	if err != nil {
+		return
+	}
+	if conn, ok := raw.(*websocket.Conn); ok && activity != nil && r.KeepAlive > 0 {
+		keep := &keepaliveConn{
+			Conn:     conn,
+			activity: activity,
+			exiter:   make(chan int),
+		}
+		go keep.loopPing(r, remote)
+		raw = keep
+	}
+	raw, err = ClientLayer(ctx, raw, option)
+	return
+}
+
+// Transport will dial remote with retry and copy data between conn and remote, the conn is closed when remote is broken
+func (r *ReconnectDialer) Transport(conn io.ReadWriteCloser, remote string) (err error) {
+	raw, err := r.Dial(remote)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	aerr, berr := transportCopy(conn, raw)
+	if aerr != nil {
+		err = aerr
+	} else {
+		err = berr
+	}
+	return
+}
+
+// activityConn is net.Conn to record the last time of data received
+type activityConn struct {
+	net.Conn
+	last int64
+}
+
+func newActivityConn(conn net.Conn) (activity *activityConn) {
+	activity = &activityConn{Conn: conn, last: time.Now().UnixNano()}
+	return
+}
+
+func (a *activityConn) Read(p []byte) (n int, err error) {
+	n, err = a.Conn.Read(p)
+	if n > 0 {
+		atomic.StoreInt64(&a.last, time.Now().UnixNano())
+	}
+	return
+}
+
+func (a *activityConn) Idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&a.last)))
+}
+
+// keepaliveConn is websocket.Conn wrapper to send ping frame between write and close when peer is not responding
+type keepaliveConn struct {
+	*websocket.Conn
+	activity *activityConn
+	writeLck sync.Mutex
+	exiter   chan int
+	closer   sync.Once
+}
+
+func (k *keepaliveConn) Write(p []byte) (n int, err error) {
+	k.writeLck.Lock()
+	n, err = k.Conn.Write(p)
+	k.writeLck.Unlock()
+	return
+}
+
+func (k *keepaliveConn) ping(timeout time.Duration) (err error) {
+	k.writeLck.Lock()
+	defer k.writeLck.Unlock()
+	payloadType := k.PayloadType
+	k.PayloadType = websocket.PingFrame
+	k.Conn.SetWriteDeadline(time.Now().Add(timeout))
+	_, err = k.Conn.Write([]byte("ping"))
+	k.Conn.SetWriteDeadline(time.Time{})
+	k.PayloadType = payloadType
+	return
+}
+
+func (k *keepaliveConn) loopPing(dialer *ReconnectDialer, remote string) {
+	ticker := time.NewTicker(dialer.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-k.exiter:
+			return
+		case <-ticker.C:
+		}
+		if dialer.Timeout > 0 && k.activity.Idle() > dialer.Timeout {
+			dialer.state(remote, ReconnectDisconnected, 0, ErrKeepAliveTimeout)
+			k.Close()
+			return
+		}
+		if err := k.ping(dialer.KeepAlive); err != nil {
+			dialer.state(remote, ReconnectDisconnected, 0, err)
+			k.Close()
+			return
+		}
+	}
+}
+
+func (k *keepaliveConn) Close() (err error) {
+	k.closer.Do(func() {
+		close(k.exiter)
+	})
+	err = k.Conn.Close()
+	return
+}
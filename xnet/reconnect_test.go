@@ -0,0 +1,157 @@
+package xnet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type stateRecorder struct {
+	states []ReconnectState
+	lock   sync.Mutex
+}
+
+func (s *stateRecorder) OnState(remote string, state ReconnectState, attempt int, err error) {
+	s.lock.Lock()
+	s.states = append(s.states, state)
+	s.lock.Unlock()
+}
+
+func (s *stateRecorder) Has(state ReconnectState) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, having := range s.states {
+		if having == state {
+			return true
+		}
+	}
+	return false
+}
+
+func runWebsocketServer(listener net.Listener, handler func(conn *websocket.Conn)) {
+	go http.Serve(listener, websocket.Handler(handler))
+}
+
+func newTestReconnectDialer() (dialer *ReconnectDialer, recorder *stateRecorder) {
+	recorder = &stateRecorder{}
+	dialer = NewReconnectDialer()
+	dialer.MinDelay = 20 * time.Millisecond
+	dialer.MaxDelay = 100 * time.Millisecond
+	dialer.MaxAttempts = 5
+	dialer.KeepAlive = 30 * time.Millisecond
+	dialer.Timeout = 100 * time.Millisecond
+	dialer.OnState = recorder.OnState
+	return
+}
+
+func TestReconnectDialer(t *testing.T) {
+	reserved, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := reserved.Addr().String()
+	reserved.Close()
+	dialer, recorder := newTestReconnectDialer()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		listener, err := net.Listen("tcp", address)
+		if err != nil {
+			panic(err)
+		}
+		runWebsocketServer(listener, func(conn *websocket.Conn) { io.Copy(conn, conn) })
+	}()
+	raw, err := dialer.Dial("ws://" + address)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer raw.Close()
+	if !recorder.Has(ReconnectRetrying) || !recorder.Has(ReconnectConnected) {
+		t.Error(recorder.states)
+		return
+	}
+	//keepalive by pong when reading
+	readed := make(chan string, 1)
+	go func() {
+		buf := make([]byte, 3)
+		io.ReadFull(raw, buf)
+		readed <- string(buf)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	fmt.Fprintf(raw, "abc")
+	if data := <-readed; data != "abc" || recorder.Has(ReconnectDisconnected) {
+		t.Errorf("%v,%v", data, recorder.states)
+		return
+	}
+	//transport
+	buf := make([]byte, 3)
+	conna, connb := net.Pipe()
+	go dialer.Transport(connb, "ws://"+address)
+	fmt.Fprintf(conna, "abc")
+	if _, err = io.ReadFull(conna, buf); err != nil || string(buf) != "abc" {
+		t.Errorf("%v,%v", err, string(buf))
+		return
+	}
+	conna.Close()
+}
+
+func TestReconnectDialerFail(t *testing.T) {
+	reserved, _ := net.Listen("tcp", "127.0.0.1:0")
+	address := reserved.Addr().String()
+	reserved.Close()
+	dialer, recorder := newTestReconnectDialer()
+	dialer.MaxAttempts = 3
+	attempts := 0
+	dialer.OnState = func(remote string, state ReconnectState, attempt int, err error) {
+		attempts = attempt
+		recorder.OnState(remote, state, attempt, err)
+	}
+	if _, err := dialer.Dial("ws://" + address); err == nil || attempts != 3 || !recorder.Has(ReconnectFailed) {
+		t.Errorf("%v,%v,%v", err, attempts, recorder.states)
+		return
+	}
+	conna, connb := net.Pipe()
+	if err := dialer.Transport(connb, "ws://"+address); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err := conna.Write([]byte("abc")); err == nil {
+		t.Error(err)
+		return
+	}
+	//backoff
+	dialer.Jitter = 0
+	if dialer.Backoff(1) != 20*time.Millisecond || dialer.Backoff(2) != 40*time.Millisecond || dialer.Backoff(10) != 100*time.Millisecond {
+		t.Errorf("%v,%v,%v", dialer.Backoff(1), dialer.Backoff(2), dialer.Backoff(10))
+		return
+	}
+	for _, state := range []ReconnectState{ReconnectConnecting, ReconnectConnected, ReconnectRetrying, ReconnectFailed, ReconnectDisconnected, ReconnectResumed, 100} {
+		if len(state.String()) < 1 {
+			t.Error(state)
+			return
+		}
+	}
+}
+
+func TestReconnectDialerKeepAlive(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	hold := make(chan int)
+	defer close(hold)
+	runWebsocketServer(listener, func(conn *websocket.Conn) { <-hold })
+	dialer, recorder := newTestReconnectDialer()
+	raw, err := dialer.Dial("ws://" + listener.Addr().String())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer raw.Close()
+	begin := time.Now()
+	if _, err = raw.Read(make([]byte, 1)); err == nil || time.Since(begin) > time.Second || !recorder.Has(ReconnectDisconnected) {
+		t.Errorf("%v,%v", err, recorder.states)
+		return
+	}
+}
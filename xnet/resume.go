@@ -0,0 +1,599 @@
+package xnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	resumeVersion        = 1
+	resumeIDSize         = 16
+	resumeFrameSize      = 16 * 1024
+	resumeFrameData      = 0x01
+	resumeFrameAck       = 0x02
+	resumeFrameClose     = 0x03
+	resumeFlagNew        = 0x00
+	resumeFlagResume     = 0x01
+	resumeStatusOK       = 0x00
+	resumeStatusNotFound = 0x01
+)
+
+var resumeMagic = []byte("XNR")
+
+// ErrResumeNotFound is the error when the resumed session is not found on server, it is expired or the server is restarted
+var ErrResumeNotFound = fmt.Errorf("resume session is not found")
+
+// ErrResumeTimeout is the error when the broken session is not resumed by client in timeout
+var ErrResumeTimeout = fmt.Errorf("resume timeout")
+
+// IsResumeURI will return whether resume=1 is in query of uri, the query is kept and sent to server,
+// so the server can accept it by ResumeServer
+func IsResumeURI(uri string) bool {
+	queryPos := strings.Index(uri, "?")
+	if queryPos < 0 {
+		return false
+	}
+	query, err := url.ParseQuery(uri[queryPos+1:])
+	return err == nil && query.Get("resume") == "1"
+}
+
+// ResumeConn is io.ReadWriteCloser to keep the stream across broken transport, the written data is kept until it is acked by peer
+// and sent again on the new transport, so nothing is lost or duplicated when the transport is resumed in time.
+// The Write is blocked when MaxBuffer of written data is not consumed by peer.
+type ResumeConn struct {
+	ID         string
+	MaxBuffer  int
+	raw        io.ReadWriteCloser
+	served     chan int
+	attached   int
+	timeout    time.Duration
+	timer      *time.Timer
+	sent       []byte
+	sentStart  uint64
+	written    uint64
+	acked      uint64
+	received   uint64
+	consumed   uint64
+	ackSent    uint64
+	queue      []byte
+	closed     bool
+	peerClosed bool
+	closeErr   error
+	onClose    func(conn *ResumeConn)
+	done       chan int
+	writeLck   sync.Mutex
+	rawLck     sync.Mutex
+	lock       sync.Mutex
+	cond       *sync.Cond
+}
+
+func newResumeConn(id string, maxBuffer int, timeout time.Duration) (conn *ResumeConn) {
+	if maxBuffer < resumeFrameSize {
+		maxBuffer = resumeFrameSize
+	}
+	conn = &ResumeConn{
+		ID:        id,
+		MaxBuffer: maxBuffer,
+		timeout:   timeout,
+		done:      make(chan int),
+	}
+	conn.cond = sync.NewCond(&conn.lock)
+	return
+}
+
+func (c *ResumeConn) offsets() (received, consumed uint64) {
+	c.lock.Lock()
+	received, consumed = c.received, c.consumed
+	c.lock.Unlock()
+	return
+}
+
+func (c *ResumeConn) isDone() bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.closed || c.peerClosed
+}
+
+// attach will use raw as transport and send the data not received by peer again in background, so the caller can start serve to read
+// and the written data is sent after it, the conn is closed when the peer offset is invalid
+func (c *ResumeConn) attach(raw io.ReadWriteCloser, peerReceived, peerConsumed uint64) (err error) {
+	c.rawLck.Lock()
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		c.rawLck.Unlock()
+		err = net.ErrClosed
+		return
+	}
+	if peerReceived < c.sentStart || peerReceived > c.written || peerConsumed > peerReceived {
+		err = fmt.Errorf("resume offset %v is not in %v-%v", peerReceived, c.sentStart, c.written)
+		c.lock.Unlock()
+		c.rawLck.Unlock()
+		c.closeBy(err)
+		return
+	}
+	if peerConsumed > c.acked {
+		c.acked = peerConsumed
+	}
+	c.sent = c.sent[peerReceived-c.sentStart:]
+	c.sentStart = peerReceived
+	c.raw = raw
+	c.served = make(chan int)
+	c.attached++
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	pending := append([]byte{}, c.sent...)
+	c.ackSent = c.consumed
+	ack := ackFrame(c.consumed)
+	c.cond.Broadcast()
+	c.lock.Unlock()
+	go func() {
+		defer c.rawLck.Unlock()
+		var err error
+		for len(pending) > 0 && err == nil {
+			size := len(pending)
+			if size > resumeFrameSize {
+				size = resumeFrameSize
+			}
+			_, err = raw.Write(dataFrame(pending[:size]))
+			pending = pending[size:]
+		}
+		if err == nil {
+			_, err = raw.Write(ack)
+		}
+		if err != nil { //broken again, it is detected by serve
+			raw.Close()
+		}
+	}()
+	return
+}
+
+// takeover will close the attached transport and wait it done, so the received offset is not changed after
+func (c *ResumeConn) takeover() {
+	c.lock.Lock()
+	raw, served := c.raw, c.served
+	c.lock.Unlock()
+	if raw != nil {
+		raw.Close()
+		<-served
+	}
+}
+
+// serve will read frame from attached raw until it is broken or peer is closed
+func (c *ResumeConn) serve(raw io.ReadWriteCloser) (err error) {
+	c.lock.Lock()
+	served, attached := c.served, c.attached
+	c.lock.Unlock()
+	defer func() {
+		raw.Close()
+		c.detach(raw, attached)
+		close(served)
+	}()
+	header := make([]byte, 9)
+	for {
+		if _, err = io.ReadFull(raw, header[:1]); err != nil {
+			return
+		}
+		switch header[0] {
+		case resumeFrameData:
+			if _, err = io.ReadFull(raw, header[1:5]); err != nil {
+				return
+			}
+			length := binary.BigEndian.Uint32(header[1:5])
+			if length > resumeFrameSize {
+				err = fmt.Errorf("resume frame size %v is too large", length)
+				return
+			}
+			payload := make([]byte, length)
+			if _, err = io.ReadFull(raw, payload); err != nil {
+				return
+			}
+			c.lock.Lock()
+			c.queue = append(c.queue, payload...)
+			c.received += uint64(length)
+			c.cond.Broadcast()
+			c.lock.Unlock()
+		case resumeFrameAck:
+			if _, err = io.ReadFull(raw, header[1:9]); err != nil {
+				return
+			}
+			acked := binary.BigEndian.Uint64(header[1:9])
+			c.lock.Lock()
+			if acked > c.acked && acked <= c.written {
+				c.acked = acked
+				if acked > c.sentStart {
+					c.sent = c.sent[acked-c.sentStart:]
+					c.sentStart = acked
+				}
+				c.cond.Broadcast()
+			}
+			c.lock.Unlock()
+		case resumeFrameClose:
+			c.lock.Lock()
+			c.peerClosed = true
+			c.cond.Broadcast()
+			c.lock.Unlock()
+			err = io.EOF
+			return
+		default:
+			err = fmt.Errorf("invalid resume frame %x", header[0])
+			return
+		}
+	}
+}
+
+// detach will release raw and start timer to close when it is not resumed in timeout
+func (c *ResumeConn) detach(raw io.ReadWriteCloser, attached int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.raw == raw {
+		c.raw = nil
+	}
+	if c.attached != attached || c.closed || c.peerClosed || c.timeout <= 0 {
+		return
+	}
+	c.timer = time.AfterFunc(c.timeout, func() {
+		c.lock.Lock()
+		expired := c.attached == attached && c.raw == nil
+		c.lock.Unlock()
+		if expired {
+			c.closeBy(ErrResumeTimeout)
+		}
+	})
+}
+
+func (c *ResumeConn) writeFrame(raw io.ReadWriteCloser, frame []byte) {
+	c.rawLck.Lock()
+	_, err := raw.Write(frame)
+	c.rawLck.Unlock()
+	if err != nil { //broken, it is detected by serve
+		raw.Close()
+	}
+}
+
+func dataFrame(payload []byte) (frame []byte) {
+	frame = make([]byte, 5+len(payload))
+	frame[0] = resumeFrameData
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+	return
+}
+
+func ackFrame(consumed uint64) (frame []byte) {
+	frame = make([]byte, 9)
+	frame[0] = resumeFrameAck
+	binary.BigEndian.PutUint64(frame[1:], consumed)
+	return
+}
+
+// Read will read data received from peer, it returns io.EOF when peer is closed
+func (c *ResumeConn) Read(p []byte) (n int, err error) {
+	c.lock.Lock()
+	for len(c.queue) < 1 && !c.closed && !c.peerClosed {
+		c.cond.Wait()
+	}
+	if c.closed {
+		err = c.closeErr
+		c.lock.Unlock()
+		return
+	}
+	if len(c.queue) < 1 {
+		err = io.EOF
+		c.lock.Unlock()
+		return
+	}
+	n = copy(p, c.queue)
+	c.queue = c.queue[n:]
+	if len(c.queue) < 1 {
+		c.queue = nil
+	}
+	c.consumed += uint64(n)
+	var raw io.ReadWriteCloser
+	var ack []byte
+	if c.raw != nil && (len(c.queue) < 1 || c.consumed-c.ackSent >= uint64(c.MaxBuffer/4)) {
+		raw, ack = c.raw, ackFrame(c.consumed)
+		c.ackSent = c.consumed
+	}
+	c.lock.Unlock()
+	if raw != nil {
+		c.writeFrame(raw, ack)
+	}
+	return
+}
+
+// Write will keep data until it is acked and send it when transport is attached, it is blocked when MaxBuffer of data is not acked
+func (c *ResumeConn) Write(p []byte) (n int, err error) {
+	c.writeLck.Lock()
+	defer c.writeLck.Unlock()
+	for len(p) > 0 {
+		c.lock.Lock()
+		for !c.closed && !c.peerClosed && c.written-c.acked >= uint64(c.MaxBuffer) {
+			c.cond.Wait()
+		}
+		if c.closed || c.peerClosed {
+			err = c.closeErr
+			if err == nil {
+				err = io.ErrClosedPipe
+			}
+			c.lock.Unlock()
+			return
+		}
+		size := uint64(len(p))
+		if size > resumeFrameSize {
+			size = resumeFrameSize
+		}
+		if free := uint64(c.MaxBuffer) - (c.written - c.acked); size > free {
+			size = free
+		}
+		c.sent = append(c.sent, p[:size]...)
+		c.written += size
+		raw := c.raw
+		c.lock.Unlock()
+		if raw != nil {
+			c.writeFrame(raw, dataFrame(p[:size]))
+		}
+		n += int(size)
+		p = p[size:]
+	}
+	return
+}
+
+// Close will notify peer to close and close the transport
+func (c *ResumeConn) Close() (err error) {
+	c.closeBy(nil)
+	return
+}
+
+func (c *ResumeConn) closeBy(reason error) {
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return
+	}
+	c.closed = true
+	c.closeErr = reason
+	if c.closeErr == nil {
+		c.closeErr = net.ErrClosed
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	raw := c.raw
+	c.cond.Broadcast()
+	c.lock.Unlock()
+	if raw != nil {
+		if reason == nil {
+			c.writeFrame(raw, []byte{resumeFrameClose})
+		}
+		raw.Close()
+	}
+	close(c.done)
+	if c.onClose != nil {
+		c.onClose(c)
+	}
+}
+
+func (c *ResumeConn) String() string {
+	return "resume(" + c.ID + ")"
+}
+
+func writeResumeHello(raw io.Writer, flag byte, id []byte, received, consumed uint64) (err error) {
+	hello := make([]byte, len(resumeMagic)+2+resumeIDSize+16)
+	copy(hello, resumeMagic)
+	offset := len(resumeMagic)
+	hello[offset], hello[offset+1] = resumeVersion, flag
+	copy(hello[offset+2:], id)
+	binary.BigEndian.PutUint64(hello[offset+2+resumeIDSize:], received)
+	binary.BigEndian.PutUint64(hello[offset+2+resumeIDSize+8:], consumed)
+	_, err = raw.Write(hello)
+	return
+}
+
+func readResumeHello(raw io.Reader) (flag byte, id []byte, received, consumed uint64, err error) {
+	hello := make([]byte, len(resumeMagic)+2+resumeIDSize+16)
+	if _, err = io.ReadFull(raw, hello); err != nil {
+		return
+	}
+	if !bytes.Equal(hello[:len(resumeMagic)], resumeMagic) || hello[len(resumeMagic)] != resumeVersion {
+		err = fmt.Errorf("invalid resume hello")
+		return
+	}
+	flag = hello[len(resumeMagic)+1]
+	offset := len(resumeMagic) + 2
+	id = hello[offset : offset+resumeIDSize]
+	received = binary.BigEndian.Uint64(hello[offset+resumeIDSize:])
+	consumed = binary.BigEndian.Uint64(hello[offset+resumeIDSize+8:])
+	return
+}
+
+func writeResumeReply(raw io.Writer, status byte, received, consumed uint64) (err error) {
+	reply := make([]byte, len(resumeMagic)+2+16)
+	copy(reply, resumeMagic)
+	offset := len(resumeMagic)
+	reply[offset], reply[offset+1] = resumeVersion, status
+	binary.BigEndian.PutUint64(reply[offset+2:], received)
+	binary.BigEndian.PutUint64(reply[offset+10:], consumed)
+	_, err = raw.Write(reply)
+	return
+}
+
+func readResumeReply(raw io.Reader) (received, consumed uint64, err error) {
+	reply := make([]byte, len(resumeMagic)+2+16)
+	if _, err = io.ReadFull(raw, reply); err != nil {
+		return
+	}
+	if !bytes.Equal(reply[:len(resumeMagic)], resumeMagic) || reply[len(resumeMagic)] != resumeVersion {
+		err = fmt.Errorf("invalid resume reply")
+		return
+	}
+	switch reply[len(resumeMagic)+1] {
+	case resumeStatusOK:
+	case resumeStatusNotFound:
+		err = ErrResumeNotFound
+		return
+	default:
+		err = fmt.Errorf("resume status %x is not supported", reply[len(resumeMagic)+1])
+		return
+	}
+	offset := len(resumeMagic) + 2
+	received = binary.BigEndian.Uint64(reply[offset:])
+	consumed = binary.BigEndian.Uint64(reply[offset+8:])
+	return
+}
+
+// NewResumeClient will return new client side ResumeConn, the transport is attached by ClientResume
+func NewResumeClient(maxBuffer int) (conn *ResumeConn, err error) {
+	id := make([]byte, resumeIDSize)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+	conn = newResumeConn(hex.EncodeToString(id), maxBuffer, 0)
+	return
+}
+
+// ClientResume will send resume handshake on raw and attach it to conn, the session is created on server when it is first attached,
+// the raw is closed when handshake fail or ctx is done
+func ClientResume(ctx context.Context, raw io.ReadWriteCloser, conn *ResumeConn) (err error) {
+	stop := CloseOnDone(ctx, raw)
+	err = clientResume(raw, conn)
+	if xerr := stop(); xerr != nil {
+		err = xerr
+	}
+	if err != nil {
+		raw.Close()
+	}
+	return
+}
+
+func clientResume(raw io.ReadWriteCloser, conn *ResumeConn) (err error) {
+	id, err := hex.DecodeString(conn.ID)
+	if err != nil || len(id) != resumeIDSize {
+		err = fmt.Errorf("invalid resume id %v", conn.ID)
+		return
+	}
+	conn.lock.Lock()
+	flag := byte(resumeFlagNew)
+	if conn.attached > 0 {
+		flag = resumeFlagResume
+	}
+	conn.lock.Unlock()
+	received, consumed := conn.offsets()
+	if err = writeResumeHello(raw, flag, id, received, consumed); err != nil {
+		return
+	}
+	peerReceived, peerConsumed, err := readResumeReply(raw)
+	if err != nil {
+		return
+	}
+	err = conn.attach(raw, peerReceived, peerConsumed)
+	return
+}
+
+// ResumeServer is the server side of ResumeConn, the new session is handled by handler of Serve and the resumed transport is attached to the waiting session.
+// The session is closed when it is not resumed by client in Timeout after transport is broken.
+type ResumeServer struct {
+	Timeout   time.Duration
+	MaxBuffer int
+	sessions  map[string]*ResumeConn
+	lock      sync.RWMutex
+}
+
+// NewResumeServer will return new ResumeServer
+func NewResumeServer() (server *ResumeServer) {
+	server = &ResumeServer{
+		Timeout:   time.Minute,
+		MaxBuffer: 1024 * 1024,
+		sessions:  map[string]*ResumeConn{},
+	}
+	return
+}
+
+// Serve will receive resume handshake on raw and serve it until it is broken, the new session is handled by handler in background
+// and closed after handler is returned. It is blocked until raw is broken, so it can be called in websocket handler directly.
+// The raw is closed when handshake fail or ctx is done before handshake is done
+func (s *ResumeServer) Serve(ctx context.Context, raw io.ReadWriteCloser, handler func(conn *ResumeConn)) (err error) {
+	stop := CloseOnDone(ctx, raw)
+	conn, created, err := s.accept(raw)
+	if xerr := stop(); xerr != nil && err == nil {
+		err = xerr
+	}
+	if err != nil {
+		raw.Close()
+		if created {
+			conn.Close()
+		}
+		return
+	}
+	if created {
+		go func() {
+			handler(conn)
+			conn.Close()
+		}()
+	}
+	err = conn.serve(raw)
+	return
+}
+
+func (s *ResumeServer) accept(raw io.ReadWriteCloser) (conn *ResumeConn, created bool, err error) {
+	flag, id, peerReceived, peerConsumed, err := readResumeHello(raw)
+	if err != nil {
+		return
+	}
+	if flag != resumeFlagNew && flag != resumeFlagResume {
+		err = fmt.Errorf("invalid resume flag %x", flag)
+		return
+	}
+	key := hex.EncodeToString(id)
+	s.lock.Lock()
+	conn = s.sessions[key]
+	if conn == nil && flag == resumeFlagNew {
+		conn = newResumeConn(key, s.MaxBuffer, s.Timeout)
+		conn.onClose = s.remove
+		s.sessions[key] = conn
+		created = true
+	}
+	s.lock.Unlock()
+	if conn == nil {
+		writeResumeReply(raw, resumeStatusNotFound, 0, 0)
+		err = ErrResumeNotFound
+		return
+	}
+	if !created { //the new session may be sent again when reply is lost
+		conn.takeover()
+	}
+	received, consumed := conn.offsets()
+	if err = writeResumeReply(raw, resumeStatusOK, received, consumed); err != nil {
+		return
+	}
+	err = conn.attach(raw, peerReceived, peerConsumed)
+	return
+}
+
+func (s *ResumeServer) remove(conn *ResumeConn) {
+	s.lock.Lock()
+	if s.sessions[conn.ID] == conn {
+		delete(s.sessions, conn.ID)
+	}
+	s.lock.Unlock()
+}
+
+// Size will return the count of session, the broken session waiting resume is included
+func (s *ResumeServer) Size() (size int) {
+	s.lock.RLock()
+	size = len(s.sessions)
+	s.lock.RUnlock()
+	return
+}
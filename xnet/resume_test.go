@@ -0,0 +1,201 @@
+package xnet
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+type resumeTestServer struct {
+	*ResumeServer
+	raws []*websocket.Conn
+	lock sync.Mutex
+}
+
+func (r *resumeTestServer) serve(conn *websocket.Conn) {
+	r.lock.Lock()
+	r.raws = append(r.raws, conn)
+	r.lock.Unlock()
+	r.Serve(context.Background(), conn, func(conn *ResumeConn) { io.Copy(conn, conn) })
+}
+
+// Break will close all transport on server
+func (r *resumeTestServer) Break() {
+	r.lock.Lock()
+	for _, raw := range r.raws {
+		raw.Close()
+	}
+	r.raws = nil
+	r.lock.Unlock()
+}
+
+func TestResumeDialer(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer listener.Close()
+	server := &resumeTestServer{ResumeServer: NewResumeServer()}
+	server.Timeout = 500 * time.Millisecond
+	runWebsocketServer(listener, server.serve)
+	dialer, recorder := newTestReconnectDialer()
+	{ //resume on broken
+		raw, err := dialer.Dial("ws://" + listener.Addr().String() + "/ws?resume=1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		buf := make([]byte, 3)
+		raw.Write([]byte("abc"))
+		if _, err = io.ReadFull(raw, buf); err != nil || string(buf) != "abc" {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+		server.Break()
+		raw.Write([]byte("def"))
+		if _, err = io.ReadFull(raw, buf); err != nil || string(buf) != "def" {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+		if !recorder.Has(ReconnectDisconnected) || !recorder.Has(ReconnectResumed) {
+			t.Error(recorder.states)
+			return
+		}
+		//data in flight is not lost
+		data := make([]byte, 512*1024)
+		rand.Read(data)
+		go func() {
+			for i := 0; i < len(data); i += 1024 {
+				raw.Write(data[i : i+1024])
+				if i%(128*1024) == 0 {
+					server.Break()
+				}
+			}
+		}()
+		received := make([]byte, len(data))
+		if _, err = io.ReadFull(raw, received); err != nil || !bytes.Equal(data, received) {
+			t.Error(err)
+			return
+		}
+		raw.Close()
+		for i := 0; i < 100 && server.Size() > 0; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if server.Size() != 0 {
+			t.Error(server.Size())
+			return
+		}
+	}
+	{ //session is not found
+		raw, err := dialer.Dial("ws://" + listener.Addr().String() + "/ws?resume=1")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		server.ResumeServer.lock.Lock()
+		server.sessions = map[string]*ResumeConn{} //server is restarted
+		server.ResumeServer.lock.Unlock()
+		server.Break()
+		if _, err = raw.Read(make([]byte, 1)); err != ErrResumeNotFound || !recorder.Has(ReconnectFailed) {
+			t.Errorf("%v,%v", err, recorder.states)
+			return
+		}
+		if _, err = raw.Write([]byte("abc")); err != ErrResumeNotFound {
+			t.Error(err)
+			return
+		}
+		raw.Close()
+	}
+}
+
+func TestResumeServer(t *testing.T) {
+	server := NewResumeServer()
+	server.Timeout = 50 * time.Millisecond
+	accepted := make(chan error, 1)
+	handler := func(conn *ResumeConn) {
+		_, err := conn.Read(make([]byte, 1))
+		accepted <- err
+	}
+	{ //expired when not resumed
+		client, _ := NewResumeClient(0)
+		conna, connb := net.Pipe()
+		go server.Serve(context.Background(), connb, handler)
+		if err := ClientResume(context.Background(), conna, client); err != nil || server.Size() != 1 {
+			t.Errorf("%v,%v", err, server.Size())
+			return
+		}
+		conna.Close()
+		if err := <-accepted; err != ErrResumeTimeout || server.Size() != 0 {
+			t.Errorf("%v,%v", err, server.Size())
+			return
+		}
+		client.Close()
+	}
+	{ //layer
+		client, _ := NewResumeClient(0)
+		conna, connb := net.Pipe()
+		option := &LayerOption{Compress: "flate", PSK: []byte("123")}
+		go func() {
+			layered, err := ServerLayer(context.Background(), connb, option)
+			if err == nil {
+				server.Serve(context.Background(), layered, func(conn *ResumeConn) { io.Copy(conn, conn) })
+			}
+		}()
+		layered, err := ClientLayer(context.Background(), conna, option)
+		if err == nil {
+			err = ClientResume(context.Background(), layered, client)
+		}
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		go client.serve(layered)
+		buf := make([]byte, 3)
+		client.Write([]byte("abc"))
+		if _, err = io.ReadFull(client, buf); err != nil || string(buf) != "abc" {
+			t.Errorf("%v,%v", err, string(buf))
+			return
+		}
+		client.Close()
+	}
+	{ //error
+		conna, connb := net.Pipe()
+		go conna.Write([]byte("XNR\x01\x05" + string(make([]byte, resumeIDSize+16))))
+		if err := server.Serve(context.Background(), connb, handler); err == nil {
+			t.Error(err)
+			return
+		}
+		conna, connb = net.Pipe()
+		go conna.Write([]byte("xxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxxx"))
+		if err := server.Serve(context.Background(), connb, handler); err == nil {
+			t.Error(err)
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, connb = net.Pipe()
+		if err := server.Serve(ctx, connb, handler); err == nil {
+			t.Error(err)
+			return
+		}
+		client, _ := NewResumeClient(0)
+		client.ID = "xx"
+		conna, _ = net.Pipe()
+		if err := ClientResume(context.Background(), conna, client); err == nil {
+			t.Error(err)
+			return
+		}
+		if IsResumeURI("ws://127.0.0.1") || IsResumeURI("ws://127.0.0.1?%zz") || !IsResumeURI("ws://127.0.0.1?resume=1") {
+			t.Error("error")
+			return
+		}
+		if client.String() != "resume(xx)" {
+			t.Error(client.String())
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package xnet
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// TLSDialer is RawDialer to dial tls connection over Dialer
+type TLSDialer struct {
+	Dialer  RawDialer
+	Config  *tls.Config
+	Timeout time.Duration
+}
+
+// NewTLSDialer will return new TLSDialer by config, the net.Dialer is used as raw dialer
+func NewTLSDialer(config *tls.Config) (dialer *TLSDialer) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	dialer = &TLSDialer{
+		Dialer:  &net.Dialer{},
+		Config:  config,
+		Timeout: 5 * time.Second,
+	}
+	return
+}
+
+// Dial will dial raw connection and do tls handshake, the ServerName is setted by address when it is empty
+func (t *TLSDialer) Dial(network, address string) (conn net.Conn, err error) {
+	conn, err = t.DialContext(context.Background(), network, address)
+	return
+}
+
+// DialContext will dial raw connection and do tls handshake with context, the handshake is limited by Timeout also
+func (t *TLSDialer) DialContext(ctx context.Context, network, address string) (conn net.Conn, err error) {
+	rawConn, err := DialRawContext(ctx, t.Dialer, network, address)
+	if err != nil {
+		return
+	}
+	config := t.Config.Clone()
+	if len(config.ServerName) < 1 {
+		host, _, xerr := net.SplitHostPort(address)
+		if xerr != nil {
+			host = address
+		}
+		config.ServerName = host
+	}
+	if t.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.Timeout)
+		defer cancel()
+	}
+	tlsConn := tls.Client(rawConn, config)
+	err = tlsConn.HandshakeContext(ctx)
+	conn = tlsConn
+	if err != nil {
+		rawConn.Close()
+		conn = nil
+	}
+	return
+}
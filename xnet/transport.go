@@ -1,6 +1,7 @@
 package xnet
 
 import (
+	"context"
 	"io"
 	"net/url"
 )
@@ -10,14 +11,17 @@ type Transporter interface {
 }
 
 func transportCopy(a, b io.ReadWriteCloser) (aerr, berr error) {
+	done := make(chan error, 1)
 	go func() {
-		_, berr = io.Copy(a, b)
+		_, err := io.Copy(a, b)
 		a.Close()
 		b.Close()
+		done <- err
 	}()
 	_, aerr = io.Copy(b, a)
 	a.Close()
 	b.Close()
+	berr = <-done
 	return
 }
 
@@ -37,12 +41,21 @@ func (w *WebsocketDialer) Transport(conn io.ReadWriteCloser, remote string) (err
 }
 
 func (d RawDialerF) Transport(conn io.ReadWriteCloser, remote string) (err error) {
+	remote, option, err := SplitLayerURI(remote)
+	if err != nil {
+		conn.Close()
+		return
+	}
 	u, err := url.Parse(remote)
 	if err != nil {
 		conn.Close()
 		return
 	}
-	raw, err := d.Dial(u.Scheme, u.Host)
+	var raw io.ReadWriteCloser
+	dialed, err := d.Dial(u.Scheme, u.Host)
+	if err == nil {
+		raw, err = ClientLayer(context.Background(), dialed, option)
+	}
 	if err != nil {
 		conn.Close()
 		return
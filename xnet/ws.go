@@ -1,6 +1,7 @@
 package xnet
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/base64"
 	"fmt"
@@ -33,6 +34,42 @@ func NewWebsocketDialer() (dialer *WebsocketDialer) {
 
 // Dial dial to remote by websocket
 func (w *WebsocketDialer) Dial(remote string) (raw io.ReadWriteCloser, err error) {
+	raw, err = w.DialContext(context.Background(), remote)
+	return
+}
+
+// DialContext dial to remote by websocket with context, the timeout query is used when ctx is not having deadline,
+// the connection is wrapped by layer when compress/psk query is set, it is removed from remote before dial, see SplitLayerURI
+func (w *WebsocketDialer) DialContext(ctx context.Context, remote string) (raw io.ReadWriteCloser, err error) {
+	remote, option, err := SplitLayerURI(remote)
+	if err != nil {
+		return
+	}
+	ctx, cancel := w.dialContext(ctx, remote)
+	defer cancel()
+	raw, err = w.dialWebsocket(ctx, remote)
+	if err == nil {
+		raw, err = ClientLayer(ctx, raw, option)
+	}
+	return
+}
+
+// dialContext will return ctx with timeout by timeout query when ctx is not having deadline
+func (w *WebsocketDialer) dialContext(ctx context.Context, remote string) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	var timeout uint64
+	if targetURL, err := url.Parse(remote); err == nil {
+		timeout, _ = strconv.ParseUint(targetURL.Query().Get("timeout"), 10, 32)
+	}
+	if timeout < 1 {
+		timeout = 5
+	}
+	return context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
+}
+
+func (w *WebsocketDialer) dialWebsocket(ctx context.Context, remote string) (raw io.ReadWriteCloser, err error) {
 	targetURL, err := url.Parse(remote)
 	if err != nil {
 		return
@@ -43,10 +80,6 @@ func (w *WebsocketDialer) Dial(remote string) (raw io.ReadWriteCloser, err error
 		password, _ = targetURL.User.Password()
 	}
 	skipVerify := targetURL.Query().Get("skip_verify") == "1" || w.TlsConfig.InsecureSkipVerify
-	timeout, _ := strconv.ParseUint(targetURL.Query().Get("timeout"), 10, 32)
-	if timeout < 1 {
-		timeout = 5
-	}
 	var origin string
 	if targetURL.Scheme == "wss" {
 		origin = fmt.Sprintf("https://%v", targetURL.Host)
@@ -71,7 +104,7 @@ func (w *WebsocketDialer) Dial(remote string) (raw io.ReadWriteCloser, err error
 			config.TlsConfig.ServerName = hostname
 		}
 		config.TlsConfig.InsecureSkipVerify = skipVerify
-		raw, err = w.dial(config, time.Duration(timeout)*time.Second)
+		raw, err = w.dial(ctx, config)
 	}
 	return
 }
@@ -90,34 +123,28 @@ func parseAuthority(location *url.URL) string {
 	return location.Host
 }
 
-func tlsHandshake(rawConn net.Conn, timeout time.Duration, config *tls.Config) (conn *tls.Conn, err error) {
-	errChannel := make(chan error, 2)
-	time.AfterFunc(timeout, func() {
-		errChannel <- fmt.Errorf("timeout")
-	})
-	conn = tls.Client(rawConn, config)
-	go func() {
-		errChannel <- conn.Handshake()
-	}()
-	err = <-errChannel
-	return
-}
-
-func (w *WebsocketDialer) dial(config *websocket.Config, timeout time.Duration) (conn net.Conn, err error) {
+func (w *WebsocketDialer) dial(ctx context.Context, config *websocket.Config) (conn net.Conn, err error) {
 	remote := parseAuthority(config.Location)
-	rawConn, err := w.Dialer.Dial("tcp", remote)
+	rawConn, err := DialRawContext(ctx, w.Dialer, "tcp", remote)
+	if err != nil {
+		return
+	}
+	stop := CloseOnDone(ctx, rawConn)
+	conn = rawConn
+	if config.Location.Scheme == "wss" {
+		tlsConn := tls.Client(rawConn, config.TlsConfig)
+		err = tlsConn.HandshakeContext(ctx)
+		conn = tlsConn
+	}
 	if err == nil {
-		if config.Location.Scheme == "wss" {
-			conn, err = tlsHandshake(rawConn, timeout, config.TlsConfig)
-		} else {
-			conn = rawConn
-		}
-		if err == nil {
-			conn, err = websocket.NewClient(config, conn)
-		}
-		if err != nil {
-			rawConn.Close()
-		}
+		conn, err = websocket.NewClient(config, conn)
+	}
+	if xerr := stop(); err == nil {
+		err = xerr
+	}
+	if err != nil {
+		rawConn.Close()
+		conn = nil
 	}
 	return
 }
@@ -0,0 +1,283 @@
+package xnet
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// ErrUnauthorized is the error when websocket request is not authenticated
+var ErrUnauthorized = fmt.Errorf("unauthorized")
+
+// Authenticator is interface to authenticate websocket request before upgrade
+type Authenticator interface {
+	Authenticate(req *http.Request) (err error)
+}
+
+// AuthenticatorF is func to implement Authenticator
+type AuthenticatorF func(req *http.Request) (err error)
+
+// Authenticate will authenticate request by func
+func (a AuthenticatorF) Authenticate(req *http.Request) (err error) {
+	err = a(req)
+	return
+}
+
+// BasicAuthenticator is Authenticator to check basic auth by username to password,
+// the username/password in query is checked also when Authorization header is not exists, it is sent by WebsocketDialer
+type BasicAuthenticator map[string]string
+
+// Authenticate will check basic auth of request
+func (b BasicAuthenticator) Authenticate(req *http.Request) (err error) {
+	username, password, ok := req.BasicAuth()
+	if !ok {
+		username, password = req.URL.Query().Get("username"), req.URL.Query().Get("password")
+	}
+	having, found := b[username]
+	if len(username) < 1 || !found || subtle.ConstantTimeCompare([]byte(having), []byte(password)) != 1 {
+		err = ErrUnauthorized
+	}
+	return
+}
+
+// BearerAuthenticator is Authenticator to check Authorization: Bearer token, it is sent by WebsocketDialer.HeaderGen
+type BearerAuthenticator []string
+
+// Authenticate will check bearer token of request
+func (b BearerAuthenticator) Authenticate(req *http.Request) (err error) {
+	auth := req.Header.Get("Authorization")
+	if len(auth) < 7 || !strings.EqualFold(auth[:7], "Bearer ") {
+		err = ErrUnauthorized
+		return
+	}
+	token := []byte(auth[7:])
+	for _, having := range b {
+		if subtle.ConstantTimeCompare([]byte(having), token) == 1 {
+			return
+		}
+	}
+	err = ErrUnauthorized
+	return
+}
+
+// HMACAuthenticator is Authenticator to check the query signed by SignURL, the expire is unix seconds
+// and the sign is hex of hmac-sha256 of path and encoded query without sign
+type HMACAuthenticator struct {
+	Key    []byte
+	MaxAge time.Duration
+}
+
+func hmacSign(key []byte, path string, query url.Values) string {
+	query.Del("sign")
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(path + "?" + query.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Authenticate will check the sign and expire of request query
+func (h *HMACAuthenticator) Authenticate(req *http.Request) (err error) {
+	query := req.URL.Query()
+	sign := query.Get("sign")
+	expire, xerr := strconv.ParseInt(query.Get("expire"), 10, 64)
+	if len(sign) < 1 || xerr != nil {
+		err = ErrUnauthorized
+		return
+	}
+	now := time.Now()
+	if now.Unix() > expire || (h.MaxAge > 0 && time.Unix(expire, 0).Sub(now) > h.MaxAge) {
+		err = fmt.Errorf("sign is expired")
+		return
+	}
+	if !hmac.Equal([]byte(sign), []byte(hmacSign(h.Key, req.URL.Path, query))) {
+		err = ErrUnauthorized
+	}
+	return
+}
+
+// SignURL will sign the uri by key and add expire/sign to query, it is checked by HMACAuthenticator
+func SignURL(key []byte, uri string, expire time.Time) (signed string, err error) {
+	target, err := url.Parse(uri)
+	if err != nil {
+		return
+	}
+	query := target.Query()
+	query.Set("expire", fmt.Sprintf("%v", expire.Unix()))
+	query.Set("sign", hmacSign(key, target.Path, query))
+	target.RawQuery = query.Encode()
+	signed = target.String()
+	return
+}
+
+// MultiAuthenticator is Authenticator to pass request when any of Authenticator is passed
+type MultiAuthenticator []Authenticator
+
+// Authenticate will check request by all Authenticator and return the first error when all fail
+func (m MultiAuthenticator) Authenticate(req *http.Request) (err error) {
+	err = ErrUnauthorized
+	for i, auth := range m {
+		xerr := auth.Authenticate(req)
+		if xerr == nil {
+			err = nil
+			break
+		}
+		if i == 0 {
+			err = xerr
+		}
+	}
+	return
+}
+
+// websocketAddr is net.Addr of WebsocketListener when it is not served on listener
+type websocketAddr string
+
+func (w websocketAddr) Network() string {
+	return "websocket"
+}
+
+func (w websocketAddr) String() string {
+	return string(w)
+}
+
+// WebsocketConn is authenticated websocket connection accepted by WebsocketListener
+type WebsocketConn struct {
+	*websocket.Conn
+	done   chan int
+	closer sync.Once
+}
+
+// Close will close the websocket connection
+func (w *WebsocketConn) Close() (err error) {
+	w.closer.Do(func() {
+		close(w.done)
+	})
+	err = w.Conn.Close()
+	return
+}
+
+// WebsocketListener is http.Handler to upgrade the authenticated request to websocket and accept it as net.Conn by Accept,
+// so the connection can be served by any xio.Processor, the origin is checked by AllowOrigins when it is not empty, "*" is allowed all.
+type WebsocketListener struct {
+	Auth         Authenticator
+	AllowOrigins []string
+	Listener     net.Listener
+	queue        chan *WebsocketConn
+	done         chan int
+	closer       sync.Once
+	lock         sync.RWMutex
+}
+
+// NewWebsocketListener will return new WebsocketListener by authenticator, it is not authenticated when auth is nil
+func NewWebsocketListener(auth Authenticator) (listener *WebsocketListener) {
+	listener = &WebsocketListener{
+		Auth:  auth,
+		queue: make(chan *WebsocketConn),
+		done:  make(chan int),
+	}
+	return
+}
+
+// CheckOrigin will check the Origin header by AllowOrigins
+func (w *WebsocketListener) CheckOrigin(req *http.Request) (err error) {
+	if len(w.AllowOrigins) < 1 {
+		return
+	}
+	origin := req.Header.Get("Origin")
+	for _, allow := range w.AllowOrigins {
+		if allow == "*" || strings.EqualFold(allow, origin) {
+			return
+		}
+	}
+	err = fmt.Errorf("origin %v is not allowed", origin)
+	return
+}
+
+func (w *WebsocketListener) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	if err := w.CheckOrigin(req); err != nil {
+		resp.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(resp, "%v", err)
+		return
+	}
+	if w.Auth != nil {
+		if err := w.Auth.Authenticate(req); err != nil {
+			resp.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprintf(resp, "%v", err)
+			return
+		}
+	}
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, req *http.Request) (err error) {
+			config.Origin, _ = websocket.Origin(config, req)
+			return
+		},
+		Handler: w.handle,
+	}
+	server.ServeHTTP(resp, req)
+}
+
+func (w *WebsocketListener) handle(raw *websocket.Conn) {
+	raw.PayloadType = websocket.BinaryFrame
+	conn := &WebsocketConn{Conn: raw, done: make(chan int)}
+	select {
+	case w.queue <- conn:
+	case <-w.done:
+		return
+	}
+	select {
+	case <-conn.done:
+	case <-w.done:
+	}
+}
+
+// Accept will wait the authenticated websocket connection, it is net.Listener implement
+func (w *WebsocketListener) Accept() (conn net.Conn, err error) {
+	select {
+	case c := <-w.queue:
+		conn = c
+	case <-w.done:
+		err = net.ErrClosed
+	}
+	return
+}
+
+// Serve will serve http on listener and upgrade request to websocket, the Listener is closed when WebsocketListener is closed
+func (w *WebsocketListener) Serve(listener net.Listener) (err error) {
+	w.lock.Lock()
+	w.Listener = listener
+	w.lock.Unlock()
+	err = http.Serve(listener, w)
+	return
+}
+
+// Close will close the listener and all connection which is not closed, it is net.Listener implement
+func (w *WebsocketListener) Close() (err error) {
+	w.closer.Do(func() {
+		close(w.done)
+		w.lock.RLock()
+		if w.Listener != nil {
+			err = w.Listener.Close()
+		}
+		w.lock.RUnlock()
+	})
+	return
+}
+
+// Addr will return the address of served Listener, it is net.Listener implement
+func (w *WebsocketListener) Addr() net.Addr {
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if w.Listener != nil {
+		return w.Listener.Addr()
+	}
+	return websocketAddr("websocket")
+}
@@ -0,0 +1,173 @@
+package xnet
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebsocketListener(t *testing.T) {
+	key := []byte("123")
+	auth := MultiAuthenticator{
+		BasicAuthenticator{"u1": "p1"},
+		BearerAuthenticator{"t1"},
+		&HMACAuthenticator{Key: key, MaxAge: time.Hour},
+	}
+	ws := NewWebsocketListener(auth)
+	raw, _ := net.Listen("tcp", "127.0.0.1:0")
+	go ws.Serve(raw)
+	defer ws.Close()
+	go func() {
+		for {
+			conn, err := ws.Accept()
+			if err != nil {
+				break
+			}
+			go io.Copy(conn, conn)
+		}
+	}()
+	address := raw.Addr().String()
+	testEcho := func(dialer *WebsocketDialer, remote string) (err error) {
+		conn, err := dialer.Dial(remote)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "abc")
+		buf := make([]byte, 3)
+		if _, err = io.ReadFull(conn, buf); err == nil && string(buf) != "abc" {
+			err = fmt.Errorf("%v", string(buf))
+		}
+		return
+	}
+	dialer := NewWebsocketDialer()
+	{ //basic
+		if err := testEcho(dialer, "ws://u1:p1@"+address+"/ws"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := testEcho(dialer, "ws://"+address+"/ws?username=u1&password=p1"); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := testEcho(dialer, "ws://u1:xx@"+address+"/ws"); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //bearer
+		bearer := NewWebsocketDialer()
+		bearer.HeaderGen = func(remote string) http.Header {
+			return http.Header{"Authorization": []string{"Bearer t1"}}
+		}
+		if err := testEcho(bearer, "ws://"+address+"/ws"); err != nil {
+			t.Error(err)
+			return
+		}
+		bearer.HeaderGen = func(remote string) http.Header {
+			return http.Header{"Authorization": []string{"Bearer xx"}}
+		}
+		if err := testEcho(bearer, "ws://"+address+"/ws"); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //hmac
+		signed, _ := SignURL(key, "ws://"+address+"/ws?a=1", time.Now().Add(time.Minute))
+		if err := testEcho(dialer, signed); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := testEcho(dialer, strings.Replace(signed, "a=1", "a=2", 1)); err == nil {
+			t.Error(err)
+			return
+		}
+		expired, _ := SignURL(key, "ws://"+address+"/ws", time.Now().Add(-time.Minute))
+		if err := testEcho(dialer, expired); err == nil {
+			t.Error(err)
+			return
+		}
+		tooLong, _ := SignURL(key, "ws://"+address+"/ws", time.Now().Add(2*time.Hour))
+		if err := testEcho(dialer, tooLong); err == nil {
+			t.Error(err)
+			return
+		}
+		wrongKey, _ := SignURL([]byte("xx"), "ws://"+address+"/ws", time.Now().Add(time.Minute))
+		if err := testEcho(dialer, wrongKey); err == nil {
+			t.Error(err)
+			return
+		}
+		if err := testEcho(dialer, "ws://"+address+"/ws?expire=xx&sign=xx"); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //origin
+		ws.AllowOrigins = []string{"http://" + address}
+		if err := testEcho(dialer, "ws://u1:p1@"+address+"/ws"); err != nil {
+			t.Error(err)
+			return
+		}
+		ws.AllowOrigins = []string{"http://127.0.0.1"}
+		if err := testEcho(dialer, "ws://u1:p1@"+address+"/ws"); err == nil {
+			t.Error(err)
+			return
+		}
+		ws.AllowOrigins = []string{"*"}
+		if err := testEcho(dialer, "ws://u1:p1@"+address+"/ws"); err != nil {
+			t.Error(err)
+			return
+		}
+	}
+	{ //func
+		if err := AuthenticatorF(func(req *http.Request) error { return nil }).Authenticate(nil); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := SignURL(key, "%zz://xx", time.Now()); err == nil {
+			t.Error(err)
+			return
+		}
+	}
+}
+
+func TestWebsocketListenerClose(t *testing.T) {
+	ws := NewWebsocketListener(nil)
+	if ws.Addr().Network() != "websocket" || len(ws.Addr().String()) < 1 {
+		t.Error(ws.Addr())
+		return
+	}
+	raw, _ := net.Listen("tcp", "127.0.0.1:0")
+	served := make(chan error, 1)
+	go func() {
+		served <- ws.Serve(raw)
+	}()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, _ := ws.Accept()
+		accepted <- conn
+	}()
+	conn, err := NewWebsocketDialer().Dial("ws://" + raw.Addr().String() + "/ws")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	<-accepted
+	ws.Close()
+	if _, err = conn.Read(make([]byte, 1)); err == nil {
+		t.Error(err)
+		return
+	}
+	if _, err = ws.Accept(); err == nil {
+		t.Error(err)
+		return
+	}
+	if err = <-served; err == nil {
+		t.Error(err)
+		return
+	}
+}